@@ -73,6 +73,7 @@ func RootCommand() *cobra.Command {
 		mountCommand(),
 		unmountCommand(),
 		migrateCommand(),
+		configCommand(),
 	)
 
 	return command
@@ -0,0 +1,90 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/kos-v/dbunderfs/internal/db"
+	dbFactory "github.com/kos-v/dbunderfs/internal/factory/db"
+	"github.com/kos-v/dsnparser"
+	"github.com/spf13/cobra"
+	"sort"
+	"strings"
+)
+
+func configCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "config DSN [NAME=VALUE...]",
+		Short: "Shows or changes the settings stored with a file system database",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runConfig(args[0], args[1:])
+		},
+	}
+
+	return command
+}
+
+// runConfig stores the settings, or prints the stored ones if no settings are given.
+func runConfig(dsn string, assignments []string) error {
+	dbInstance, err := dbFactory.CreateInstance(*dsnparser.Parse(dsn))
+	if err != nil {
+		return err
+	}
+	defer dbInstance.Close()
+
+	ctx := context.Background()
+	settings, err := dbFactory.LoadVolumeSettings(ctx, dbInstance)
+	if err != nil {
+		return err
+	}
+	repositoryRegistry, err := dbFactory.CreateRepositoryRegistry(dbInstance, settings)
+	if err != nil {
+		return err
+	}
+
+	if len(assignments) == 0 {
+		values, err := repositoryRegistry.GetSettingRepository().FindAll(ctx)
+		if err != nil {
+			return err
+		}
+
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s=%s\n", name, values[name])
+		}
+
+		return nil
+	}
+
+	for _, assignment := range assignments {
+		parts := strings.SplitN(assignment, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid setting %q. Should be NAME=VALUE", assignment)
+		}
+		if err := db.SaveVolumeSetting(ctx, repositoryRegistry, parts[0], parts[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
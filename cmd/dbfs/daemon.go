@@ -0,0 +1,158 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// daemonStatusEnv holds the descriptor of the pipe the detached process reports the result of the mount to.
+const daemonStatusEnv = "DBFS_DAEMON_STATUS_FD"
+
+const daemonStatusReady = "ready"
+
+// startDaemon starts a detached copy of the process and waits until it reports that the volume
+// is mounted. The errors of the mount are returned, so the exit code of the parent is correct.
+func startDaemon() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	statusReader, statusWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer statusReader.Close()
+
+	// The daemon starts in the working directory of the caller, so the relative paths of the arguments
+	// resolve as they do for a mount in the foreground. It leaves the directory once the volume is mounted.
+	cmd := exec.Command(executable)
+	// The name is kept, as the process may have been started by mount(8) as a mount helper.
+	cmd.Args = os.Args
+	cmd.Env = append(os.Environ(), daemonStatusEnv+"=3")
+	cmd.ExtraFiles = []*os.File{statusWriter}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		statusWriter.Close()
+		return err
+	}
+	statusWriter.Close()
+
+	status, err := ioutil.ReadAll(statusReader)
+	if err != nil {
+		return err
+	}
+	if string(status) == daemonStatusReady {
+		return cmd.Process.Release()
+	}
+
+	waitErr := cmd.Wait()
+	if len(status) > 0 {
+		return errors.New(string(status))
+	}
+	return fmt.Errorf("daemon exited before mounting: %v", waitErr)
+}
+
+// daemonStatus reports the result of the mount to the parent waiting in startDaemon.
+// It is nil in the processes that are not started by startDaemon.
+type daemonStatus struct {
+	pipe *os.File
+}
+
+func newDaemonStatus() *daemonStatus {
+	fd, err := strconv.Atoi(os.Getenv(daemonStatusEnv))
+	if err != nil {
+		return nil
+	}
+	// The pipe must not be inherited by fusermount, which the mount runs.
+	syscall.CloseOnExec(fd)
+
+	return &daemonStatus{pipe: os.NewFile(uintptr(fd), "daemon-status")}
+}
+
+// ready tells the parent that the volume is mounted and lets it exit.
+func (s *daemonStatus) ready() {
+	s.report(daemonStatusReady)
+}
+
+// fail passes the error of the mount to the parent. It has no effect once the volume is mounted.
+func (s *daemonStatus) fail(err error) {
+	if err != nil {
+		s.report(err.Error())
+	}
+}
+
+func (s *daemonStatus) report(status string) {
+	if s == nil || s.pipe == nil {
+		return
+	}
+
+	_, _ = s.pipe.WriteString(status)
+	s.pipe.Close()
+	s.pipe = nil
+}
+
+// writePidfile writes the pid of the process to the file and returns the function that removes it.
+// The pid is written to a temporary file that is linked to the path, so the pidfile appears with its
+// content at once and only one of the processes started together gets it. A pidfile left by a process
+// that is not running anymore is removed before the retry.
+func writePidfile(path string) (func(), error) {
+	if path == "" {
+		return func() {}, nil
+	}
+
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := ioutil.WriteFile(tmp, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+
+	for {
+		err := os.Link(tmp, path)
+		if err == nil {
+			return func() { _ = os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		// Kill with a pid below 1 signals process groups, such a pidfile is treated as stale.
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		if pid > 0 {
+			if err := syscall.Kill(pid, 0); err == nil || err == syscall.EPERM {
+				return nil, fmt.Errorf("pidfile %s belongs to the running process %d", path, pid)
+			}
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
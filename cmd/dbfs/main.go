@@ -18,11 +18,23 @@ package main
 
 import (
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func main() {
-	err := RootCommand().Execute()
+	command := RootCommand()
+
+	// mount(8) runs the binary installed as mount.dbfs with "DSN POINT -o options" for volumes of fstab
+	// and waits for it to exit, so the mount is detached unless the foreground option is given.
+	if strings.HasPrefix(filepath.Base(os.Args[0]), "mount.") {
+		command.SetArgs(append([]string{"mount", "--daemon"}, os.Args[1:]...))
+	}
+
+	err := command.Execute()
 	if err != nil {
 		os.Exit(1)
 	}
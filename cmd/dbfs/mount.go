@@ -17,34 +17,162 @@
 package main
 
 import (
+	"bazil.org/fuse"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"github.com/kos-v/dbunderfs/internal/db/sqlite"
 	dbFactory "github.com/kos-v/dbunderfs/internal/factory/db"
 	"github.com/kos-v/dbunderfs/internal/fs"
+	"github.com/kos-v/dbunderfs/internal/log"
+	"github.com/kos-v/dbunderfs/internal/mount"
 	"github.com/kos-v/dsnparser"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 type mountOpts struct {
-	dsn   string
-	point string
+	allowOther      bool
+	allowRoot       bool
+	atime           string
+	cacheTTL        time.Duration
+	daemon          bool
+	defaultPerms    bool
+	directIO        bool
+	dsn             string
+	fake            bool
+	foreground      bool
+	fsName          string
+	gid             int64
+	logFile         string
+	maxReadahead    uint32
+	opTimeout       time.Duration
+	options         []string
+	pidfile         string
+	point           string
+	readOnly        bool
+	retries         int
+	retryBackoff    time.Duration
+	retryMaxBackoff time.Duration
+	sloppy          bool
+	statfsTTL       time.Duration
+	subtype         string
+	syslog          bool
+	uid             int64
+	umask           string
+	writeBuffer     int
 }
 
 func mountCommand() *cobra.Command {
+	opts := mountOpts{}
 	command := &cobra.Command{
-		Use:   "mount POINT DSN",
+		Use:   "mount DSN POINT",
 		Short: "Mounts database FS to a specified mount point",
 		Args:  cobra.MinimumNArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runMount(mountOpts{
-				dsn:   args[0],
-				point: args[1],
-			})
+			opts.dsn = args[0]
+			opts.point = args[1]
+
+			if err := mount.ApplyOptions(cmd, opts.options, opts.sloppy); err != nil {
+				return err
+			}
+			if opts.fake {
+				return nil
+			}
+
+			status := newDaemonStatus()
+			if status == nil && opts.daemon && !opts.foreground {
+				return startDaemon()
+			}
+			err := runMount(opts, status)
+			status.fail(err)
+
+			return err
 		},
 	}
 
+	command.Flags().StringVar(&opts.atime, "atime", string(fs.AtimeRelative), "Access time update mode: strict, relatime or noatime")
+	command.Flags().DurationVar(&opts.cacheTTL, "cache-ttl", time.Second, "Time for which descriptors and directory entries are cached, e.g. 5s. 0 disables caching")
+	command.Flags().IntVar(&opts.retries, "retries", 3, "Number of repeats of a database operation failed with a transient error. 0 disables retries")
+	command.Flags().DurationVar(&opts.retryBackoff, "retry-backoff", 50*time.Millisecond, "Delay before the first repeat of a failed operation, doubled with each repeat")
+	command.Flags().DurationVar(&opts.retryMaxBackoff, "retry-max-backoff", 2*time.Second, "Maximum delay between repeats of a failed operation")
+	command.Flags().BoolVar(&opts.directIO, "direct-io", true, "Bypass the page cache of the kernel. Disable for volumes mounted by a single writer")
+	command.Flags().BoolVar(&opts.defaultPerms, "default-permissions", false, "Let the kernel check the permissions instead of the filesystem")
+	command.Flags().IntVar(&opts.writeBuffer, "write-buffer", 1024*1024, "Size in bytes of the data buffered by an open file before it is written to the database. 0 disables buffering")
+	command.Flags().DurationVar(&opts.statfsTTL, "statfs-ttl", 30*time.Second, "Time for which the usage of the volume reported by statfs is cached")
+	command.Flags().DurationVar(&opts.opTimeout, "op-timeout", 0, "Maximum duration of the database queries of a filesystem operation, e.g. 30s. 0 disables the limit")
+	command.Flags().BoolVar(&opts.readOnly, "read-only", false, "Mount the volume read-only")
+	command.Flags().BoolVar(&opts.allowOther, "allow-other", false, "Allow access to the mount to all the users. Requires user_allow_other in /etc/fuse.conf for non-root users")
+	command.Flags().BoolVar(&opts.allowRoot, "allow-root", false, "Allow access to the mount to root besides the user who mounted it")
+	command.Flags().Int64Var(&opts.uid, "uid", -1, "Report all the entries as owned by the user. -1 reports the stored owners")
+	command.Flags().Int64Var(&opts.gid, "gid", -1, "Report all the entries as owned by the group. -1 reports the stored groups")
+	command.Flags().StringVar(&opts.umask, "umask", "0", "Octal mask of the permission bits cleared for all the entries, e.g. 022")
+	command.Flags().StringVar(&opts.fsName, "fsname", "dbfs", "Source of the mount shown in the mount table")
+	command.Flags().StringVar(&opts.subtype, "subtype", "dbfs", "Type of the mount shown in the mount table as fuse.<subtype>")
+	command.Flags().Uint32Var(&opts.maxReadahead, "max-readahead", 0, "Maximum readahead of the kernel in bytes. 0 keeps the default of the kernel")
+	command.Flags().BoolVar(&opts.daemon, "daemon", false, "Detach from the terminal once the volume is mounted")
+	command.Flags().BoolVar(&opts.foreground, "foreground", false, "Stay in the foreground, overrides --daemon")
+	command.Flags().StringVar(&opts.pidfile, "pidfile", "", "File to write the pid of the mount process to")
+	command.Flags().StringVar(&opts.logFile, "log-file", "", "File to append the log to instead of stdout")
+	command.Flags().BoolVar(&opts.syslog, "syslog", false, "Send the log to syslog instead of stdout. The default of --daemon")
+	command.Flags().StringArrayVarP(&opts.options, "options", "o", nil, "Comma separated options in the format of mount(8), e.g. ro,allow_other,uid=1000")
+
+	// The arguments of mount(8) passed to the mount helpers.
+	command.Flags().BoolVarP(&opts.sloppy, "sloppy", "s", false, "Ignore unknown options")
+	command.Flags().BoolVarP(&opts.fake, "fake", "f", false, "Check the options without mounting")
+	command.Flags().BoolP("no-mtab", "n", false, "Ignored")
+	command.Flags().BoolP("verbose", "v", false, "Ignored")
+	command.Flags().StringP("type", "t", "", "Ignored")
+	for _, name := range []string{"sloppy", "fake", "no-mtab", "verbose", "type"} {
+		_ = command.Flags().MarkHidden(name)
+	}
+
 	return command
 }
 
-func runMount(opts mountOpts) error {
+func runMount(opts mountOpts, status *daemonStatus) error {
+	if err := absolutePaths(&opts); err != nil {
+		return err
+	}
+
+	atime, err := fs.ParseAtimeMode(opts.atime)
+	if err != nil {
+		return err
+	}
+	if opts.allowOther && opts.allowRoot {
+		return errors.New("allow-other and allow-root are mutually exclusive")
+	}
+	uid, err := parseID("uid", opts.uid)
+	if err != nil {
+		return err
+	}
+	gid, err := parseID("gid", opts.gid)
+	if err != nil {
+		return err
+	}
+	umask, err := strconv.ParseUint(opts.umask, 8, 32)
+	if err != nil || umask > 0777 {
+		return fmt.Errorf("invalid umask %q. Should be an octal number from 0 to 777", opts.umask)
+	}
+
+	// The options are checked before the log is redirected, so their errors reach the terminal.
+	if err := redirectLog(opts, status != nil); err != nil {
+		return err
+	}
+	removePidfile, err := writePidfile(opts.pidfile)
+	if err != nil {
+		return err
+	}
+	defer removePidfile()
+
 	dsn := dsnparser.Parse(opts.dsn)
 
 	dbInstance, err := dbFactory.CreateInstance(*dsn)
@@ -53,10 +181,130 @@ func runMount(opts mountOpts) error {
 	}
 	defer dbInstance.Close()
 
-	repositoryRegistry, err := dbFactory.CreateRepositoryRegistry(dbInstance)
+	dbInstance, err = dbFactory.CreateRetryInstance(dbInstance, db.RetryPolicy{
+		MaxRetries: opts.retries,
+		Backoff:    opts.retryBackoff,
+		MaxBackoff: opts.retryMaxBackoff,
+	})
+	if err != nil {
+		return err
+	}
+
+	settings, err := dbFactory.LoadVolumeSettings(context.Background(), dbInstance)
 	if err != nil {
 		return err
 	}
 
-	return fs.Mount(opts.point, repositoryRegistry)
+	repositoryRegistry, err := dbFactory.CreateRepositoryRegistry(dbInstance, settings)
+	if err != nil {
+		return err
+	}
+
+	if opts.cacheTTL > 0 {
+		repositoryRegistry = db.NewCachedRepositoryRegistry(repositoryRegistry, opts.cacheTTL, settings.CaseInsensitive)
+	}
+
+	return fs.Mount(opts.point, repositoryRegistry, fs.Options{
+		Atime:              atime,
+		CacheTTL:           opts.cacheTTL,
+		DefaultPermissions: opts.defaultPerms,
+		DirectIO:           opts.directIO,
+		Limits:             settings.Limits,
+		OpTimeout:          opts.opTimeout,
+		StatfsTTL:          opts.statfsTTL,
+		WriteBuffer:        opts.writeBuffer,
+		ReadOnly:           opts.readOnly,
+		AllowOther:         opts.allowOther,
+		AllowRoot:          opts.allowRoot,
+		UID:                uid,
+		GID:                gid,
+		Umask:              os.FileMode(umask),
+		FSName:             opts.fsName,
+		Subtype:            opts.subtype,
+		MaxReadahead:       opts.maxReadahead,
+		OnReady: func() {
+			unmountOnSignal(opts.point)
+			if status != nil {
+				// The daemon must not keep the directory it was started from busy.
+				if err := os.Chdir("/"); err != nil {
+					logrus.Warnf("Error leaving the working directory: %s", err.Error())
+				}
+			}
+			status.ready()
+		},
+	})
+}
+
+// absolutePaths resolves the mount point, the pidfile, the log file and the path of an sqlite database
+// against the working directory, which the detached process leaves once the volume is mounted.
+func absolutePaths(opts *mountOpts) error {
+	for _, path := range []*string{&opts.point, &opts.pidfile, &opts.logFile} {
+		if *path == "" {
+			continue
+		}
+		abs, err := filepath.Abs(*path)
+		if err != nil {
+			return err
+		}
+		*path = abs
+	}
+
+	dsn := dsnparser.Parse(opts.dsn)
+	if dsn.GetScheme() != "sqlite" {
+		return nil
+	}
+	database := (&sqlite.DSN{ParsedDSN: *dsn}).GetDatabase()
+	if database == "" || filepath.IsAbs(database) {
+		return nil
+	}
+	abs, err := filepath.Abs(database)
+	if err != nil {
+		return err
+	}
+	start := strings.Index(opts.dsn, "://") + 3
+	opts.dsn = opts.dsn[:start] + abs + opts.dsn[start+len(database):]
+
+	return nil
+}
+
+// redirectLog sends the log to the file or syslog. A detached process logs to syslog by default,
+// as its stdout is closed.
+func redirectLog(opts mountOpts, detached bool) error {
+	switch {
+	case opts.logFile != "":
+		return log.RedirectToFile(opts.logFile)
+	case opts.syslog || detached:
+		return log.RedirectToSyslog("dbfs")
+	}
+
+	return nil
+}
+
+// unmountOnSignal unmounts the volume on SIGINT and SIGTERM, so the mount process exits
+// once the kernel closes the connection.
+func unmountOnSignal(point string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		for sig := range signals {
+			logrus.Infof("Received %s, unmounting %s", sig, point)
+			if err := fuse.Unmount(point); err != nil {
+				logrus.Errorf("Error unmounting %s: %s", point, err.Error())
+			}
+		}
+	}()
+}
+
+// parseID returns the forced uid or gid, or nil for -1.
+func parseID(name string, id int64) (*uint32, error) {
+	if id == -1 {
+		return nil, nil
+	}
+	if id < 0 || id >= 1<<32-1 {
+		return nil, fmt.Errorf("invalid %s %d. Should be a number from 0 to %d or -1", name, id, uint32(1<<32-2))
+	}
+
+	value := uint32(id)
+	return &value, nil
 }
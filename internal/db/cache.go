@@ -0,0 +1,506 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"context"
+	"github.com/kos-v/dbunderfs/internal/container"
+	"sync"
+	"time"
+)
+
+// cachePurgeInterval is the number of insertions after which expired entries are purged.
+const cachePurgeInterval = 1024
+
+type cachedDescriptor struct {
+	descr   DescriptorInterface
+	expires time.Time
+}
+
+type cacheNameKey struct {
+	parent Inode
+	name   string
+}
+
+// cachedName maps a directory entry to its inode and the name it is stored with. Zero inode
+// is a negative entry.
+type cachedName struct {
+	inode   Inode
+	name    string
+	expires time.Time
+}
+
+type cachedChild struct {
+	inode Inode
+	name  string
+}
+
+type cachedChildren struct {
+	children []cachedChild
+	expires  time.Time
+}
+
+// entryDescriptor is a descriptor seen through one of its directory entries. Hard links share
+// the attributes of the origin descriptor, but have their own parent and name.
+type entryDescriptor struct {
+	DescriptorInterface
+	parent Inode
+	name   string
+}
+
+func (d *entryDescriptor) GetName() string {
+	return d.name
+}
+
+func (d *entryDescriptor) GetParent() Inode {
+	return d.parent
+}
+
+// DescriptorCache keeps descriptors, directory entries (including missing ones) and children listings
+// for the TTL. Entries and listings only refer to inodes, the attributes are always taken from the
+// descriptors, so changing a descriptor requires the invalidation of its inode only.
+// Entries are keyed by NameKey, so on case-insensitive volumes all cases of a name share one entry.
+type DescriptorCache struct {
+	TTL             time.Duration
+	CaseInsensitive bool
+
+	mu          sync.Mutex
+	descriptors map[Inode]cachedDescriptor
+	names       map[cacheNameKey]cachedName
+	children    map[Inode]cachedChildren
+	// generation is increased by each invalidation. Results of queries started before an invalidation
+	// are not cached, as they may miss the change.
+	generation uint64
+	inserts    int
+}
+
+func (c *DescriptorCache) getGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+func (c *DescriptorCache) findDescriptor(inode Inode, now time.Time) DescriptorInterface {
+	if cached, ok := c.descriptors[inode]; ok && now.Before(cached.expires) {
+		return cached.descr
+	}
+
+	return nil
+}
+
+func (c *DescriptorCache) getDescriptor(inode Inode) DescriptorInterface {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.findDescriptor(inode, time.Now())
+}
+
+// getEntry returns the cached descriptor of the directory entry. The found result is false
+// if the entry is unknown. The descriptor is nil for a negative entry.
+func (c *DescriptorCache) getEntry(parent Inode, name string) (DescriptorInterface, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	cached, ok := c.names[c.nameKey(parent, name)]
+	if !ok || !now.Before(cached.expires) {
+		return nil, false
+	}
+	if cached.inode == 0 {
+		return nil, true
+	}
+
+	descr := c.findDescriptor(cached.inode, now)
+	if descr == nil {
+		return nil, false
+	}
+
+	return &entryDescriptor{DescriptorInterface: descr, parent: parent, name: cached.name}, true
+}
+
+func (c *DescriptorCache) getChildren(parent Inode) (container.CollectionInterface, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	cached, ok := c.children[parent]
+	if !ok || !now.Before(cached.expires) {
+		return nil, false
+	}
+
+	collection := &container.Collection{}
+	for _, child := range cached.children {
+		descr := c.findDescriptor(child.inode, now)
+		if descr == nil {
+			return nil, false
+		}
+		collection.Append(&entryDescriptor{DescriptorInterface: descr, parent: parent, name: child.name})
+	}
+
+	return collection, true
+}
+
+func (c *DescriptorCache) putDescriptor(generation uint64, descr DescriptorInterface) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	c.storeDescriptor(descr, time.Now().Add(c.TTL))
+}
+
+// putEntry caches the result of a lookup. A nil descriptor is cached as a negative entry.
+func (c *DescriptorCache) putEntry(generation uint64, parent Inode, name string, descr DescriptorInterface) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	c.initMaps()
+	expires := time.Now().Add(c.TTL)
+	entry := cachedName{expires: expires}
+	if descr != nil {
+		entry.inode = descr.GetInode()
+		entry.name = descr.GetName()
+		c.storeDescriptor(descr, expires)
+	}
+	c.names[c.nameKey(parent, name)] = entry
+	c.inserted()
+}
+
+func (c *DescriptorCache) putChildren(generation uint64, parent Inode, collection container.CollectionInterface) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	c.initMaps()
+	expires := time.Now().Add(c.TTL)
+	listing := cachedChildren{expires: expires}
+	for _, item := range collection.ToList() {
+		descr := item.(DescriptorInterface)
+		listing.children = append(listing.children, cachedChild{inode: descr.GetInode(), name: descr.GetName()})
+		c.names[c.nameKey(parent, descr.GetName())] = cachedName{inode: descr.GetInode(), name: descr.GetName(), expires: expires}
+		c.storeDescriptor(descr, expires)
+	}
+	c.children[parent] = listing
+	c.inserted()
+}
+
+func (c *DescriptorCache) nameKey(parent Inode, name string) cacheNameKey {
+	return cacheNameKey{parent: parent, name: NameKey(name, c.CaseInsensitive)}
+}
+
+func (c *DescriptorCache) initMaps() {
+	if c.descriptors == nil {
+		c.descriptors = make(map[Inode]cachedDescriptor)
+		c.names = make(map[cacheNameKey]cachedName)
+		c.children = make(map[Inode]cachedChildren)
+	}
+}
+
+// storeDescriptor caches the attributes of the descriptor. The name of a descriptor found by
+// its inode is the name of any of its entries, which is enough for the attribute requests.
+func (c *DescriptorCache) storeDescriptor(descr DescriptorInterface, expires time.Time) {
+	c.initMaps()
+	if entry, ok := descr.(*entryDescriptor); ok {
+		descr = entry.DescriptorInterface
+	}
+	c.descriptors[descr.GetInode()] = cachedDescriptor{descr: descr, expires: expires}
+	c.inserted()
+}
+
+func (c *DescriptorCache) inserted() {
+	c.inserts++
+	if c.inserts < cachePurgeInterval {
+		return
+	}
+	c.inserts = 0
+
+	now := time.Now()
+	for inode, cached := range c.descriptors {
+		if !now.Before(cached.expires) {
+			delete(c.descriptors, inode)
+		}
+	}
+	for key, cached := range c.names {
+		if !now.Before(cached.expires) {
+			delete(c.names, key)
+		}
+	}
+	for inode, cached := range c.children {
+		if !now.Before(cached.expires) {
+			delete(c.children, inode)
+		}
+	}
+}
+
+// InvalidateInode drops the cached descriptor of the inode and the listing of its children.
+func (c *DescriptorCache) InvalidateInode(inode Inode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	delete(c.descriptors, inode)
+	delete(c.children, inode)
+}
+
+// InvalidateEntry drops the directory entry together with the descriptor it points to,
+// the descriptor of the parent and the listing of the parent.
+func (c *DescriptorCache) InvalidateEntry(parent Inode, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	key := c.nameKey(parent, name)
+	if cached, ok := c.names[key]; ok && cached.inode != 0 {
+		delete(c.descriptors, cached.inode)
+		delete(c.children, cached.inode)
+	}
+	delete(c.names, key)
+	delete(c.descriptors, parent)
+	delete(c.children, parent)
+}
+
+// CachedDescriptorRepository serves lookups from the cache and invalidates it on local changes.
+// Changes made by other clients of the database become visible after the TTL.
+type CachedDescriptorRepository struct {
+	Repository DescriptorRepository
+	Cache      *DescriptorCache
+}
+
+func (r *CachedDescriptorRepository) Create(ctx context.Context, parent Inode, name string, dType DescriptorType, attrs DescriptorAttrs) (DescriptorInterface, error) {
+	defer r.Cache.InvalidateEntry(parent, name)
+
+	return r.Repository.Create(ctx, parent, name, dType, attrs)
+}
+
+func (r *CachedDescriptorRepository) CreateSymlink(ctx context.Context, parent Inode, name string, target string, attrs DescriptorAttrs) (DescriptorInterface, error) {
+	defer r.Cache.InvalidateEntry(parent, name)
+
+	return r.Repository.CreateSymlink(ctx, parent, name, target, attrs)
+}
+
+func (r *CachedDescriptorRepository) FindChildrenByInode(ctx context.Context, parentInode Inode) (container.CollectionInterface, error) {
+	if collection, ok := r.Cache.getChildren(parentInode); ok {
+		return collection, nil
+	}
+
+	generation := r.Cache.getGeneration()
+	collection, err := r.Repository.FindChildrenByInode(ctx, parentInode)
+	if err != nil {
+		return nil, err
+	}
+	r.Cache.putChildren(generation, parentInode, collection)
+
+	return collection, nil
+}
+
+func (r *CachedDescriptorRepository) FindRoot(ctx context.Context) (DescriptorInterface, error) {
+	return r.Repository.FindRoot(ctx)
+}
+
+func (r *CachedDescriptorRepository) FindSingleByInode(ctx context.Context, inode Inode) (DescriptorInterface, error) {
+	if descr := r.Cache.getDescriptor(inode); descr != nil {
+		return descr, nil
+	}
+
+	generation := r.Cache.getGeneration()
+	descr, err := r.Repository.FindSingleByInode(ctx, inode)
+	if err != nil || descr == nil {
+		return descr, err
+	}
+	r.Cache.putDescriptor(generation, descr)
+
+	return descr, nil
+}
+
+func (r *CachedDescriptorRepository) FindSingleByName(ctx context.Context, parent Inode, target string) (DescriptorInterface, error) {
+	if descr, ok := r.Cache.getEntry(parent, target); ok {
+		return descr, nil
+	}
+
+	generation := r.Cache.getGeneration()
+	descr, err := r.Repository.FindSingleByName(ctx, parent, target)
+	if err != nil {
+		return nil, err
+	}
+	r.Cache.putEntry(generation, parent, target, descr)
+
+	return descr, nil
+}
+
+func (r *CachedDescriptorRepository) GetUsage(ctx context.Context) (VolumeUsage, error) {
+	return r.Repository.GetUsage(ctx)
+}
+
+func (r *CachedDescriptorRepository) IsExistsByName(ctx context.Context, parent Inode, name string) (bool, error) {
+	descr, err := r.FindSingleByName(ctx, parent, name)
+	if err != nil {
+		return false, err
+	}
+
+	return descr != nil, nil
+}
+
+func (r *CachedDescriptorRepository) Link(ctx context.Context, inode Inode, newParent Inode, newName string) (DescriptorInterface, error) {
+	defer r.Cache.InvalidateInode(inode)
+	defer r.Cache.InvalidateEntry(newParent, newName)
+
+	return r.Repository.Link(ctx, inode, newParent, newName)
+}
+
+func (r *CachedDescriptorRepository) RemoveByName(ctx context.Context, parent Inode, name string, dir bool) error {
+	// The descriptor of the entry is invalidated as well, since other hard links of it remain.
+	if err := r.resolveEntry(ctx, parent, name); err != nil {
+		return err
+	}
+	defer r.Cache.InvalidateEntry(parent, name)
+
+	return r.Repository.RemoveByName(ctx, parent, name, dir)
+}
+
+func (r *CachedDescriptorRepository) Rename(ctx context.Context, oldParent Inode, oldName string, newParent Inode, newName string) error {
+	if err := r.resolveEntry(ctx, oldParent, oldName); err != nil {
+		return err
+	}
+	if err := r.resolveEntry(ctx, newParent, newName); err != nil {
+		return err
+	}
+	defer r.Cache.InvalidateEntry(newParent, newName)
+	defer r.Cache.InvalidateEntry(oldParent, oldName)
+
+	return r.Repository.Rename(ctx, oldParent, oldName, newParent, newName)
+}
+
+func (r *CachedDescriptorRepository) UpdateAtime(ctx context.Context, inode Inode, relative bool) error {
+	defer r.Cache.InvalidateInode(inode)
+
+	return r.Repository.UpdateAtime(ctx, inode, relative)
+}
+
+func (r *CachedDescriptorRepository) UpdateAttrs(ctx context.Context, inode Inode, attrs DescriptorAttrs) error {
+	defer r.Cache.InvalidateInode(inode)
+
+	return r.Repository.UpdateAttrs(ctx, inode, attrs)
+}
+
+// resolveEntry makes sure that the cache knows the inode of the entry, so the invalidation
+// of the entry reaches its descriptor.
+func (r *CachedDescriptorRepository) resolveEntry(ctx context.Context, parent Inode, name string) error {
+	_, err := r.FindSingleByName(ctx, parent, name)
+	return err
+}
+
+// CachedDataBlockRepository invalidates the cached descriptors on changes of the file data,
+// as they change the size and the modification time.
+type CachedDataBlockRepository struct {
+	Repository DataBlockRepository
+	Cache      *DescriptorCache
+}
+
+func (r *CachedDataBlockRepository) Append(ctx context.Context, descr DescriptorInterface, data []byte) (int, error) {
+	defer r.Cache.InvalidateInode(descr.GetInode())
+
+	return r.Repository.Append(ctx, descr, data)
+}
+
+func (r *CachedDataBlockRepository) Read(ctx context.Context, descr DescriptorInterface, offset uint64, size int) ([]byte, error) {
+	return r.Repository.Read(ctx, descr, offset, size)
+}
+
+func (r *CachedDataBlockRepository) Truncate(ctx context.Context, descr DescriptorInterface, size uint64) error {
+	defer r.Cache.InvalidateInode(descr.GetInode())
+
+	return r.Repository.Truncate(ctx, descr, size)
+}
+
+func (r *CachedDataBlockRepository) Write(ctx context.Context, descr DescriptorInterface, offset uint64, data []byte) (int, error) {
+	defer r.Cache.InvalidateInode(descr.GetInode())
+
+	return r.Repository.Write(ctx, descr, offset, data)
+}
+
+// CachedXattrRepository invalidates the cached descriptors on changes of the attributes,
+// as they change the change time.
+type CachedXattrRepository struct {
+	Repository XattrRepository
+	Cache      *DescriptorCache
+}
+
+func (r *CachedXattrRepository) Find(ctx context.Context, inode Inode, name string) ([]byte, error) {
+	return r.Repository.Find(ctx, inode, name)
+}
+
+func (r *CachedXattrRepository) FindNames(ctx context.Context, inode Inode) ([]string, error) {
+	return r.Repository.FindNames(ctx, inode)
+}
+
+func (r *CachedXattrRepository) Remove(ctx context.Context, inode Inode, name string) error {
+	defer r.Cache.InvalidateInode(inode)
+
+	return r.Repository.Remove(ctx, inode, name)
+}
+
+func (r *CachedXattrRepository) Set(ctx context.Context, inode Inode, name string, value []byte, flags XattrSetFlags) error {
+	defer r.Cache.InvalidateInode(inode)
+
+	return r.Repository.Set(ctx, inode, name, value, flags)
+}
+
+// CachedRepositoryRegistry wraps the repositories of the registry with a shared descriptor cache.
+type CachedRepositoryRegistry struct {
+	dataBlocks  *CachedDataBlockRepository
+	descriptors *CachedDescriptorRepository
+	settings    SettingRepository
+	xattrs      *CachedXattrRepository
+}
+
+// NewCachedRepositoryRegistry creates the cached registry. caseInsensitive must match the case
+// sensitivity of the registry, so the cache matches the names the same way.
+func NewCachedRepositoryRegistry(registry RepositoryRegistry, ttl time.Duration, caseInsensitive bool) *CachedRepositoryRegistry {
+	cache := &DescriptorCache{TTL: ttl, CaseInsensitive: caseInsensitive}
+
+	return &CachedRepositoryRegistry{
+		dataBlocks:  &CachedDataBlockRepository{Repository: registry.GetDataBlockRepository(), Cache: cache},
+		descriptors: &CachedDescriptorRepository{Repository: registry.GetDescriptorRepository(), Cache: cache},
+		settings:    registry.GetSettingRepository(),
+		xattrs:      &CachedXattrRepository{Repository: registry.GetXattrRepository(), Cache: cache},
+	}
+}
+
+func (r *CachedRepositoryRegistry) GetDataBlockRepository() DataBlockRepository {
+	return r.dataBlocks
+}
+
+func (r *CachedRepositoryRegistry) GetDescriptorRepository() DescriptorRepository {
+	return r.descriptors
+}
+
+func (r *CachedRepositoryRegistry) GetSettingRepository() SettingRepository {
+	return r.settings
+}
+
+func (r *CachedRepositoryRegistry) GetXattrRepository() XattrRepository {
+	return r.xattrs
+}
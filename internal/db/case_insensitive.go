@@ -0,0 +1,34 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import "strings"
+
+// FoldName returns the case fold of the name. Names that differ only in case have the same fold.
+func FoldName(name string) string {
+	return strings.ToLower(strings.ToUpper(name))
+}
+
+// NameKey returns the form of the name that identifies a directory entry: the case fold of the name
+// on case-insensitive volumes and the name itself otherwise.
+func NameKey(name string, caseInsensitive bool) string {
+	if caseInsensitive {
+		return FoldName(name)
+	}
+
+	return name
+}
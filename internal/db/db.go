@@ -17,50 +17,125 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"github.com/kos-v/dbunderfs/internal/container"
+	"time"
 )
 
 const (
 	RootName string = "/"
+
+	// DefaultBlockSize is the size of data blocks of files, if the volume does not override it.
+	// Files that fit in a single block are stored inline in the descriptor.
+	DefaultBlockSize uint32 = 64 * 1024
+
+	// MaxNameLength is the maximum length of an entry name in bytes.
+	MaxNameLength = 255
+
+	// RelatimeInterval is the maximum age of an access time in the relatime mode.
+	RelatimeInterval = 24 * time.Hour
 )
 
 type Instance interface {
 	Close() error
 	Connect() (*sql.DB, error)
 	Exec(query string, args ...interface{}) (sql.Result, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 	GetDriverName() string
 	GetDSN() DSN
 	HasConnection() bool
 	Reconnect() (*sql.DB, error)
 	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
 	QueryRow(query string, args ...interface{}) *sql.Row
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	// Transaction runs the handler in a transaction bound to the context. The transaction
+	// is rolled back if the context is done before it is committed.
+	Transaction(ctx context.Context, handler TxHandler) error
 }
 
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// TxHandler is executed inside a database transaction. The transaction is committed
+// if the handler returns nil and rolled back otherwise.
+type TxHandler func(tx Queryer) error
+
 type DSN interface {
 	GetDatabase() string
+	GetParam(name string) string
 	GetPrefix() string
+	HasParam(name string) bool
 	ToString() string
 }
 
 type DataBlockRepository interface {
-	FindFirst(descr DescriptorInterface) (DataBlockNodeInterface, error)
-	Write(descr DescriptorInterface, data *[]byte) error
+	// Append writes the data at the end of the file. The end is determined atomically with the write.
+	Append(ctx context.Context, descr DescriptorInterface, data []byte) (int, error)
+	// Read returns up to size bytes of the file data starting at the offset.
+	// The result is shorter than size only at the end of the file.
+	Read(ctx context.Context, descr DescriptorInterface, offset uint64, size int) ([]byte, error)
+	Truncate(ctx context.Context, descr DescriptorInterface, size uint64) error
+	Write(ctx context.Context, descr DescriptorInterface, offset uint64, data []byte) (int, error)
 }
 
 type DescriptorRepository interface {
-	Create(parent Inode, name string, dType DescriptorType, attrs DescriptorAttrs) (DescriptorInterface, error)
-	FindChildrenByInode(parentInode Inode) (container.CollectionInterface, error)
-	FindRoot() (DescriptorInterface, error)
-	FindSingleByInode(inode Inode) (DescriptorInterface, error)
-	FindSingleByName(parent Inode, target string) (DescriptorInterface, error)
-	IsExistsByName(parent Inode, name string) (bool, error)
-	RemoveByName(parent Inode, name string) error
+	Create(ctx context.Context, parent Inode, name string, dType DescriptorType, attrs DescriptorAttrs) (DescriptorInterface, error)
+	CreateSymlink(ctx context.Context, parent Inode, name string, target string, attrs DescriptorAttrs) (DescriptorInterface, error)
+	FindChildrenByInode(ctx context.Context, parentInode Inode) (container.CollectionInterface, error)
+	FindRoot(ctx context.Context) (DescriptorInterface, error)
+	FindSingleByInode(ctx context.Context, inode Inode) (DescriptorInterface, error)
+	FindSingleByName(ctx context.Context, parent Inode, target string) (DescriptorInterface, error)
+	// GetUsage returns the total size of the files and the number of inodes of the volume.
+	// Hard links are counted once.
+	GetUsage(ctx context.Context) (VolumeUsage, error)
+	IsExistsByName(ctx context.Context, parent Inode, name string) (bool, error)
+	// Link creates a new directory entry (hard link) for the existing descriptor.
+	Link(ctx context.Context, inode Inode, newParent Inode, newName string) (DescriptorInterface, error)
+	// RemoveByName removes the directory entry. If dir is true, the entry must be an empty directory (rmdir),
+	// otherwise it must not be a directory (unlink). Entries of a tree are never removed implicitly.
+	RemoveByName(ctx context.Context, parent Inode, name string, dir bool) error
+	Rename(ctx context.Context, oldParent Inode, oldName string, newParent Inode, newName string) error
+	// UpdateAtime sets the access time of the descriptor to the current time. If relative is true,
+	// the time is updated only when it is not newer than mtime/ctime or older than a day (relatime).
+	UpdateAtime(ctx context.Context, inode Inode, relative bool) error
+	// UpdateAttrs saves the permission, the owner and the access/modification times of the descriptor.
+	// The change time is set to the current time. The size is managed by DataBlockRepository.
+	UpdateAttrs(ctx context.Context, inode Inode, attrs DescriptorAttrs) error
+}
+
+// XattrSetFlags has the same values as the flags of setxattr(2).
+type XattrSetFlags uint32
+
+const (
+	// XattrCreate fails the set operation if the attribute already exists.
+	XattrCreate XattrSetFlags = 1
+	// XattrReplace fails the set operation if the attribute does not exist.
+	XattrReplace XattrSetFlags = 2
+)
+
+type XattrRepository interface {
+	Find(ctx context.Context, inode Inode, name string) ([]byte, error)
+	FindNames(ctx context.Context, inode Inode) ([]string, error)
+	Remove(ctx context.Context, inode Inode, name string) error
+	Set(ctx context.Context, inode Inode, name string, value []byte, flags XattrSetFlags) error
+}
+
+// SettingRepository stores the settings of the volume by name. See VolumeSettings.
+type SettingRepository interface {
+	FindAll(ctx context.Context) (map[string]string, error)
+	Set(ctx context.Context, name string, value string) error
 }
 
 type RepositoryRegistry interface {
 	GetDataBlockRepository() DataBlockRepository
 	GetDescriptorRepository() DescriptorRepository
+	GetSettingRepository() SettingRepository
+	GetXattrRepository() XattrRepository
 }
 
 type QueryExecutor interface {
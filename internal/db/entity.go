@@ -20,7 +20,7 @@ import (
 	"database/sql"
 	"io/fs"
 	"strconv"
-	"sync"
+	"time"
 )
 
 type Inode uint64
@@ -28,51 +28,56 @@ type Inode uint64
 type DescriptorType string
 
 const (
-	DT_Dir  DescriptorType = "DIR"
-	DT_File DescriptorType = "FILE"
+	DT_Dir     DescriptorType = "DIR"
+	DT_File    DescriptorType = "FILE"
+	DT_Symlink DescriptorType = "SYMLINK"
 )
 
-type DataBlockNodeInterface interface {
-	Add(offset uint64, newData *[]byte) int
-	GetData() *[]byte
-}
+// Splice writes chunk into data at the offset and returns the result. The data is extended
+// if needed, a gap between the end of the data and the offset is filled with zeros.
+func Splice(data []byte, offset uint64, chunk []byte) []byte {
+	end := offset + uint64(len(chunk))
+	if end > uint64(len(data)) {
+		extended := make([]byte, end)
+		copy(extended, data)
+		data = extended
+	}
+	copy(data[offset:], chunk)
 
-type DataBlockNode struct {
-	mu   sync.Mutex
-	Data []byte
+	return data
 }
 
-func (d *DataBlockNode) Add(offset uint64, newData *[]byte) int {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-
-	if int(offset) > len(d.Data) {
-		offset = uint64(len(d.Data))
+// CheckNameLength fails for names longer than MaxNameLength. The repositories check the names
+// before they are stored, as not every storage limits the length of the name column in bytes.
+func CheckNameLength(name string) error {
+	if len(name) > MaxNameLength {
+		return &NameTooLongError{Name: name, MaxLength: MaxNameLength}
 	}
 
-	newLen := len(d.Data) + len(*newData) - (len(d.Data) - int(offset))
-	data := make([]byte, newLen)
-	copy(data, d.Data)
-
-	addedLen := copy(data[offset:], *newData)
-	d.Data = data
-
-	return addedLen
+	return nil
 }
 
-func (d *DataBlockNode) GetData() *[]byte {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+// Resize cuts the data to the size or extends it with zeros.
+func Resize(data []byte, size uint64) []byte {
+	if size <= uint64(len(data)) {
+		return data[:size]
+	}
 
-	return &d.Data
+	return Splice(data, size, nil)
 }
 
 type DescriptorInterface interface {
+	GetAtime() time.Time
+	GetCrtime() time.Time
+	GetCtime() time.Time
 	GetInode() Inode
+	GetMtime() time.Time
 	GetName() string
+	GetNlink() uint32
 	GetParent() Inode
 	GetPermission() fs.FileMode
 	GetSize() uint64
+	GetTarget() string
 	GetType() DescriptorType
 	GetUID() uint32
 	GetGID() uint32
@@ -84,6 +89,10 @@ type DescriptorAttrs struct {
 	Permission string
 	UID        uint32
 	GID        uint32
+	Atime      time.Time
+	Mtime      time.Time
+	Ctime      time.Time
+	Crtime     time.Time
 }
 
 type Descriptor struct {
@@ -93,16 +102,38 @@ type Descriptor struct {
 	Parent sql.NullInt64
 	Name   string
 	Type   DescriptorType
+	Target sql.NullString
+	Nlink  uint32
+}
+
+func (d *Descriptor) GetAtime() time.Time {
+	return d.Atime
+}
+
+func (d *Descriptor) GetCrtime() time.Time {
+	return d.Crtime
+}
+
+func (d *Descriptor) GetCtime() time.Time {
+	return d.Ctime
 }
 
 func (d *Descriptor) GetInode() Inode {
 	return d.Inode
 }
 
+func (d *Descriptor) GetMtime() time.Time {
+	return d.Mtime
+}
+
 func (d *Descriptor) GetName() string {
 	return d.Name
 }
 
+func (d *Descriptor) GetNlink() uint32 {
+	return d.Nlink
+}
+
 func (d *Descriptor) GetParent() Inode {
 	return Inode(d.Parent.Int64)
 }
@@ -113,13 +144,28 @@ func (d *Descriptor) GetPermission() fs.FileMode {
 		return 0
 	}
 
-	return fs.FileMode(octal)
+	mode := fs.FileMode(octal) & fs.ModePerm
+	if octal&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if octal&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if octal&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+
+	return mode
 }
 
 func (d *Descriptor) GetSize() uint64 {
 	return d.Size
 }
 
+func (d *Descriptor) GetTarget() string {
+	return d.Target.String
+}
+
 func (d *Descriptor) GetType() DescriptorType {
 	return d.Type
 }
@@ -0,0 +1,127 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import "fmt"
+
+type NotFoundError struct {
+	Inode  Inode
+	Parent Inode
+	Name   string
+}
+
+func (err *NotFoundError) Error() string {
+	if err.Name == "" {
+		return fmt.Sprintf("node %d was not found", err.Inode)
+	}
+	return fmt.Sprintf("node %q was not found in parent %d", err.Name, err.Parent)
+}
+
+type ExistsError struct {
+	Parent Inode
+	Name   string
+}
+
+func (err *ExistsError) Error() string {
+	return fmt.Sprintf("node %q already exists in parent %d", err.Name, err.Parent)
+}
+
+type NameTooLongError struct {
+	Name      string
+	MaxLength int
+}
+
+func (err *NameTooLongError) Error() string {
+	return fmt.Sprintf("name %q is longer than %d bytes", err.Name, err.MaxLength)
+}
+
+// NotEmptyError is returned for a directory that has entries. The directory is identified by its inode
+// or, when the database only reports the entry, by its parent and name.
+type NotEmptyError struct {
+	Inode  Inode
+	Parent Inode
+	Name   string
+}
+
+func (err *NotEmptyError) Error() string {
+	if err.Inode == 0 {
+		return fmt.Sprintf("directory %q in parent %d is not empty", err.Name, err.Parent)
+	}
+	return fmt.Sprintf("directory %d is not empty", err.Inode)
+}
+
+type IsDirError struct{ Inode Inode }
+
+func (err *IsDirError) Error() string {
+	return fmt.Sprintf("node %d is a directory", err.Inode)
+}
+
+type NotDirError struct{ Inode Inode }
+
+func (err *NotDirError) Error() string {
+	return fmt.Sprintf("node %d is not a directory", err.Inode)
+}
+
+type SubtreeMoveError struct {
+	Inode  Inode
+	Target Inode
+}
+
+func (err *SubtreeMoveError) Error() string {
+	return fmt.Sprintf("directory %d cannot be moved into its own subtree %d", err.Inode, err.Target)
+}
+
+type XattrNotFoundError struct {
+	Inode Inode
+	Name  string
+}
+
+func (err *XattrNotFoundError) Error() string {
+	return fmt.Sprintf("extended attribute %q of node %d was not found", err.Name, err.Inode)
+}
+
+type XattrExistsError struct {
+	Inode Inode
+	Name  string
+}
+
+func (err *XattrExistsError) Error() string {
+	return fmt.Sprintf("extended attribute %q of node %d already exists", err.Name, err.Inode)
+}
+
+// ConstraintError is a violation of a database constraint that has no more specific error.
+type ConstraintError struct {
+	Err error
+}
+
+func (err *ConstraintError) Error() string {
+	return fmt.Sprintf("constraint violation: %s", err.Err)
+}
+
+func (err *ConstraintError) Unwrap() error {
+	return err.Err
+}
+
+// NoSpaceError is returned for a change that would exceed the capacity or the inode limit of the volume.
+type NoSpaceError struct {
+	Limit string
+	Value uint64
+}
+
+func (err *NoSpaceError) Error() string {
+	return fmt.Sprintf("the change exceeds the %s %d of the volume", err.Limit, err.Value)
+}
@@ -0,0 +1,43 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package memory
+
+import "github.com/kos-v/dsnparser"
+
+type DSN struct {
+	ParsedDSN dsnparser.DSN
+}
+
+func (d *DSN) GetDatabase() string {
+	return d.ParsedDSN.GetHost()
+}
+
+func (d *DSN) GetParam(name string) string {
+	return d.ParsedDSN.GetParam(name)
+}
+
+func (d *DSN) GetPrefix() string {
+	return ""
+}
+
+func (d *DSN) HasParam(name string) bool {
+	return d.ParsedDSN.HasParam(name)
+}
+
+func (d *DSN) ToString() string {
+	return d.ParsedDSN.GetRaw()
+}
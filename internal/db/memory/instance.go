@@ -0,0 +1,110 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"sync"
+)
+
+type UnsupportedQueryError struct{}
+
+func (err *UnsupportedQueryError) Error() string {
+	return "memory volume does not execute SQL queries"
+}
+
+// Instance keeps the volume in the process memory. The volume is created on Connect and lives
+// until the process exits. There is no SQL engine behind the instance: Exec, Query and Transaction
+// fail with UnsupportedQueryError and QueryRow returns nil, the repositories work with the Storage directly.
+type Instance struct {
+	DSN DSN
+
+	sync.RWMutex
+	storage *Storage
+}
+
+func (inst *Instance) Close() error {
+	return nil
+}
+
+func (inst *Instance) Connect() (*sql.DB, error) {
+	inst.Lock()
+	defer inst.Unlock()
+
+	if inst.storage == nil {
+		storage, err := NewStorage()
+		if err != nil {
+			return nil, err
+		}
+		inst.storage = storage
+	}
+
+	return nil, nil
+}
+
+func (inst *Instance) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return nil, &UnsupportedQueryError{}
+}
+
+func (inst *Instance) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return nil, &UnsupportedQueryError{}
+}
+
+func (inst *Instance) GetDriverName() string {
+	return "memory"
+}
+
+func (inst *Instance) GetDSN() db.DSN {
+	return &inst.DSN
+}
+
+func (inst *Instance) GetStorage() *Storage {
+	inst.RLock()
+	defer inst.RUnlock()
+
+	return inst.storage
+}
+
+func (inst *Instance) HasConnection() bool {
+	return inst.GetStorage() != nil
+}
+
+func (inst *Instance) Reconnect() (*sql.DB, error) {
+	return inst.Connect()
+}
+
+func (inst *Instance) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, &UnsupportedQueryError{}
+}
+
+func (inst *Instance) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, &UnsupportedQueryError{}
+}
+
+func (inst *Instance) QueryRow(query string, args ...interface{}) *sql.Row {
+	return nil
+}
+
+func (inst *Instance) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return nil
+}
+
+func (inst *Instance) Transaction(ctx context.Context, handler db.TxHandler) error {
+	return &UnsupportedQueryError{}
+}
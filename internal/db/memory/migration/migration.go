@@ -0,0 +1,37 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package migration
+
+import (
+	"github.com/kos-v/dbunderfs/internal/db/migration"
+)
+
+// Commiter of the memory volumes. The volume is created with the latest schema, so there are
+// no migrations and nothing to commit.
+type Commiter struct{}
+
+func (c *Commiter) Commit(migration *migration.Migration) error {
+	return nil
+}
+
+func (c *Commiter) IsCommited(migration *migration.Migration) (bool, error) {
+	return true, nil
+}
+
+func (c *Commiter) Rollback(migration *migration.Migration) error {
+	return nil
+}
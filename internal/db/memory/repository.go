@@ -0,0 +1,531 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"github.com/kos-v/dbunderfs/internal/container"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"sort"
+	"time"
+)
+
+type DataBlockRepository struct {
+	storage   *Storage
+	blockSize uint32
+}
+
+func (repo *DataBlockRepository) Read(ctx context.Context, descr db.DescriptorInterface, offset uint64, size int) ([]byte, error) {
+	repo.storage.RLock()
+	defer repo.storage.RUnlock()
+
+	n, ok := repo.storage.nodes[descr.GetInode()]
+	if !ok {
+		return nil, &db.NotFoundError{Inode: descr.GetInode()}
+	}
+
+	fileSize := n.descr.Size
+	if offset >= fileSize || size <= 0 {
+		return []byte{}, nil
+	}
+	end := offset + uint64(size)
+	if end > fileSize {
+		end = fileSize
+	}
+
+	bs := uint64(repo.blockSize)
+	data := make([]byte, end-offset)
+	for idx := offset / bs; idx*bs < end; idx++ {
+		block := n.blocks[idx]
+		blockStart := idx * bs
+		if blockStart < offset {
+			skip := offset - blockStart
+			if skip < uint64(len(block)) {
+				copy(data, block[skip:])
+			}
+		} else {
+			copy(data[blockStart-offset:], block)
+		}
+	}
+
+	return data, nil
+}
+
+func (repo *DataBlockRepository) Truncate(ctx context.Context, descr db.DescriptorInterface, size uint64) error {
+	repo.storage.Lock()
+	defer repo.storage.Unlock()
+
+	n, ok := repo.storage.nodes[descr.GetInode()]
+	if !ok {
+		return &db.NotFoundError{Inode: descr.GetInode()}
+	}
+
+	bs := uint64(repo.blockSize)
+	blocksCount := (size + bs - 1) / bs
+	for idx := range n.blocks {
+		if idx >= blocksCount {
+			delete(n.blocks, idx)
+		}
+	}
+	if blocksCount > 0 {
+		lastBlockSize := size - (blocksCount-1)*bs
+		if block := n.blocks[blocksCount-1]; uint64(len(block)) > lastBlockSize {
+			n.blocks[blocksCount-1] = block[:lastBlockSize]
+		}
+	}
+
+	now := time.Now()
+	n.descr.Size = size
+	n.descr.Mtime = now
+	n.descr.Ctime = now
+
+	return nil
+}
+
+func (repo *DataBlockRepository) Append(ctx context.Context, descr db.DescriptorInterface, data []byte) (int, error) {
+	return repo.write(descr, 0, data, true)
+}
+
+func (repo *DataBlockRepository) Write(ctx context.Context, descr db.DescriptorInterface, offset uint64, data []byte) (int, error) {
+	return repo.write(descr, offset, data, false)
+}
+
+func (repo *DataBlockRepository) write(descr db.DescriptorInterface, offset uint64, data []byte, appending bool) (int, error) {
+	repo.storage.Lock()
+	defer repo.storage.Unlock()
+
+	n, ok := repo.storage.nodes[descr.GetInode()]
+	if !ok {
+		return 0, &db.NotFoundError{Inode: descr.GetInode()}
+	}
+	if appending {
+		offset = n.descr.Size
+	}
+
+	bs := uint64(repo.blockSize)
+	end := offset + uint64(len(data))
+	for idx := offset / bs; idx*bs < end; idx++ {
+		blockStart := idx * bs
+		from := offset
+		if from < blockStart {
+			from = blockStart
+		}
+		to := end
+		if to > blockStart+bs {
+			to = blockStart + bs
+		}
+
+		block := append([]byte{}, n.blocks[idx]...)
+		n.blocks[idx] = db.Splice(block, from-blockStart, data[from-offset:to-offset])
+	}
+
+	now := time.Now()
+	if end > n.descr.Size {
+		n.descr.Size = end
+	}
+	n.descr.Mtime = now
+	n.descr.Ctime = now
+
+	return len(data), nil
+}
+
+type DescriptorRepository struct {
+	storage         *Storage
+	caseInsensitive bool
+}
+
+func (dr *DescriptorRepository) Create(ctx context.Context, parent db.Inode, name string, dType db.DescriptorType, attrs db.DescriptorAttrs) (db.DescriptorInterface, error) {
+	return dr.create(ctx, parent, name, dType, sql.NullString{}, attrs)
+}
+
+func (dr *DescriptorRepository) CreateSymlink(ctx context.Context, parent db.Inode, name string, target string, attrs db.DescriptorAttrs) (db.DescriptorInterface, error) {
+	return dr.create(ctx, parent, name, db.DT_Symlink, sql.NullString{String: target, Valid: true}, attrs)
+}
+
+func (dr *DescriptorRepository) FindChildrenByInode(ctx context.Context, parentInode db.Inode) (container.CollectionInterface, error) {
+	dr.storage.RLock()
+	defer dr.storage.RUnlock()
+
+	collection := container.Collection{}
+	dir, ok := dr.storage.nodes[parentInode]
+	if !ok || dir.children == nil {
+		return &collection, nil
+	}
+
+	for _, descr := range dr.storage.sortedChildren(dir) {
+		collection.Append(descr)
+	}
+
+	return &collection, nil
+}
+
+func (dr *DescriptorRepository) FindRoot(ctx context.Context) (db.DescriptorInterface, error) {
+	dr.storage.RLock()
+	defer dr.storage.RUnlock()
+
+	n, ok := dr.storage.nodes[dr.storage.root]
+	if !ok {
+		return nil, nil
+	}
+
+	descr := n.descr
+	return &descr, nil
+}
+
+func (dr *DescriptorRepository) FindSingleByInode(ctx context.Context, inode db.Inode) (db.DescriptorInterface, error) {
+	dr.storage.RLock()
+	defer dr.storage.RUnlock()
+
+	n, ok := dr.storage.nodes[inode]
+	if !ok {
+		return nil, nil
+	}
+
+	descr := n.descr
+	return &descr, nil
+}
+
+func (dr *DescriptorRepository) FindSingleByName(ctx context.Context, parent db.Inode, target string) (db.DescriptorInterface, error) {
+	dr.storage.RLock()
+	defer dr.storage.RUnlock()
+
+	n, entry := dr.storage.findEntry(parent, dr.nameKey(target))
+	if n == nil {
+		return nil, nil
+	}
+
+	return dr.storage.describe(n, parent, entry.name), nil
+}
+
+func (dr *DescriptorRepository) GetUsage(ctx context.Context) (db.VolumeUsage, error) {
+	dr.storage.RLock()
+	defer dr.storage.RUnlock()
+
+	usage := db.VolumeUsage{Inodes: uint64(len(dr.storage.nodes))}
+	for _, n := range dr.storage.nodes {
+		usage.Bytes += n.descr.Size
+	}
+
+	return usage, nil
+}
+
+func (dr *DescriptorRepository) IsExistsByName(ctx context.Context, parent db.Inode, name string) (bool, error) {
+	descr, err := dr.FindSingleByName(ctx, parent, name)
+	if err != nil {
+		return false, err
+	}
+
+	return descr != nil, nil
+}
+
+func (dr *DescriptorRepository) Link(ctx context.Context, inode db.Inode, newParent db.Inode, newName string) (db.DescriptorInterface, error) {
+	dr.storage.Lock()
+	defer dr.storage.Unlock()
+
+	n, ok := dr.storage.nodes[inode]
+	if !ok {
+		return nil, &db.NotFoundError{Inode: inode}
+	}
+	if n.descr.Type == db.DT_Dir {
+		return nil, &db.IsDirError{Inode: inode}
+	}
+
+	dir, err := dr.storage.findDir(newParent)
+	if err != nil {
+		return nil, err
+	}
+	entry := dr.newLink(newParent, newName)
+	if err := dr.storage.checkFreeName(dir, entry); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	n.links = append(n.links, entry)
+	dir.children[entry.key] = inode
+	n.descr.Nlink++
+	n.descr.Ctime = now
+	dr.storage.touchDirs(now, newParent)
+
+	return dr.storage.describe(n, newParent, newName), nil
+}
+
+func (dr *DescriptorRepository) RemoveByName(ctx context.Context, parent db.Inode, name string, dir bool) error {
+	dr.storage.Lock()
+	defer dr.storage.Unlock()
+
+	n, entry := dr.storage.findEntry(parent, dr.nameKey(name))
+	if n == nil {
+		return &db.NotFoundError{Parent: parent, Name: name}
+	}
+	if err := dr.checkRemovable(n, dir); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	dr.storage.removeEntry(n, entry, now)
+	dr.storage.touchDirs(now, parent)
+
+	return nil
+}
+
+func (dr *DescriptorRepository) Rename(ctx context.Context, oldParent db.Inode, oldName string, newParent db.Inode, newName string) error {
+	dr.storage.Lock()
+	defer dr.storage.Unlock()
+
+	if err := db.CheckNameLength(newName); err != nil {
+		return err
+	}
+
+	source, sourceEntry := dr.storage.findEntry(oldParent, dr.nameKey(oldName))
+	if source == nil {
+		return &db.NotFoundError{Parent: oldParent, Name: oldName}
+	}
+
+	if oldParent == newParent && oldName == newName {
+		return nil
+	}
+
+	if _, err := dr.storage.findDir(newParent); err != nil {
+		return err
+	}
+
+	if source.descr.Type == db.DT_Dir && oldParent != newParent {
+		if err := dr.storage.checkNotAncestor(source.descr.Inode, newParent); err != nil {
+			return err
+		}
+	}
+
+	// On case-insensitive volumes the target is the source itself when only the case of the name changes.
+	now := time.Now()
+	entry := dr.newLink(newParent, newName)
+	if target, targetEntry := dr.storage.findEntry(newParent, entry.key); target != nil && targetEntry != sourceEntry {
+		if target == source {
+			return nil
+		}
+
+		if err := dr.checkReplaceable(source, target); err != nil {
+			return err
+		}
+
+		dr.storage.removeEntry(target, targetEntry, now)
+	}
+
+	dr.storage.moveEntry(source, sourceEntry, entry)
+	source.descr.Ctime = now
+	dr.storage.touchDirs(now, oldParent, newParent)
+
+	return nil
+}
+
+func (dr *DescriptorRepository) UpdateAtime(ctx context.Context, inode db.Inode, relative bool) error {
+	dr.storage.Lock()
+	defer dr.storage.Unlock()
+
+	n, ok := dr.storage.nodes[inode]
+	if !ok {
+		return nil
+	}
+
+	now := time.Now()
+	attrs := &n.descr.DescriptorAttrs
+	if relative && attrs.Atime.After(attrs.Mtime) && attrs.Atime.After(attrs.Ctime) && !attrs.Atime.Before(now.Add(-db.RelatimeInterval)) {
+		return nil
+	}
+	attrs.Atime = now
+
+	return nil
+}
+
+func (dr *DescriptorRepository) UpdateAttrs(ctx context.Context, inode db.Inode, attrs db.DescriptorAttrs) error {
+	dr.storage.Lock()
+	defer dr.storage.Unlock()
+
+	n, ok := dr.storage.nodes[inode]
+	if !ok {
+		return nil
+	}
+
+	n.descr.Permission = attrs.Permission
+	n.descr.UID = attrs.UID
+	n.descr.GID = attrs.GID
+	n.descr.Atime = attrs.Atime
+	n.descr.Mtime = attrs.Mtime
+	n.descr.Ctime = time.Now()
+
+	return nil
+}
+
+func (dr *DescriptorRepository) create(ctx context.Context, parent db.Inode, name string, dType db.DescriptorType, target sql.NullString, attrs db.DescriptorAttrs) (db.DescriptorInterface, error) {
+	dr.storage.Lock()
+	defer dr.storage.Unlock()
+
+	dir, err := dr.storage.findDir(parent)
+	if err != nil {
+		return nil, err
+	}
+	entry := dr.newLink(parent, name)
+	if err := dr.storage.checkFreeName(dir, entry); err != nil {
+		return nil, err
+	}
+
+	n := dr.storage.addNode(dir, entry, dType, target, attrs)
+	dr.storage.touchDirs(n.descr.Crtime, parent)
+
+	descr := n.descr
+	return &descr, nil
+}
+
+func (dr *DescriptorRepository) nameKey(name string) string {
+	return db.NameKey(name, dr.caseInsensitive)
+}
+
+func (dr *DescriptorRepository) newLink(parent db.Inode, name string) link {
+	return link{parent: parent, name: name, key: dr.nameKey(name)}
+}
+
+// checkRemovable verifies that the kind of the entry matches the removal: rmdir removes only empty
+// directories, unlink removes anything but directories.
+func (dr *DescriptorRepository) checkRemovable(target *node, dir bool) error {
+	if !dir && target.descr.Type == db.DT_Dir {
+		return &db.IsDirError{Inode: target.descr.Inode}
+	}
+	if dir && target.descr.Type != db.DT_Dir {
+		return &db.NotDirError{Inode: target.descr.Inode}
+	}
+	if dir && len(target.children) > 0 {
+		return &db.NotEmptyError{Inode: target.descr.Inode}
+	}
+
+	return nil
+}
+
+// checkReplaceable verifies that the target of a rename can be overwritten by the source.
+func (dr *DescriptorRepository) checkReplaceable(source *node, target *node) error {
+	if source.descr.Type != db.DT_Dir && target.descr.Type == db.DT_Dir {
+		return &db.IsDirError{Inode: target.descr.Inode}
+	}
+	if source.descr.Type == db.DT_Dir && target.descr.Type != db.DT_Dir {
+		return &db.NotDirError{Inode: target.descr.Inode}
+	}
+	if target.descr.Type == db.DT_Dir && len(target.children) > 0 {
+		return &db.NotEmptyError{Inode: target.descr.Inode}
+	}
+
+	return nil
+}
+
+type SettingRepository struct {
+	storage *Storage
+}
+
+func (repo *SettingRepository) FindAll(ctx context.Context) (map[string]string, error) {
+	repo.storage.RLock()
+	defer repo.storage.RUnlock()
+
+	settings := make(map[string]string, len(repo.storage.settings))
+	for name, value := range repo.storage.settings {
+		settings[name] = value
+	}
+
+	return settings, nil
+}
+
+func (repo *SettingRepository) Set(ctx context.Context, name string, value string) error {
+	repo.storage.Lock()
+	defer repo.storage.Unlock()
+
+	repo.storage.settings[name] = value
+
+	return nil
+}
+
+type XattrRepository struct {
+	storage *Storage
+}
+
+func (repo *XattrRepository) Find(ctx context.Context, inode db.Inode, name string) ([]byte, error) {
+	repo.storage.RLock()
+	defer repo.storage.RUnlock()
+
+	n, ok := repo.storage.nodes[inode]
+	if !ok {
+		return nil, &db.XattrNotFoundError{Inode: inode, Name: name}
+	}
+	value, ok := n.xattrs[name]
+	if !ok {
+		return nil, &db.XattrNotFoundError{Inode: inode, Name: name}
+	}
+
+	return append([]byte{}, value...), nil
+}
+
+func (repo *XattrRepository) FindNames(ctx context.Context, inode db.Inode) ([]string, error) {
+	repo.storage.RLock()
+	defer repo.storage.RUnlock()
+
+	var names []string
+	if n, ok := repo.storage.nodes[inode]; ok {
+		for name := range n.xattrs {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (repo *XattrRepository) Remove(ctx context.Context, inode db.Inode, name string) error {
+	repo.storage.Lock()
+	defer repo.storage.Unlock()
+
+	n, ok := repo.storage.nodes[inode]
+	if !ok {
+		return &db.XattrNotFoundError{Inode: inode, Name: name}
+	}
+	if _, ok := n.xattrs[name]; !ok {
+		return &db.XattrNotFoundError{Inode: inode, Name: name}
+	}
+
+	delete(n.xattrs, name)
+	n.descr.Ctime = time.Now()
+
+	return nil
+}
+
+func (repo *XattrRepository) Set(ctx context.Context, inode db.Inode, name string, value []byte, flags db.XattrSetFlags) error {
+	repo.storage.Lock()
+	defer repo.storage.Unlock()
+
+	n, ok := repo.storage.nodes[inode]
+	if !ok {
+		return &db.NotFoundError{Inode: inode}
+	}
+
+	_, exists := n.xattrs[name]
+	if exists && flags&db.XattrCreate != 0 {
+		return &db.XattrExistsError{Inode: inode, Name: name}
+	}
+	if !exists && flags&db.XattrReplace != 0 {
+		return &db.XattrNotFoundError{Inode: inode, Name: name}
+	}
+
+	n.xattrs[name] = append([]byte{}, value...)
+	n.descr.Ctime = time.Now()
+
+	return nil
+}
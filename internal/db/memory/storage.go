@@ -0,0 +1,256 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package memory
+
+import (
+	"database/sql"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"os/user"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// link is a directory entry of a node. The first link of a node is reported as its parent and name,
+// the same way as the origin row of a hard link in the SQL volumes. key is db.NameKey of the name.
+type link struct {
+	parent db.Inode
+	name   string
+	key    string
+}
+
+type node struct {
+	descr  db.Descriptor
+	links  []link
+	blocks map[uint64][]byte
+	// children are keyed by the keys of the entry names.
+	children map[string]db.Inode
+	xattrs   map[string][]byte
+}
+
+// Storage is the volume data of an in-memory instance. Repositories take the lock
+// for the whole operation, so each operation is atomic.
+type Storage struct {
+	sync.RWMutex
+	lastInode db.Inode
+	nodes     map[db.Inode]*node
+	root      db.Inode
+	settings  map[string]string
+}
+
+// NewStorage creates a volume with the root directory owned by the current user.
+func NewStorage() (*Storage, error) {
+	currentUser, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(currentUser.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(currentUser.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := &Storage{nodes: map[db.Inode]*node{}, settings: map[string]string{}}
+	root := storage.addNode(nil, link{name: db.RootName}, db.DT_Dir, sql.NullString{}, db.DescriptorAttrs{
+		Permission: "0775",
+		UID:        uint32(uid),
+		GID:        uint32(gid),
+	})
+	storage.root = root.descr.Inode
+
+	return storage, nil
+}
+
+func (s *Storage) addNode(parent *node, entry link, dType db.DescriptorType, target sql.NullString, attrs db.DescriptorAttrs) *node {
+	now := time.Now()
+	attrs.Atime = now
+	attrs.Mtime = now
+	attrs.Ctime = now
+	attrs.Crtime = now
+
+	s.lastInode++
+	n := &node{
+		descr: db.Descriptor{
+			DescriptorAttrs: attrs,
+			Inode:           s.lastInode,
+			Name:            entry.name,
+			Type:            dType,
+			Target:          target,
+			Nlink:           1,
+		},
+		blocks: map[uint64][]byte{},
+		xattrs: map[string][]byte{},
+	}
+	if dType == db.DT_Dir {
+		n.children = map[string]db.Inode{}
+	}
+	if parent != nil {
+		n.links = []link{entry}
+		n.descr.Parent = sql.NullInt64{Int64: int64(parent.descr.Inode), Valid: true}
+		parent.children[entry.key] = n.descr.Inode
+	}
+	s.nodes[n.descr.Inode] = n
+
+	return n
+}
+
+func (s *Storage) findDir(inode db.Inode) (*node, error) {
+	dir, ok := s.nodes[inode]
+	if !ok {
+		return nil, &db.NotFoundError{Inode: inode}
+	}
+	if dir.descr.Type != db.DT_Dir {
+		return nil, &db.NotDirError{Inode: inode}
+	}
+
+	return dir, nil
+}
+
+// findEntry returns the node and the link of the directory entry with the key. The node is nil
+// if the entry does not exist.
+func (s *Storage) findEntry(parent db.Inode, key string) (*node, link) {
+	dir, ok := s.nodes[parent]
+	if !ok || dir.children == nil {
+		return nil, link{}
+	}
+	inode, ok := dir.children[key]
+	if !ok {
+		return nil, link{}
+	}
+
+	n := s.nodes[inode]
+	return n, n.findLink(parent, key)
+}
+
+// describe returns a copy of the node attributes as seen through the directory entry.
+func (s *Storage) describe(n *node, parent db.Inode, name string) *db.Descriptor {
+	descr := n.descr
+	if len(n.links) > 0 {
+		descr.Parent = sql.NullInt64{Int64: int64(parent), Valid: true}
+		descr.Name = name
+	}
+
+	return &descr
+}
+
+func (s *Storage) checkFreeName(dir *node, entry link) error {
+	if err := db.CheckNameLength(entry.name); err != nil {
+		return err
+	}
+	if _, ok := dir.children[entry.key]; ok {
+		return &db.ExistsError{Parent: dir.descr.Inode, Name: entry.name}
+	}
+
+	return nil
+}
+
+// checkNotAncestor walks up from the node to the root and fails if inode is one of its ancestors
+// (or the node itself).
+func (s *Storage) checkNotAncestor(inode db.Inode, node db.Inode) error {
+	current, ok := s.nodes[node]
+	for ok {
+		if current.descr.Inode == inode {
+			return &db.SubtreeMoveError{Inode: inode, Target: node}
+		}
+		if len(current.links) == 0 {
+			break
+		}
+		current, ok = s.nodes[current.links[0].parent]
+	}
+
+	return nil
+}
+
+// moveEntry changes the parent and the name of the directory entry of the node.
+func (s *Storage) moveEntry(n *node, from link, to link) {
+	for i := range n.links {
+		if n.links[i] == from {
+			n.links[i] = to
+			break
+		}
+	}
+	delete(s.nodes[from.parent].children, from.key)
+	s.nodes[to.parent].children[to.key] = n.descr.Inode
+	s.syncOrigin(n)
+}
+
+// removeEntry removes the directory entry. The node itself is removed with its last link.
+// Directories must be emptied before.
+func (s *Storage) removeEntry(n *node, entry link, now time.Time) {
+	delete(s.nodes[entry.parent].children, entry.key)
+	for i := range n.links {
+		if n.links[i] == entry {
+			n.links = append(n.links[:i], n.links[i+1:]...)
+			break
+		}
+	}
+
+	if len(n.links) > 0 {
+		n.descr.Nlink--
+		n.descr.Ctime = now
+		s.syncOrigin(n)
+		return
+	}
+
+	delete(s.nodes, n.descr.Inode)
+}
+
+func (s *Storage) syncOrigin(n *node) {
+	n.descr.Parent = sql.NullInt64{Int64: int64(n.links[0].parent), Valid: true}
+	n.descr.Name = n.links[0].name
+}
+
+// touchDirs updates the modification and change times of directories whose entries were changed.
+func (s *Storage) touchDirs(now time.Time, inodes ...db.Inode) {
+	for _, inode := range inodes {
+		if dir, ok := s.nodes[inode]; ok {
+			dir.descr.Mtime = now
+			dir.descr.Ctime = now
+		}
+	}
+}
+
+// sortedChildren returns the entries of the directory in the order of the SQL volumes: by type, then by name.
+func (s *Storage) sortedChildren(dir *node) []*db.Descriptor {
+	children := make([]*db.Descriptor, 0, len(dir.children))
+	for key, inode := range dir.children {
+		n := s.nodes[inode]
+		children = append(children, s.describe(n, dir.descr.Inode, n.findLink(dir.descr.Inode, key).name))
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].Type != children[j].Type {
+			return children[i].Type < children[j].Type
+		}
+		return children[i].Name < children[j].Name
+	})
+
+	return children
+}
+
+func (n *node) findLink(parent db.Inode, key string) link {
+	for _, l := range n.links {
+		if l.parent == parent && l.key == key {
+			return l
+		}
+	}
+
+	return link{}
+}
@@ -0,0 +1,71 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"github.com/kos-v/dbunderfs/internal/db/sqldb"
+	"strings"
+)
+
+// Dialect adapts the shared SQL instance and repositories to MySQL.
+type Dialect struct{}
+
+var _ sqldb.Dialect = Dialect{}
+
+func (Dialect) Name() string {
+	return "mysql"
+}
+
+func (Dialect) DriverName() string {
+	return "mysql"
+}
+
+func (Dialect) InitPool(pool *sql.DB) {
+	pool.Exec("SET max_allowed_packet = ?", 1024*1024*64)
+}
+
+func (Dialect) Rebind(query string) string {
+	return query
+}
+
+func (Dialect) LockRows() string {
+	return " FOR UPDATE"
+}
+
+func (Dialect) Substring(column string) string {
+	return "SUBSTRING(" + column + ", ?, ?)"
+}
+
+func (Dialect) Upsert(key string, columns ...string) string {
+	updates := make([]string, len(columns))
+	for i, column := range columns {
+		updates[i] = column + " = VALUES(" + column + ")"
+	}
+
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+}
+
+func (Dialect) InsertID(tx db.Queryer, query string, column string, args ...interface{}) (int64, error) {
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
@@ -26,6 +26,10 @@ func (d *DSN) GetDatabase() string {
 	return d.ParsedDSN.GetPath()
 }
 
+func (d *DSN) GetParam(name string) string {
+	return d.ParsedDSN.GetParam(name)
+}
+
 func (d *DSN) GetPrefix() string {
 	if !d.ParsedDSN.HasParam("prefix") {
 		return ""
@@ -33,6 +37,10 @@ func (d *DSN) GetPrefix() string {
 	return d.ParsedDSN.GetParam("prefix")
 }
 
+func (d *DSN) HasParam(name string) bool {
+	return d.ParsedDSN.HasParam(name)
+}
+
 func (d *DSN) ToString() string {
 	protocol := "tcp"
 	if d.ParsedDSN.HasParam("protocol") {
@@ -0,0 +1,85 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mysql
+
+import (
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"net"
+)
+
+const (
+	errLockWaitTimeout      = 1205
+	errLockDeadlock         = 1213
+	errServerShutdown       = 1053
+	errConnectionKilled     = 1927
+	errServerGoneAway       = 2006
+	errServerLostConnection = 2013
+
+	errBadNull          = 1048
+	errDupEntry         = 1062
+	errRowIsReferenced  = 1217
+	errNoReferencedRow  = 1216
+	errDataTooLong      = 1406
+	errRowIsReferenced2 = 1451
+	errNoReferencedRow2 = 1452
+	errCheckConstraint  = 3819
+)
+
+// ClassifyError tells the retry layer which MySQL errors are transient.
+func ClassifyError(err error) db.ErrorClass {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case errLockWaitTimeout, errLockDeadlock:
+			return db.ErrorRetryable
+		case errServerShutdown, errConnectionKilled, errServerGoneAway, errServerLostConnection:
+			return db.ErrorConnection
+		}
+		return db.ErrorPermanent
+	}
+
+	var netErr net.Error
+	if errors.Is(err, mysql.ErrInvalidConn) || errors.As(err, &netErr) {
+		return db.ErrorConnection
+	}
+
+	return db.ErrorPermanent
+}
+
+func (Dialect) TranslateError(err error, parent db.Inode, name string) error {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return err
+	}
+
+	switch mysqlErr.Number {
+	case errDupEntry:
+		return &db.ExistsError{Parent: parent, Name: name}
+	case errDataTooLong:
+		return &db.NameTooLongError{Name: name, MaxLength: db.MaxNameLength}
+	case errNoReferencedRow, errNoReferencedRow2:
+		return &db.NotFoundError{Inode: parent}
+	case errRowIsReferenced, errRowIsReferenced2:
+		return &db.NotEmptyError{Parent: parent, Name: name}
+	case errBadNull, errCheckConstraint:
+		return &db.ConstraintError{Err: err}
+	}
+
+	return err
+}
@@ -0,0 +1,79 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// PoolOptions are the limits of the connection pool of an instance. Zero values keep
+// the defaults of database/sql.
+type PoolOptions struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// ParsePoolOptions reads the pool limits from the DSN parameters max_open_conns, max_idle_conns
+// and conn_max_lifetime (a duration like "5m").
+func ParsePoolOptions(dsn DSN) (PoolOptions, error) {
+	opts := PoolOptions{}
+
+	var err error
+	if opts.MaxOpenConns, err = parseConnsParam(dsn, "max_open_conns"); err != nil {
+		return opts, err
+	}
+	if opts.MaxIdleConns, err = parseConnsParam(dsn, "max_idle_conns"); err != nil {
+		return opts, err
+	}
+	if dsn.HasParam("conn_max_lifetime") {
+		opts.ConnMaxLifetime, err = time.ParseDuration(dsn.GetParam("conn_max_lifetime"))
+		if err != nil || opts.ConnMaxLifetime < 0 {
+			return opts, fmt.Errorf("invalid conn_max_lifetime %q. Should be a duration like \"5m\"", dsn.GetParam("conn_max_lifetime"))
+		}
+	}
+
+	return opts, nil
+}
+
+func (opts PoolOptions) Apply(pool *sql.DB) {
+	if opts.MaxOpenConns > 0 {
+		pool.SetMaxOpenConns(opts.MaxOpenConns)
+	}
+	if opts.MaxIdleConns > 0 {
+		pool.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	if opts.ConnMaxLifetime > 0 {
+		pool.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	}
+}
+
+func parseConnsParam(dsn DSN, name string) (int, error) {
+	if !dsn.HasParam(name) {
+		return 0, nil
+	}
+
+	value, err := strconv.Atoi(dsn.GetParam(name))
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid %s %q. Should be a non-negative number", name, dsn.GetParam(name))
+	}
+
+	return value, nil
+}
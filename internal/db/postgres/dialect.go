@@ -0,0 +1,86 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package postgres
+
+import (
+	"database/sql"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"github.com/kos-v/dbunderfs/internal/db/sqldb"
+	"strconv"
+	"strings"
+)
+
+// Dialect adapts the shared SQL instance and repositories to PostgreSQL.
+type Dialect struct{}
+
+var _ sqldb.Dialect = Dialect{}
+
+func (Dialect) Name() string {
+	return "postgres"
+}
+
+func (Dialect) DriverName() string {
+	return "postgres"
+}
+
+func (Dialect) InitPool(pool *sql.DB) {}
+
+// Rebind converts "?" placeholders to the positional "$N" placeholders of PostgreSQL,
+// so queries can be written in the same way as for MySQL.
+func (Dialect) Rebind(query string) string {
+	var builder strings.Builder
+	inQuotes := false
+	placeholder := 0
+	for _, symbol := range query {
+		switch {
+		case symbol == '\'':
+			inQuotes = !inQuotes
+		case symbol == '?' && !inQuotes:
+			placeholder++
+			builder.WriteString("$" + strconv.Itoa(placeholder))
+			continue
+		}
+		builder.WriteRune(symbol)
+	}
+
+	return builder.String()
+}
+
+func (Dialect) LockRows() string {
+	return " FOR UPDATE"
+}
+
+func (Dialect) Substring(column string) string {
+	return "SUBSTRING(" + column + " FROM ? FOR ?)"
+}
+
+func (Dialect) Upsert(key string, columns ...string) string {
+	updates := make([]string, len(columns))
+	for i, column := range columns {
+		updates[i] = column + " = EXCLUDED." + column
+	}
+
+	return "ON CONFLICT (" + key + ") DO UPDATE SET " + strings.Join(updates, ", ")
+}
+
+// InsertID returns the generated value with the RETURNING clause, as lib/pq does not support LastInsertId.
+func (Dialect) InsertID(tx db.Queryer, query string, column string, args ...interface{}) (int64, error) {
+	var id int64
+	err := tx.QueryRow(query+" RETURNING "+column, args...).Scan(&id)
+
+	return id, err
+}
@@ -0,0 +1,77 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package postgres
+
+import (
+	"github.com/kos-v/dsnparser"
+	"strings"
+)
+
+type DSN struct {
+	ParsedDSN dsnparser.DSN
+}
+
+func (d *DSN) GetDatabase() string {
+	return d.ParsedDSN.GetPath()
+}
+
+func (d *DSN) GetParam(name string) string {
+	return d.ParsedDSN.GetParam(name)
+}
+
+func (d *DSN) GetPrefix() string {
+	if !d.ParsedDSN.HasParam("prefix") {
+		return ""
+	}
+	return d.ParsedDSN.GetParam("prefix")
+}
+
+func (d *DSN) HasParam(name string) bool {
+	return d.ParsedDSN.HasParam(name)
+}
+
+// ToString returns the connection string in the key/value format of lib/pq.
+func (d *DSN) ToString() string {
+	var params []string
+	if d.ParsedDSN.GetHost() != "" {
+		params = append(params, "host="+quoteConnValue(d.ParsedDSN.GetHost()))
+	}
+	if d.ParsedDSN.GetPath() != "" {
+		params = append(params, "dbname="+quoteConnValue(d.ParsedDSN.GetPath()))
+	}
+	if d.ParsedDSN.GetPort() != "" {
+		params = append(params, "port="+quoteConnValue(d.ParsedDSN.GetPort()))
+	}
+	if d.ParsedDSN.GetUser() != "" {
+		params = append(params, "user="+quoteConnValue(d.ParsedDSN.GetUser()))
+	}
+	if d.ParsedDSN.GetPassword() != "" {
+		params = append(params, "password="+quoteConnValue(d.ParsedDSN.GetPassword()))
+	}
+	if d.ParsedDSN.HasParam("sslmode") {
+		params = append(params, "sslmode="+quoteConnValue(d.ParsedDSN.GetParam("sslmode")))
+	}
+
+	return strings.Join(params, " ")
+}
+
+func quoteConnValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `'`, `\'`, -1)
+
+	return "'" + value + "'"
+}
@@ -0,0 +1,71 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package postgres
+
+import (
+	"errors"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"github.com/lib/pq"
+	"net"
+	"strings"
+)
+
+// ClassifyError tells the retry layer which PostgreSQL errors are transient.
+func ClassifyError(err error) db.ErrorClass {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code == "40P01", pqErr.Code == "40001", pqErr.Code == "55P03":
+			// deadlock_detected, serialization_failure, lock_not_available
+			return db.ErrorRetryable
+		case pqErr.Code.Class() == "08", pqErr.Code == "57P01", pqErr.Code == "57P02", pqErr.Code == "57P03":
+			// connection_exception, admin_shutdown, crash_shutdown, cannot_connect_now
+			return db.ErrorConnection
+		}
+		return db.ErrorPermanent
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return db.ErrorConnection
+	}
+
+	return db.ErrorPermanent
+}
+
+func (Dialect) TranslateError(err error, parent db.Inode, name string) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	switch pqErr.Code {
+	case "23505": // unique_violation
+		return &db.ExistsError{Parent: parent, Name: name}
+	case "22001": // string_data_right_truncation
+		return &db.NameTooLongError{Name: name, MaxLength: db.MaxNameLength}
+	case "23503": // foreign_key_violation
+		if strings.Contains(pqErr.Detail, "is still referenced") {
+			return &db.NotEmptyError{Parent: parent, Name: name}
+		}
+		return &db.NotFoundError{Inode: parent}
+	case "23502", "23514": // not_null_violation, check_violation
+		return &db.ConstraintError{Err: err}
+	}
+
+	return err
+}
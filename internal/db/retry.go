@@ -0,0 +1,183 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// ErrorClass tells how a failed query may be retried.
+type ErrorClass int
+
+const (
+	// ErrorPermanent is not retried.
+	ErrorPermanent ErrorClass = iota
+	// ErrorRetryable means that the statement had no effect (deadlock, lock wait timeout, busy database
+	// or a connection that failed before the statement was sent), so any operation may be repeated.
+	ErrorRetryable
+	// ErrorConnection means that the connection was lost and the outcome of the statement is unknown.
+	// The instance is reconnected, but only idempotent operations are repeated.
+	ErrorConnection
+)
+
+// ErrorClassifier returns the class of a driver error.
+type ErrorClassifier func(err error) ErrorClass
+
+type RetryPolicy struct {
+	// MaxRetries is the number of repeats of a failed operation. Zero disables retries.
+	MaxRetries int
+	// Backoff is the delay before the first repeat. The delay doubles with each repeat up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// RetryInstance repeats operations of the wrapped instance that failed with transient errors.
+// Reads are repeated on any transient error. Writes outside of a transaction are repeated only
+// when the statement surely had no effect. A transaction is repeated as a whole when it was rolled
+// back by the server, or when the connection was lost before the commit.
+type RetryInstance struct {
+	Instance
+	Classify ErrorClassifier
+	Policy   RetryPolicy
+
+	reconnectMu   sync.Mutex
+	lastReconnect time.Time
+}
+
+func (inst *RetryInstance) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return inst.ExecContext(context.Background(), query, args...)
+}
+
+func (inst *RetryInstance) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	for attempt := 0; ; attempt++ {
+		result, err := inst.Instance.ExecContext(ctx, query, args...)
+		if !inst.shouldRetry(ctx, err, attempt, false) {
+			return result, err
+		}
+	}
+}
+
+func (inst *RetryInstance) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return inst.QueryContext(context.Background(), query, args...)
+}
+
+func (inst *RetryInstance) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	for attempt := 0; ; attempt++ {
+		rows, err := inst.Instance.QueryContext(ctx, query, args...)
+		if !inst.shouldRetry(ctx, err, attempt, true) {
+			return rows, err
+		}
+	}
+}
+
+func (inst *RetryInstance) QueryRow(query string, args ...interface{}) *sql.Row {
+	return inst.QueryRowContext(context.Background(), query, args...)
+}
+
+func (inst *RetryInstance) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	for attempt := 0; ; attempt++ {
+		row := inst.Instance.QueryRowContext(ctx, query, args...)
+		if !inst.shouldRetry(ctx, row.Err(), attempt, true) {
+			return row
+		}
+	}
+}
+
+func (inst *RetryInstance) Transaction(ctx context.Context, handler TxHandler) error {
+	for attempt := 0; ; attempt++ {
+		committing := false
+		err := inst.Instance.Transaction(ctx, func(tx Queryer) error {
+			if err := handler(tx); err != nil {
+				return err
+			}
+			committing = true
+			return nil
+		})
+
+		if !inst.shouldRetry(ctx, err, attempt, !committing) {
+			return err
+		}
+	}
+}
+
+// shouldRetry classifies the error, reconnects the instance if the connection was lost and waits
+// for the backoff delay. It returns false if the operation must not be repeated.
+func (inst *RetryInstance) shouldRetry(ctx context.Context, err error, attempt int, idempotent bool) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+
+	class := ErrorPermanent
+	if errors.Is(err, driver.ErrBadConn) {
+		class = ErrorRetryable
+	} else if inst.Classify != nil {
+		class = inst.Classify(err)
+	}
+
+	if class == ErrorConnection {
+		inst.reconnect()
+	}
+	if class == ErrorPermanent || (class == ErrorConnection && !idempotent) || attempt >= inst.Policy.MaxRetries {
+		return false
+	}
+
+	log.Warnf("Transient database error, retry %d of %d: %s", attempt+1, inst.Policy.MaxRetries, err.Error())
+
+	timer := time.NewTimer(inst.backoff(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (inst *RetryInstance) backoff(attempt int) time.Duration {
+	delay := inst.Policy.Backoff
+	for i := 0; i < attempt && (inst.Policy.MaxBackoff <= 0 || delay < inst.Policy.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if inst.Policy.MaxBackoff > 0 && delay > inst.Policy.MaxBackoff {
+		delay = inst.Policy.MaxBackoff
+	}
+
+	return delay
+}
+
+// reconnect replaces the pool of the instance. Concurrent operations that lost their connections
+// at the same time share a single reconnect.
+func (inst *RetryInstance) reconnect() {
+	inst.reconnectMu.Lock()
+	defer inst.reconnectMu.Unlock()
+
+	if time.Since(inst.lastReconnect) < inst.Policy.Backoff {
+		return
+	}
+	inst.lastReconnect = time.Now()
+
+	log.Warnf("Database connection lost, reconnecting")
+	if _, err := inst.Instance.Reconnect(); err != nil {
+		log.Warnf("Error: %s", err.Error())
+	}
+}
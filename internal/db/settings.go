@@ -0,0 +1,122 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// SettingCaseInsensitive is the name of the setting that matches the names of entries regardless of case.
+	SettingCaseInsensitive = "case_insensitive"
+	// SettingCapacity is the name of the setting that limits the total size of the files.
+	SettingCapacity = "capacity"
+	// SettingMaxInodes is the name of the setting that limits the number of inodes.
+	SettingMaxInodes = "max_inodes"
+)
+
+// VolumeSettings are stored with the volume, so every mount of the volume uses the same settings.
+type VolumeSettings struct {
+	// CaseInsensitive makes the directory entries unique and matched by the case folds of their names.
+	// Names keep the case they were created with.
+	CaseInsensitive bool
+	// Limits are the capacity and the number of inodes of the volume.
+	Limits VolumeLimits
+}
+
+// LoadVolumeSettings reads the stored settings. Settings that were never set keep their defaults.
+func LoadVolumeSettings(ctx context.Context, repo SettingRepository) (VolumeSettings, error) {
+	settings := VolumeSettings{}
+
+	values, err := repo.FindAll(ctx)
+	if err != nil {
+		return settings, err
+	}
+	for name, value := range values {
+		if err := settings.set(name, value); err != nil {
+			return settings, err
+		}
+	}
+
+	return settings, nil
+}
+
+// SaveVolumeSetting validates and stores the setting. The case sensitivity can be changed only while
+// the volume is empty, as the entries are indexed by the keys of their names (see NameKey).
+func SaveVolumeSetting(ctx context.Context, registry RepositoryRegistry, name string, value string) error {
+	settings := VolumeSettings{}
+	if err := settings.set(name, value); err != nil {
+		return err
+	}
+
+	if name == SettingCaseInsensitive {
+		if err := checkEmptyVolume(ctx, registry.GetDescriptorRepository()); err != nil {
+			return fmt.Errorf("%s can be changed only on an empty volume: %w", name, err)
+		}
+	}
+
+	return registry.GetSettingRepository().Set(ctx, name, value)
+}
+
+func (s *VolumeSettings) set(name string, value string) error {
+	switch name {
+	case SettingCaseInsensitive:
+		caseInsensitive, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q. Should be true or false", name, value)
+		}
+		s.CaseInsensitive = caseInsensitive
+	case SettingCapacity:
+		capacity, err := parseSize(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q. Should be a number of bytes like \"10G\"", name, value)
+		}
+		s.Limits.Capacity = capacity
+	case SettingMaxInodes:
+		maxInodes, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q. Should be a non-negative number", name, value)
+		}
+		s.Limits.MaxInodes = maxInodes
+	default:
+		return fmt.Errorf("unknown volume setting %q", name)
+	}
+
+	return nil
+}
+
+func checkEmptyVolume(ctx context.Context, repo DescriptorRepository) error {
+	root, err := repo.FindRoot(ctx)
+	if err != nil {
+		return err
+	}
+	if root == nil {
+		return &NotFoundError{Name: RootName}
+	}
+
+	children, err := repo.FindChildrenByInode(ctx, root.GetInode())
+	if err != nil {
+		return err
+	}
+	if children.Len() > 0 {
+		return &NotEmptyError{Inode: root.GetInode()}
+	}
+
+	return nil
+}
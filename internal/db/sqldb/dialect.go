@@ -0,0 +1,48 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sqldb
+
+import (
+	"database/sql"
+	"github.com/kos-v/dbunderfs/internal/db"
+)
+
+// Dialect adapts the queries of the shared instance and repositories to a database. The queries
+// are written with "?" placeholders and the "{%prefix%}" table prefix.
+type Dialect interface {
+	// Name is the name of the database reported by the instance as its driver name.
+	Name() string
+	// DriverName is the name of the database/sql driver.
+	DriverName() string
+	// InitPool prepares a new connection pool.
+	InitPool(pool *sql.DB)
+	// Rebind converts the "?" placeholders of the query to the placeholders of the database.
+	Rebind(query string) string
+	// LockRows returns the clause that locks the selected rows until the end of the transaction.
+	// It is empty if a transaction holds the write lock of the whole database.
+	LockRows() string
+	// Substring returns the expression selecting a part of the binary column. The 1-based start
+	// and the length are passed as placeholders.
+	Substring(column string) string
+	// Upsert returns the clause of an INSERT statement that replaces the columns of the row with the same key.
+	Upsert(key string, columns ...string) string
+	// InsertID runs the INSERT statement and returns the value generated for the column.
+	InsertID(tx db.Queryer, query string, column string, args ...interface{}) (int64, error)
+	// TranslateError converts the constraint violations of a statement changing the entry parent/name
+	// to the typed errors of the db package. Other errors are returned as is.
+	TranslateError(err error, parent db.Inode, name string) error
+}
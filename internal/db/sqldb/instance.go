@@ -0,0 +1,265 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"strings"
+	"sync"
+)
+
+type NotConnectedError struct{}
+
+func (err *NotConnectedError) Error() string {
+	return "instance is not connected"
+}
+
+// Instance wraps a *sql.DB pool. Queries run concurrently on the pool connections,
+// the lock only guards the replacement of the pool on Connect and Reconnect.
+type Instance struct {
+	DSN     db.DSN
+	Dialect Dialect
+
+	sync.RWMutex
+	pool *pool
+	// closing counts the replaced pools that are not closed yet.
+	closing sync.WaitGroup
+}
+
+// pool counts the calls that use a *sql.DB, so that a replaced pool is closed only
+// after they return.
+type pool struct {
+	*sql.DB
+	users sync.WaitGroup
+}
+
+// Close closes the pool and waits until the pools replaced by Reconnect are closed.
+func (inst *Instance) Close() error {
+	var err error
+	if p := inst.getPool(); p != nil {
+		err = p.Close()
+	}
+	inst.closing.Wait()
+
+	return err
+}
+
+func (inst *Instance) Connect() (*sql.DB, error) {
+	if inst.HasConnection() {
+		return inst.getPool().DB, nil
+	}
+
+	sqlDB, err := inst.generatePool()
+	if err != nil {
+		return nil, err
+	}
+
+	inst.Lock()
+	inst.pool = &pool{DB: sqlDB}
+	inst.Unlock()
+
+	return sqlDB, nil
+}
+
+func (inst *Instance) GetDSN() db.DSN {
+	return inst.DSN
+}
+
+func (inst *Instance) Exec(query string, args ...interface{}) (sql.Result, error) {
+	p := inst.acquirePool()
+	defer p.users.Done()
+
+	return p.Exec(inst.prepareQuery(query), args...)
+}
+
+func (inst *Instance) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	p := inst.acquirePool()
+	defer p.users.Done()
+
+	return p.ExecContext(ctx, inst.prepareQuery(query), args...)
+}
+
+func (inst *Instance) GetDriverName() string {
+	return inst.Dialect.Name()
+}
+
+func (inst *Instance) HasConnection() bool {
+	if p := inst.getPool(); p != nil {
+		if err := p.Ping(); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reconnect replaces the pool with a new one. The calls that already took the old pool,
+// including the ones still waiting for a connection, finish on it before it is closed.
+func (inst *Instance) Reconnect() (*sql.DB, error) {
+	sqlDB, err := inst.generatePool()
+	if err != nil {
+		return nil, err
+	}
+
+	inst.Lock()
+	oldPool := inst.pool
+	inst.pool = &pool{DB: sqlDB}
+	inst.Unlock()
+
+	if oldPool != nil {
+		inst.closing.Add(1)
+		go func() {
+			defer inst.closing.Done()
+			oldPool.users.Wait()
+			oldPool.Close()
+		}()
+	}
+
+	return sqlDB, nil
+}
+
+func (inst *Instance) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	p := inst.acquirePool()
+	defer p.users.Done()
+
+	return p.Query(inst.prepareQuery(query), args...)
+}
+
+func (inst *Instance) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	p := inst.acquirePool()
+	defer p.users.Done()
+
+	return p.QueryContext(ctx, inst.prepareQuery(query), args...)
+}
+
+func (inst *Instance) QueryRow(query string, args ...interface{}) *sql.Row {
+	p := inst.acquirePool()
+	defer p.users.Done()
+
+	return p.QueryRow(inst.prepareQuery(query), args...)
+}
+
+func (inst *Instance) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	p := inst.acquirePool()
+	defer p.users.Done()
+
+	return p.QueryRowContext(ctx, inst.prepareQuery(query), args...)
+}
+
+func (inst *Instance) Transaction(ctx context.Context, handler db.TxHandler) error {
+	p := inst.acquirePool()
+	defer p.users.Done()
+
+	tx, err := p.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = handler(&Tx{ctx: ctx, instance: inst, tx: tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// acquirePool returns the current pool and counts the caller as its user. The caller
+// calls users.Done when it no longer needs the pool. Before Connect the queries run on
+// a pool that fails them with NotConnectedError.
+func (inst *Instance) acquirePool() *pool {
+	inst.RLock()
+	defer inst.RUnlock()
+
+	p := inst.pool
+	if p == nil {
+		p = notConnectedPool
+	}
+	p.users.Add(1)
+
+	return p
+}
+
+func (inst *Instance) getPool() *pool {
+	inst.RLock()
+	defer inst.RUnlock()
+
+	return inst.pool
+}
+
+func (inst *Instance) generatePool() (*sql.DB, error) {
+	pool, err := sql.Open(inst.Dialect.DriverName(), inst.DSN.ToString())
+	if err != nil {
+		return nil, err
+	}
+	if err = pool.Ping(); err != nil {
+		return nil, err
+	}
+
+	poolOpts, err := db.ParsePoolOptions(inst.DSN)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	poolOpts.Apply(pool)
+	inst.Dialect.InitPool(pool)
+
+	return pool, nil
+}
+
+func (inst *Instance) prepareQuery(query string) string {
+	return inst.Dialect.Rebind(strings.Replace(query, "{%prefix%}", inst.DSN.GetPrefix(), -1))
+}
+
+// Tx runs the queries of a transaction with the context the transaction was started with.
+type Tx struct {
+	ctx      context.Context
+	instance *Instance
+	tx       *sql.Tx
+}
+
+func (t *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.tx.ExecContext(t.ctx, t.instance.prepareQuery(query), args...)
+}
+
+func (t *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return t.tx.QueryContext(t.ctx, t.instance.prepareQuery(query), args...)
+}
+
+func (t *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return t.tx.QueryRowContext(t.ctx, t.instance.prepareQuery(query), args...)
+}
+
+// notConnectedPool has no connections, so the queries return NotConnectedError, including
+// the ones of QueryRow, which reports errors only through the returned row.
+var notConnectedPool = &pool{DB: sql.OpenDB(notConnectedConnector{})}
+
+type notConnectedConnector struct{}
+
+func (notConnectedConnector) Connect(context.Context) (driver.Conn, error) {
+	return nil, &NotConnectedError{}
+}
+
+func (c notConnectedConnector) Driver() driver.Driver {
+	return c
+}
+
+func (notConnectedConnector) Open(string) (driver.Conn, error) {
+	return nil, &NotConnectedError{}
+}
@@ -0,0 +1,967 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/kos-v/dbunderfs/internal/container"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"time"
+)
+
+const descriptorColumns = "inode, parent, name, type, size, permission, uid, gid, atime, mtime, ctime, crtime, target, nlink"
+
+// entryColumns and entryTables select descriptors by directory entries. An entry of a hard link
+// refers to the origin descriptor, which holds the attributes and the data of the file.
+const entryColumns = "o.inode, e.parent, e.name, o.type, o.size, o.permission, o.uid, o.gid, o.atime, o.mtime, o.ctime, o.crtime, o.target, o.nlink"
+const entryTables = "{%prefix%}descriptors e JOIN {%prefix%}descriptors o ON o.inode = COALESCE(e.origin, e.inode)"
+
+type DataBlockRepository struct {
+	instance  db.Instance
+	dialect   Dialect
+	blockSize uint32
+}
+
+func (repo *DataBlockRepository) Read(ctx context.Context, descr db.DescriptorInterface, offset uint64, size int) ([]byte, error) {
+	var fileSize uint64
+	var blockSize uint32
+	var inlineData []byte
+
+	row := repo.instance.QueryRowContext(ctx, `SELECT size, block_size, `+repo.dialect.Substring("fast_block")+` FROM {%prefix%}descriptors WHERE inode = ?`,
+		offset+1,
+		size,
+		descr.GetInode(),
+	)
+	if err := row.Scan(&fileSize, &blockSize, &inlineData); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &db.NotFoundError{Inode: descr.GetInode()}
+		}
+		return nil, err
+	}
+
+	if offset >= fileSize || size <= 0 {
+		return []byte{}, nil
+	}
+	end := offset + uint64(size)
+	if end > fileSize {
+		end = fileSize
+	}
+
+	if blockSize == 0 {
+		if uint64(len(inlineData)) > end-offset {
+			inlineData = inlineData[:end-offset]
+		}
+		return inlineData, nil
+	}
+
+	bs := uint64(blockSize)
+	rows, err := repo.instance.QueryContext(ctx, `SELECT idx, data FROM {%prefix%}blocks WHERE inode = ? AND idx BETWEEN ? AND ?`,
+		descr.GetInode(),
+		offset/bs,
+		(end-1)/bs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	data := make([]byte, end-offset)
+	for rows.Next() {
+		var idx uint64
+		var block []byte
+		if err := rows.Scan(&idx, &block); err != nil {
+			return nil, err
+		}
+
+		blockStart := idx * bs
+		if blockStart < offset {
+			skip := offset - blockStart
+			if skip >= uint64(len(block)) {
+				continue
+			}
+			copy(data, block[skip:])
+		} else {
+			copy(data[blockStart-offset:], block)
+		}
+	}
+
+	return data, rows.Err()
+}
+
+func (repo *DataBlockRepository) Truncate(ctx context.Context, descr db.DescriptorInterface, size uint64) error {
+	return repo.instance.Transaction(ctx, func(tx db.Queryer) error {
+		_, blockSize, err := repo.lockDescriptor(tx, descr.GetInode())
+		if err != nil {
+			return err
+		}
+
+		if blockSize == 0 && size > uint64(repo.blockSize) {
+			if blockSize, err = repo.convertToBlocks(tx, descr.GetInode()); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now().UnixNano()
+		if blockSize == 0 {
+			var data []byte
+			if err := tx.QueryRow(`SELECT fast_block FROM {%prefix%}descriptors WHERE inode = ?`, descr.GetInode()).Scan(&data); err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(`UPDATE {%prefix%}descriptors SET fast_block = ?, size = ?, mtime = ?, ctime = ? WHERE inode = ?`,
+				db.Resize(data, size),
+				size,
+				now,
+				now,
+				descr.GetInode(),
+			)
+			return err
+		}
+
+		blocksCount := (size + uint64(blockSize) - 1) / uint64(blockSize)
+		if _, err = tx.Exec(`DELETE FROM {%prefix%}blocks WHERE inode = ? AND idx >= ?`, descr.GetInode(), blocksCount); err != nil {
+			return err
+		}
+		if blocksCount > 0 {
+			lastBlockSize := size - (blocksCount-1)*uint64(blockSize)
+			_, err = tx.Exec(`UPDATE {%prefix%}blocks SET data = `+repo.dialect.Substring("data")+` WHERE inode = ? AND idx = ?`,
+				1,
+				lastBlockSize,
+				descr.GetInode(),
+				blocksCount-1,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(`UPDATE {%prefix%}descriptors SET size = ?, mtime = ?, ctime = ? WHERE inode = ?`,
+			size,
+			now,
+			now,
+			descr.GetInode(),
+		)
+		return err
+	})
+}
+
+func (repo *DataBlockRepository) Append(ctx context.Context, descr db.DescriptorInterface, data []byte) (int, error) {
+	return repo.write(ctx, descr, 0, data, true)
+}
+
+func (repo *DataBlockRepository) Write(ctx context.Context, descr db.DescriptorInterface, offset uint64, data []byte) (int, error) {
+	return repo.write(ctx, descr, offset, data, false)
+}
+
+// write stores the data at the offset, or at the end of the file if appending is true. The size
+// of an appended file is read under the descriptor lock, so concurrent appends do not overlap.
+func (repo *DataBlockRepository) write(ctx context.Context, descr db.DescriptorInterface, offset uint64, data []byte, appending bool) (int, error) {
+	err := repo.instance.Transaction(ctx, func(tx db.Queryer) error {
+		size, blockSize, err := repo.lockDescriptor(tx, descr.GetInode())
+		if err != nil {
+			return err
+		}
+		if appending {
+			offset = size
+		}
+
+		newSize := offset + uint64(len(data))
+		if newSize < size {
+			newSize = size
+		}
+
+		if blockSize == 0 && newSize > uint64(repo.blockSize) {
+			if blockSize, err = repo.convertToBlocks(tx, descr.GetInode()); err != nil {
+				return err
+			}
+		}
+
+		if blockSize == 0 {
+			err = repo.writeInline(tx, descr.GetInode(), offset, data)
+		} else {
+			err = repo.writeBlocks(tx, descr.GetInode(), blockSize, offset, data)
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UnixNano()
+		_, err = tx.Exec(`UPDATE {%prefix%}descriptors SET size = ?, mtime = ?, ctime = ? WHERE inode = ?`,
+			newSize,
+			now,
+			now,
+			descr.GetInode(),
+		)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+// convertToBlocks moves the inline data of the descriptor from fast_block to the blocks table.
+// Volumes created before the blocks table existed are converted this way on the first write
+// that outgrows the inline limit.
+func (repo *DataBlockRepository) convertToBlocks(tx db.Queryer, inode db.Inode) (uint32, error) {
+	var data []byte
+	if err := tx.QueryRow(`SELECT fast_block FROM {%prefix%}descriptors WHERE inode = ?`, inode).Scan(&data); err != nil {
+		return 0, err
+	}
+
+	blockSize := uint64(repo.blockSize)
+	for idx := uint64(0); idx*blockSize < uint64(len(data)); idx++ {
+		end := (idx + 1) * blockSize
+		if end > uint64(len(data)) {
+			end = uint64(len(data))
+		}
+		if err := repo.saveBlock(tx, inode, idx, data[idx*blockSize:end]); err != nil {
+			return 0, err
+		}
+	}
+
+	_, err := tx.Exec(`UPDATE {%prefix%}descriptors SET fast_block = NULL, block_size = ? WHERE inode = ?`, repo.blockSize, inode)
+	if err != nil {
+		return 0, err
+	}
+
+	return repo.blockSize, nil
+}
+
+func (repo *DataBlockRepository) lockDescriptor(tx db.Queryer, inode db.Inode) (uint64, uint32, error) {
+	var size uint64
+	var blockSize uint32
+
+	row := tx.QueryRow(`SELECT size, block_size FROM {%prefix%}descriptors WHERE inode = ?`+repo.dialect.LockRows(), inode)
+	if err := row.Scan(&size, &blockSize); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, 0, &db.NotFoundError{Inode: inode}
+		}
+		return 0, 0, err
+	}
+
+	return size, blockSize, nil
+}
+
+func (repo *DataBlockRepository) saveBlock(tx db.Queryer, inode db.Inode, idx uint64, data []byte) error {
+	_, err := tx.Exec(`
+		INSERT INTO {%prefix%}blocks (inode, idx, data) VALUES (?, ?, ?)
+		`+repo.dialect.Upsert("inode, idx", "data"),
+		inode,
+		idx,
+		data,
+	)
+
+	return err
+}
+
+func (repo *DataBlockRepository) writeBlocks(tx db.Queryer, inode db.Inode, blockSize uint32, offset uint64, data []byte) error {
+	bs := uint64(blockSize)
+	end := offset + uint64(len(data))
+
+	for idx := offset / bs; idx*bs < end; idx++ {
+		blockStart := idx * bs
+		from := offset
+		if from < blockStart {
+			from = blockStart
+		}
+		to := end
+		if to > blockStart+bs {
+			to = blockStart + bs
+		}
+
+		var block []byte
+		if from > blockStart || to < blockStart+bs {
+			row := tx.QueryRow(`SELECT data FROM {%prefix%}blocks WHERE inode = ? AND idx = ?`, inode, idx)
+			if err := row.Scan(&block); err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		}
+
+		block = db.Splice(block, from-blockStart, data[from-offset:to-offset])
+		if err := repo.saveBlock(tx, inode, idx, block); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (repo *DataBlockRepository) writeInline(tx db.Queryer, inode db.Inode, offset uint64, data []byte) error {
+	var block []byte
+	if err := tx.QueryRow(`SELECT fast_block FROM {%prefix%}descriptors WHERE inode = ?`, inode).Scan(&block); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`UPDATE {%prefix%}descriptors SET fast_block = ? WHERE inode = ?`, db.Splice(block, offset, data), inode)
+	return err
+}
+
+// DescriptorRepository looks the entries up by the folded_name column, which holds db.NameKey
+// of the name and is unique within a directory.
+type DescriptorRepository struct {
+	instance        db.Instance
+	dialect         Dialect
+	caseInsensitive bool
+}
+
+// entry is a row of the descriptors table locked for a modification of the directory tree.
+// For hard links id is the identifier of the link row and inode is the origin descriptor.
+type entry struct {
+	id    db.Inode
+	inode db.Inode
+	dType db.DescriptorType
+}
+
+func (dr *DescriptorRepository) Create(ctx context.Context, parent db.Inode, name string, dType db.DescriptorType, attrs db.DescriptorAttrs) (db.DescriptorInterface, error) {
+	return dr.create(ctx, parent, name, dType, sql.NullString{}, attrs)
+}
+
+func (dr *DescriptorRepository) CreateSymlink(ctx context.Context, parent db.Inode, name string, target string, attrs db.DescriptorAttrs) (db.DescriptorInterface, error) {
+	return dr.create(ctx, parent, name, db.DT_Symlink, sql.NullString{String: target, Valid: true}, attrs)
+}
+
+func (dr *DescriptorRepository) FindChildrenByInode(ctx context.Context, parentInode db.Inode) (container.CollectionInterface, error) {
+	rows, err := dr.instance.QueryContext(ctx, `
+		SELECT `+entryColumns+`
+		FROM `+entryTables+`
+		WHERE e.parent = ?
+		ORDER BY e.type, e.name`, parentInode,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collection := container.Collection{}
+	for rows.Next() {
+		descr, err := dr.hydrateDescriptor(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		collection.Append(descr)
+	}
+
+	return &collection, nil
+}
+
+func (dr *DescriptorRepository) FindRoot(ctx context.Context) (db.DescriptorInterface, error) {
+	row := dr.instance.QueryRowContext(ctx, `
+		SELECT `+descriptorColumns+`
+		FROM {%prefix%}descriptors
+		WHERE parent IS NULL AND name = ?`, db.RootName,
+	)
+
+	descr, err := dr.hydrateDescriptor(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if descr.GetType() != db.DT_Dir {
+		return nil, nil
+	}
+
+	return descr, nil
+}
+
+func (dr *DescriptorRepository) FindSingleByInode(ctx context.Context, inode db.Inode) (db.DescriptorInterface, error) {
+	row := dr.instance.QueryRowContext(ctx, `
+		SELECT `+descriptorColumns+`
+		FROM {%prefix%}descriptors 
+		WHERE inode = ?`, inode,
+	)
+
+	descr, err := dr.hydrateDescriptor(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return descr, nil
+}
+
+func (dr *DescriptorRepository) FindSingleByName(ctx context.Context, parent db.Inode, target string) (db.DescriptorInterface, error) {
+	row := dr.instance.QueryRowContext(ctx, `
+		SELECT `+entryColumns+`
+		FROM `+entryTables+`
+		WHERE e.parent = ? AND e.folded_name = ?`, parent, dr.nameKey(target),
+	)
+
+	descr, err := dr.hydrateDescriptor(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return descr, nil
+}
+
+func (dr *DescriptorRepository) GetUsage(ctx context.Context) (db.VolumeUsage, error) {
+	usage := db.VolumeUsage{}
+	row := dr.instance.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(size), 0), COUNT(*)
+		FROM {%prefix%}descriptors
+		WHERE origin IS NULL`,
+	)
+	if err := row.Scan(&usage.Bytes, &usage.Inodes); err != nil {
+		return usage, err
+	}
+
+	return usage, nil
+}
+
+func (dr *DescriptorRepository) IsExistsByName(ctx context.Context, parent db.Inode, name string) (bool, error) {
+	descr, err := dr.FindSingleByName(ctx, parent, name)
+	if err != nil {
+		return false, err
+	}
+
+	return descr != nil, nil
+}
+
+func (dr *DescriptorRepository) Link(ctx context.Context, inode db.Inode, newParent db.Inode, newName string) (db.DescriptorInterface, error) {
+	if err := db.CheckNameLength(newName); err != nil {
+		return nil, err
+	}
+
+	err := dr.instance.Transaction(ctx, func(tx db.Queryer) error {
+		var dType db.DescriptorType
+		row := tx.QueryRow(`SELECT type FROM {%prefix%}descriptors WHERE inode = ? AND origin IS NULL`+dr.dialect.LockRows(), inode)
+		if err := row.Scan(&dType); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return &db.NotFoundError{Inode: inode}
+			}
+			return err
+		}
+		if dType == db.DT_Dir {
+			return &db.IsDirError{Inode: inode}
+		}
+
+		_, err := tx.Exec(`
+			INSERT INTO {%prefix%}descriptors (parent, origin, name, folded_name, type, permission, uid, gid)
+			VALUES (?, ?, ?, ?, ?, '0000', 0, 0)`,
+			newParent,
+			inode,
+			newName,
+			dr.nameKey(newName),
+			dType,
+		)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UnixNano()
+		if _, err = tx.Exec(`UPDATE {%prefix%}descriptors SET nlink = nlink + 1, ctime = ? WHERE inode = ?`, now, inode); err != nil {
+			return err
+		}
+
+		return dr.touchDirs(tx, now, newParent)
+	})
+	if err != nil {
+		return nil, dr.dialect.TranslateError(err, newParent, newName)
+	}
+
+	return dr.FindSingleByName(ctx, newParent, newName)
+}
+
+func (dr *DescriptorRepository) RemoveByName(ctx context.Context, parent db.Inode, name string, dir bool) error {
+	err := dr.instance.Transaction(ctx, func(tx db.Queryer) error {
+		target, err := dr.lockEntry(tx, parent, name)
+		if err != nil {
+			return err
+		}
+		if target == nil {
+			return &db.NotFoundError{Parent: parent, Name: name}
+		}
+		if err := dr.checkRemovable(tx, target, dir); err != nil {
+			return err
+		}
+
+		now := time.Now().UnixNano()
+		if err := dr.removeEntry(tx, now, target); err != nil {
+			return err
+		}
+
+		return dr.touchDirs(tx, now, parent)
+	})
+
+	return dr.dialect.TranslateError(err, parent, name)
+}
+
+func (dr *DescriptorRepository) Rename(ctx context.Context, oldParent db.Inode, oldName string, newParent db.Inode, newName string) error {
+	if err := db.CheckNameLength(newName); err != nil {
+		return err
+	}
+
+	err := dr.instance.Transaction(ctx, func(tx db.Queryer) error {
+		source, err := dr.lockEntry(tx, oldParent, oldName)
+		if err != nil {
+			return err
+		}
+		if source == nil {
+			return &db.NotFoundError{Parent: oldParent, Name: oldName}
+		}
+
+		if oldParent == newParent && oldName == newName {
+			return nil
+		}
+
+		if source.dType == db.DT_Dir && oldParent != newParent {
+			if err := dr.checkNotAncestor(tx, source.inode, newParent); err != nil {
+				return err
+			}
+		}
+
+		target, err := dr.lockEntry(tx, newParent, newName)
+		if err != nil {
+			return err
+		}
+
+		// On case-insensitive volumes the target is the source itself when only the case of the name changes.
+		now := time.Now().UnixNano()
+		if target != nil && target.id != source.id {
+			if target.inode == source.inode {
+				return nil
+			}
+
+			if err := dr.checkReplaceable(tx, source, target); err != nil {
+				return err
+			}
+
+			if err := dr.removeEntry(tx, now, target); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(`UPDATE {%prefix%}descriptors SET parent = ?, name = ?, folded_name = ? WHERE inode = ?`,
+			newParent,
+			newName,
+			dr.nameKey(newName),
+			source.id,
+		)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(`UPDATE {%prefix%}descriptors SET ctime = ? WHERE inode = ?`, now, source.inode); err != nil {
+			return err
+		}
+
+		return dr.touchDirs(tx, now, oldParent, newParent)
+	})
+
+	return dr.dialect.TranslateError(err, newParent, newName)
+}
+
+func (dr *DescriptorRepository) UpdateAtime(ctx context.Context, inode db.Inode, relative bool) error {
+	now := time.Now()
+	if !relative {
+		_, err := dr.instance.ExecContext(ctx, `UPDATE {%prefix%}descriptors SET atime = ? WHERE inode = ?`, now.UnixNano(), inode)
+		return err
+	}
+
+	_, err := dr.instance.ExecContext(ctx, `
+		UPDATE {%prefix%}descriptors
+		SET atime = ?
+		WHERE inode = ? AND (atime <= mtime OR atime <= ctime OR atime < ?)`,
+		now.UnixNano(),
+		inode,
+		now.Add(-db.RelatimeInterval).UnixNano(),
+	)
+
+	return err
+}
+
+func (dr *DescriptorRepository) UpdateAttrs(ctx context.Context, inode db.Inode, attrs db.DescriptorAttrs) error {
+	_, err := dr.instance.ExecContext(ctx, `
+		UPDATE {%prefix%}descriptors
+		SET permission = ?, uid = ?, gid = ?, atime = ?, mtime = ?, ctime = ?
+		WHERE inode = ?`,
+		attrs.Permission,
+		attrs.UID,
+		attrs.GID,
+		attrs.Atime.UnixNano(),
+		attrs.Mtime.UnixNano(),
+		time.Now().UnixNano(),
+		inode,
+	)
+
+	return err
+}
+
+func (dr *DescriptorRepository) create(ctx context.Context, parent db.Inode, name string, dType db.DescriptorType, target sql.NullString, attrs db.DescriptorAttrs) (db.DescriptorInterface, error) {
+	if err := db.CheckNameLength(name); err != nil {
+		return nil, err
+	}
+
+	var inode int64
+	err := dr.instance.Transaction(ctx, func(tx db.Queryer) error {
+		now := time.Now().UnixNano()
+		sqlStatement := `
+		INSERT INTO {%prefix%}descriptors (parent, name, folded_name, type, size, permission, uid, gid, atime, mtime, ctime, crtime, target)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+		var err error
+		inode, err = dr.dialect.InsertID(tx, sqlStatement, "inode",
+			parent,
+			name,
+			dr.nameKey(name),
+			dType,
+			attrs.Size,
+			attrs.Permission,
+			attrs.UID,
+			attrs.GID,
+			now,
+			now,
+			now,
+			now,
+			target,
+		)
+		if err != nil {
+			return err
+		}
+
+		return dr.touchDirs(tx, now, parent)
+	})
+	if err != nil {
+		return nil, dr.dialect.TranslateError(err, parent, name)
+	}
+
+	descr, err := dr.FindSingleByInode(ctx, db.Inode(inode))
+	if err != nil {
+		return nil, err
+	}
+
+	return descr, nil
+}
+
+// checkNotAncestor walks up from the node to the root and fails if inode is one of its ancestors
+// (or the node itself).
+func (dr *DescriptorRepository) checkNotAncestor(tx db.Queryer, inode db.Inode, node db.Inode) error {
+	current := sql.NullInt64{Int64: int64(node), Valid: true}
+	for current.Valid {
+		if db.Inode(current.Int64) == inode {
+			return &db.SubtreeMoveError{Inode: inode, Target: node}
+		}
+
+		row := tx.QueryRow(`SELECT parent FROM {%prefix%}descriptors WHERE inode = ?`, current.Int64)
+		if err := row.Scan(&current); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkReplaceable verifies that the target of a rename can be overwritten by the source.
+func (dr *DescriptorRepository) checkReplaceable(tx db.Queryer, source *entry, target *entry) error {
+	if source.dType != db.DT_Dir && target.dType == db.DT_Dir {
+		return &db.IsDirError{Inode: target.inode}
+	}
+	if source.dType == db.DT_Dir && target.dType != db.DT_Dir {
+		return &db.NotDirError{Inode: target.inode}
+	}
+
+	if target.dType == db.DT_Dir {
+		return dr.checkEmpty(tx, target.inode)
+	}
+
+	return nil
+}
+
+// checkRemovable verifies that the kind of the entry matches the removal: rmdir removes only empty
+// directories, unlink removes anything but directories.
+func (dr *DescriptorRepository) checkRemovable(tx db.Queryer, target *entry, dir bool) error {
+	if !dir && target.dType == db.DT_Dir {
+		return &db.IsDirError{Inode: target.inode}
+	}
+	if dir && target.dType != db.DT_Dir {
+		return &db.NotDirError{Inode: target.inode}
+	}
+
+	if dir {
+		return dr.checkEmpty(tx, target.inode)
+	}
+
+	return nil
+}
+
+func (dr *DescriptorRepository) checkEmpty(tx db.Queryer, inode db.Inode) error {
+	var childrenCount int
+	row := tx.QueryRow(`SELECT COUNT(*) FROM {%prefix%}descriptors WHERE parent = ?`, inode)
+	if err := row.Scan(&childrenCount); err != nil {
+		return err
+	}
+	if childrenCount > 0 {
+		return &db.NotEmptyError{Inode: inode}
+	}
+
+	return nil
+}
+
+func (dr *DescriptorRepository) hydrateDescriptor(row interface{}) (db.DescriptorInterface, error) {
+	var scanner interface {
+		Scan(dest ...interface{}) error
+	}
+
+	switch row.(type) {
+	case *sql.Row:
+		scanner = row.(*sql.Row)
+	case *sql.Rows:
+		scanner = row.(*sql.Rows)
+	default:
+		return nil, fmt.Errorf("type \"%T\" not support for row argument", row)
+	}
+
+	descr := db.Descriptor{}
+	var atime, mtime, ctime, crtime int64
+	err := scanner.Scan(
+		&descr.Inode,
+		&descr.Parent,
+		&descr.Name,
+		&descr.Type,
+		&descr.Size,
+		&descr.Permission,
+		&descr.UID,
+		&descr.GID,
+		&atime,
+		&mtime,
+		&ctime,
+		&crtime,
+		&descr.Target,
+		&descr.Nlink,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	descr.Atime = time.Unix(0, atime)
+	descr.Mtime = time.Unix(0, mtime)
+	descr.Ctime = time.Unix(0, ctime)
+	descr.Crtime = time.Unix(0, crtime)
+
+	return &descr, nil
+}
+
+func (dr *DescriptorRepository) lockEntry(tx db.Queryer, parent db.Inode, name string) (*entry, error) {
+	e := entry{}
+	row := tx.QueryRow(`
+		SELECT inode, COALESCE(origin, inode), type
+		FROM {%prefix%}descriptors
+		WHERE parent = ? AND folded_name = ?`+dr.dialect.LockRows(), parent, dr.nameKey(name),
+	)
+	if err := row.Scan(&e.id, &e.inode, &e.dType); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// removeEntry removes the directory entry. The descriptor itself is removed with its last link.
+// If the entry of the origin descriptor is removed while other links exist, the origin takes over
+// the place of one of the links, so the inode stays the same.
+func (dr *DescriptorRepository) removeEntry(tx db.Queryer, now int64, e *entry) error {
+	if e.id != e.inode {
+		if _, err := tx.Exec(`DELETE FROM {%prefix%}descriptors WHERE inode = ?`, e.id); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(`UPDATE {%prefix%}descriptors SET nlink = nlink - 1, ctime = ? WHERE inode = ?`, now, e.inode)
+		return err
+	}
+
+	var linkID db.Inode
+	var linkParent db.Inode
+	var linkName, linkKey string
+	row := tx.QueryRow(`SELECT inode, parent, name, folded_name FROM {%prefix%}descriptors WHERE origin = ? LIMIT 1`+dr.dialect.LockRows(), e.inode)
+	if err := row.Scan(&linkID, &linkParent, &linkName, &linkKey); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		_, err = tx.Exec(`DELETE FROM {%prefix%}descriptors WHERE inode = ?`, e.inode)
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM {%prefix%}descriptors WHERE inode = ?`, linkID); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`UPDATE {%prefix%}descriptors SET parent = ?, name = ?, folded_name = ?, nlink = nlink - 1, ctime = ? WHERE inode = ?`,
+		linkParent,
+		linkName,
+		linkKey,
+		now,
+		e.inode,
+	)
+	return err
+}
+
+func (dr *DescriptorRepository) nameKey(name string) string {
+	return db.NameKey(name, dr.caseInsensitive)
+}
+
+// touchDirs updates the modification and change times of directories whose entries were changed.
+func (dr *DescriptorRepository) touchDirs(tx db.Queryer, now int64, inodes ...db.Inode) error {
+	for _, inode := range inodes {
+		if _, err := tx.Exec(`UPDATE {%prefix%}descriptors SET mtime = ?, ctime = ? WHERE inode = ?`, now, now, inode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type SettingRepository struct {
+	instance db.Instance
+	dialect  Dialect
+}
+
+func (repo *SettingRepository) FindAll(ctx context.Context) (map[string]string, error) {
+	rows, err := repo.instance.QueryContext(ctx, `SELECT name, value FROM {%prefix%}settings`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := map[string]string{}
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		settings[name] = value
+	}
+
+	return settings, rows.Err()
+}
+
+func (repo *SettingRepository) Set(ctx context.Context, name string, value string) error {
+	_, err := repo.instance.ExecContext(ctx, `
+		INSERT INTO {%prefix%}settings (name, value) VALUES (?, ?)
+		`+repo.dialect.Upsert("name", "value"),
+		name,
+		value,
+	)
+
+	return err
+}
+
+type XattrRepository struct {
+	instance db.Instance
+	dialect  Dialect
+}
+
+func (repo *XattrRepository) Find(ctx context.Context, inode db.Inode, name string) ([]byte, error) {
+	var value []byte
+	row := repo.instance.QueryRowContext(ctx, `SELECT value FROM {%prefix%}xattrs WHERE inode = ? AND name = ?`, inode, name)
+	if err := row.Scan(&value); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &db.XattrNotFoundError{Inode: inode, Name: name}
+		}
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func (repo *XattrRepository) FindNames(ctx context.Context, inode db.Inode) ([]string, error) {
+	rows, err := repo.instance.QueryContext(ctx, `SELECT name FROM {%prefix%}xattrs WHERE inode = ? ORDER BY name`, inode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+func (repo *XattrRepository) Remove(ctx context.Context, inode db.Inode, name string) error {
+	return repo.instance.Transaction(ctx, func(tx db.Queryer) error {
+		result, err := tx.Exec(`DELETE FROM {%prefix%}xattrs WHERE inode = ? AND name = ?`, inode, name)
+		if err != nil {
+			return err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return &db.XattrNotFoundError{Inode: inode, Name: name}
+		}
+
+		_, err = tx.Exec(`UPDATE {%prefix%}descriptors SET ctime = ? WHERE inode = ?`, time.Now().UnixNano(), inode)
+		return err
+	})
+}
+
+func (repo *XattrRepository) Set(ctx context.Context, inode db.Inode, name string, value []byte, flags db.XattrSetFlags) error {
+	return repo.instance.Transaction(ctx, func(tx db.Queryer) error {
+		exists := true
+		row := tx.QueryRow(`SELECT name FROM {%prefix%}xattrs WHERE inode = ? AND name = ?`+repo.dialect.LockRows(), inode, name)
+		if err := row.Scan(new(string)); err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			exists = false
+		}
+
+		if exists && flags&db.XattrCreate != 0 {
+			return &db.XattrExistsError{Inode: inode, Name: name}
+		}
+		if !exists && flags&db.XattrReplace != 0 {
+			return &db.XattrNotFoundError{Inode: inode, Name: name}
+		}
+
+		_, err := tx.Exec(`
+			INSERT INTO {%prefix%}xattrs (inode, name, value) VALUES (?, ?, ?)
+			`+repo.dialect.Upsert("inode, name", "value"),
+			inode,
+			name,
+			value,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`UPDATE {%prefix%}descriptors SET ctime = ? WHERE inode = ?`, time.Now().UnixNano(), inode)
+		return err
+	})
+}
@@ -0,0 +1,43 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sqldb
+
+import "github.com/kos-v/dbunderfs/internal/db"
+
+type RepositoryRegistry struct {
+	BlockSize uint32
+	Instance  db.Instance
+	Dialect   Dialect
+	// CaseInsensitive must match the case_insensitive setting of the volume, see db.VolumeSettings.
+	CaseInsensitive bool
+}
+
+func (f *RepositoryRegistry) GetDataBlockRepository() db.DataBlockRepository {
+	return &DataBlockRepository{instance: f.Instance, dialect: f.Dialect, blockSize: f.BlockSize}
+}
+
+func (f *RepositoryRegistry) GetDescriptorRepository() db.DescriptorRepository {
+	return &DescriptorRepository{instance: f.Instance, dialect: f.Dialect, caseInsensitive: f.CaseInsensitive}
+}
+
+func (f *RepositoryRegistry) GetSettingRepository() db.SettingRepository {
+	return &SettingRepository{instance: f.Instance, dialect: f.Dialect}
+}
+
+func (f *RepositoryRegistry) GetXattrRepository() db.XattrRepository {
+	return &XattrRepository{instance: f.Instance, dialect: f.Dialect}
+}
@@ -0,0 +1,71 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sqlite
+
+import (
+	"database/sql"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"github.com/kos-v/dbunderfs/internal/db/sqldb"
+	"strings"
+)
+
+// Dialect adapts the shared SQL instance and repositories to SQLite.
+type Dialect struct{}
+
+var _ sqldb.Dialect = Dialect{}
+
+func (Dialect) Name() string {
+	return "sqlite"
+}
+
+func (Dialect) DriverName() string {
+	return "sqlite3"
+}
+
+func (Dialect) InitPool(pool *sql.DB) {}
+
+func (Dialect) Rebind(query string) string {
+	return query
+}
+
+// LockRows returns no clause. Transactions are immediate (see requiredParams), so a transaction holds
+// the write lock of the database from the start and the rows to modify are read without row locks.
+func (Dialect) LockRows() string {
+	return ""
+}
+
+func (Dialect) Substring(column string) string {
+	return "substr(" + column + ", ?, ?)"
+}
+
+func (Dialect) Upsert(key string, columns ...string) string {
+	updates := make([]string, len(columns))
+	for i, column := range columns {
+		updates[i] = column + " = EXCLUDED." + column
+	}
+
+	return "ON CONFLICT (" + key + ") DO UPDATE SET " + strings.Join(updates, ", ")
+}
+
+func (Dialect) InsertID(tx db.Queryer, query string, column string, args ...interface{}) (int64, error) {
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
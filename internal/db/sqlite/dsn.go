@@ -0,0 +1,104 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sqlite
+
+import (
+	"github.com/kos-v/dsnparser"
+	"net/url"
+	"strings"
+)
+
+// requiredParams are set whatever the DSN says. Foreign keys are needed for the cascade deletion
+// of blocks, immediate transactions serialize concurrent writers.
+var requiredParams = map[string]string{
+	"_foreign_keys": "1",
+	"_txlock":       "immediate",
+}
+
+// defaultParams are set unless the DSN sets them. The busy timeout makes concurrent writers wait
+// for each other instead of failing.
+var defaultParams = map[string]string{
+	"_busy_timeout": "5000",
+	"_journal_mode": "WAL",
+}
+
+// ownParams are the DSN parameters read by dbfs itself, they are not passed to the driver.
+var ownParams = map[string]bool{
+	"prefix":            true,
+	"block_size":        true,
+	"max_open_conns":    true,
+	"max_idle_conns":    true,
+	"conn_max_lifetime": true,
+}
+
+type DSN struct {
+	ParsedDSN dsnparser.DSN
+}
+
+// GetDatabase returns the path of the database file. The path is taken from the raw DSN,
+// so "sqlite:///var/lib/dbfs/vol.db" is absolute and "sqlite://vol.db" is relative.
+func (d *DSN) GetDatabase() string {
+	path := d.ParsedDSN.GetRaw()
+	if pos := strings.Index(path, "://"); pos != -1 {
+		path = path[pos+3:]
+	}
+	if pos := strings.Index(path, "?"); pos != -1 {
+		path = path[:pos]
+	}
+
+	return path
+}
+
+func (d *DSN) GetParam(name string) string {
+	return d.ParsedDSN.GetParam(name)
+}
+
+func (d *DSN) GetPrefix() string {
+	if !d.ParsedDSN.HasParam("prefix") {
+		return ""
+	}
+	return d.ParsedDSN.GetParam("prefix")
+}
+
+func (d *DSN) HasParam(name string) bool {
+	return d.ParsedDSN.HasParam(name)
+}
+
+// ToString returns the connection string in the URI format of go-sqlite3. The segments of the path
+// are escaped, so "?", "#" and "%" in the file names are kept. The parameters of the DSN are passed
+// to the driver along with the ones dbfs needs.
+func (d *DSN) ToString() string {
+	segments := strings.Split(d.GetDatabase(), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	params := url.Values{}
+	for name, value := range defaultParams {
+		params.Set(name, value)
+	}
+	for name, value := range d.ParsedDSN.GetParams() {
+		if !ownParams[name] {
+			params.Set(name, value)
+		}
+	}
+	for name, value := range requiredParams {
+		params.Set(name, value)
+	}
+
+	return "file:" + strings.Join(segments, "/") + "?" + params.Encode()
+}
@@ -0,0 +1,55 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sqlite
+
+import (
+	"errors"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"github.com/mattn/go-sqlite3"
+)
+
+// ClassifyError tells the retry layer which SQLite errors are transient. A database locked by
+// another process longer than the busy timeout is retried, there are no connections to lose.
+func ClassifyError(err error) db.ErrorClass {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked) {
+		return db.ErrorRetryable
+	}
+
+	return db.ErrorPermanent
+}
+
+// TranslateError converts the constraint violations to the typed errors of the db package. SQLite
+// does not limit the length of the text columns, so the names are checked by the repository.
+func (Dialect) TranslateError(err error, parent db.Inode, name string) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrConstraint {
+		return err
+	}
+
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return &db.ExistsError{Parent: parent, Name: name}
+	case sqlite3.ErrConstraintForeignKey:
+		return &db.NotFoundError{Inode: parent}
+	case sqlite3.ErrConstraintTrigger:
+		// The only trigger aborts the deletion of a directory with entries.
+		return &db.NotEmptyError{Parent: parent, Name: name}
+	}
+
+	return &db.ConstraintError{Err: err}
+}
@@ -0,0 +1,64 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// VolumeLimits are the capacity and the number of inodes of the volume. Changes that exceed them
+// fail with ENOSPC. Zero values mean that the volume is not limited.
+type VolumeLimits struct {
+	Capacity  uint64
+	MaxInodes uint64
+}
+
+// VolumeUsage is the total size of the files and the number of inodes of the volume.
+type VolumeUsage struct {
+	Bytes  uint64
+	Inodes uint64
+}
+
+// parseSize reads a number of bytes with an optional K, M, G or T suffix.
+func parseSize(value string) (uint64, error) {
+	multiplier := uint64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1 << 30
+	case strings.HasSuffix(value, "T"):
+		multiplier = 1 << 40
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+
+	size, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if size > ^uint64(0)/multiplier {
+		return 0, fmt.Errorf("size %s overflows", value)
+	}
+
+	return size * multiplier, nil
+}
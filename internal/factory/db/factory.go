@@ -17,15 +17,28 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"github.com/kos-v/dbunderfs/internal/db"
+	"github.com/kos-v/dbunderfs/internal/db/memory"
+	memoryMigration "github.com/kos-v/dbunderfs/internal/db/memory/migration"
 	"github.com/kos-v/dbunderfs/internal/db/migration"
 	"github.com/kos-v/dbunderfs/internal/db/mysql"
 	mysqlMigration "github.com/kos-v/dbunderfs/internal/db/mysql/migration"
+	"github.com/kos-v/dbunderfs/internal/db/postgres"
+	postgresMigration "github.com/kos-v/dbunderfs/internal/db/postgres/migration"
+	"github.com/kos-v/dbunderfs/internal/db/sqldb"
+	"github.com/kos-v/dbunderfs/internal/db/sqlite"
+	sqliteMigration "github.com/kos-v/dbunderfs/internal/db/sqlite/migration"
 	mysqlMigrations "github.com/kos-v/dbunderfs/internal/migrations/mysql"
+	postgresMigrations "github.com/kos-v/dbunderfs/internal/migrations/postgres"
+	sqliteMigrations "github.com/kos-v/dbunderfs/internal/migrations/sqlite"
 	"github.com/kos-v/dsnparser"
+	"strconv"
 )
 
+const maxBlockSize = 16 * 1024 * 1024
+
 type DriverNotFoundError struct{ driver string }
 
 func (err *DriverNotFoundError) Error() string {
@@ -34,8 +47,26 @@ func (err *DriverNotFoundError) Error() string {
 
 func CreateInstance(dsn dsnparser.DSN) (db.Instance, error) {
 	switch dsn.GetScheme() {
+	case "memory":
+		inst := memory.Instance{DSN: memory.DSN{ParsedDSN: dsn}}
+		if _, err := inst.Connect(); err != nil {
+			return nil, err
+		}
+		return &inst, nil
 	case "mysql":
-		inst := mysql.Instance{DSN: mysql.DSN{ParsedDSN: dsn}}
+		inst := sqldb.Instance{DSN: &mysql.DSN{ParsedDSN: dsn}, Dialect: mysql.Dialect{}}
+		if _, err := inst.Connect(); err != nil {
+			return nil, err
+		}
+		return &inst, nil
+	case "postgres", "postgresql":
+		inst := sqldb.Instance{DSN: &postgres.DSN{ParsedDSN: dsn}, Dialect: postgres.Dialect{}}
+		if _, err := inst.Connect(); err != nil {
+			return nil, err
+		}
+		return &inst, nil
+	case "sqlite":
+		inst := sqldb.Instance{DSN: &sqlite.DSN{ParsedDSN: dsn}, Dialect: sqlite.Dialect{}}
 		if _, err := inst.Connect(); err != nil {
 			return nil, err
 		}
@@ -47,8 +78,14 @@ func CreateInstance(dsn dsnparser.DSN) (db.Instance, error) {
 
 func CreateMigrationCommiter(instance db.Instance) (migration.Commiter, error) {
 	switch instance.GetDriverName() {
+	case "memory":
+		return &memoryMigration.Commiter{}, nil
 	case "mysql":
 		return &mysqlMigration.Commiter{Instance: instance}, nil
+	case "postgres":
+		return &postgresMigration.Commiter{Instance: instance}, nil
+	case "sqlite":
+		return &sqliteMigration.Commiter{Instance: instance}, nil
 	}
 
 	return nil, &DriverNotFoundError{driver: instance.GetDriverName()}
@@ -56,18 +93,84 @@ func CreateMigrationCommiter(instance db.Instance) (migration.Commiter, error) {
 
 func CreateMigrations(instance db.Instance) ([]*migration.Migration, error) {
 	switch instance.GetDriverName() {
+	case "memory":
+		return []*migration.Migration{}, nil
 	case "mysql":
 		return mysqlMigrations.Migrations(), nil
+	case "postgres":
+		return postgresMigrations.Migrations(), nil
+	case "sqlite":
+		return sqliteMigrations.Migrations(), nil
 	}
 
 	return nil, &DriverNotFoundError{driver: instance.GetDriverName()}
 }
 
-func CreateRepositoryRegistry(instance db.Instance) (db.RepositoryRegistry, error) {
+// CreateRetryInstance wraps the instance with the retry layer using the error classification of its driver.
+// Memory instances have no transient errors and are returned as is.
+func CreateRetryInstance(instance db.Instance, policy db.RetryPolicy) (db.Instance, error) {
+	var classify db.ErrorClassifier
 	switch instance.GetDriverName() {
+	case "memory":
+		return instance, nil
 	case "mysql":
-		return &mysql.RepositoryRegistry{Instance: instance}, nil
+		classify = mysql.ClassifyError
+	case "postgres":
+		classify = postgres.ClassifyError
+	case "sqlite":
+		classify = sqlite.ClassifyError
+	default:
+		return nil, &DriverNotFoundError{driver: instance.GetDriverName()}
+	}
+
+	return &db.RetryInstance{Instance: instance, Classify: classify, Policy: policy}, nil
+}
+
+// CreateRepositoryRegistry creates the registry of the volume. The settings must be the ones
+// stored with the volume, see LoadVolumeSettings.
+func CreateRepositoryRegistry(instance db.Instance, settings db.VolumeSettings) (db.RepositoryRegistry, error) {
+	blockSize, err := parseBlockSize(instance.GetDSN())
+	if err != nil {
+		return nil, err
+	}
+
+	switch instance.GetDriverName() {
+	case "memory":
+		return &memory.RepositoryRegistry{
+			BlockSize:       blockSize,
+			Storage:         instance.(*memory.Instance).GetStorage(),
+			CaseInsensitive: settings.CaseInsensitive,
+		}, nil
+	case "mysql":
+		return &sqldb.RepositoryRegistry{BlockSize: blockSize, Instance: instance, Dialect: mysql.Dialect{}, CaseInsensitive: settings.CaseInsensitive}, nil
+	case "postgres":
+		return &sqldb.RepositoryRegistry{BlockSize: blockSize, Instance: instance, Dialect: postgres.Dialect{}, CaseInsensitive: settings.CaseInsensitive}, nil
+	case "sqlite":
+		return &sqldb.RepositoryRegistry{BlockSize: blockSize, Instance: instance, Dialect: sqlite.Dialect{}, CaseInsensitive: settings.CaseInsensitive}, nil
 	}
 
 	return nil, &DriverNotFoundError{driver: instance.GetDriverName()}
 }
+
+// LoadVolumeSettings reads the settings stored with the volume.
+func LoadVolumeSettings(ctx context.Context, instance db.Instance) (db.VolumeSettings, error) {
+	registry, err := CreateRepositoryRegistry(instance, db.VolumeSettings{})
+	if err != nil {
+		return db.VolumeSettings{}, err
+	}
+
+	return db.LoadVolumeSettings(ctx, registry.GetSettingRepository())
+}
+
+func parseBlockSize(dsn db.DSN) (uint32, error) {
+	if !dsn.HasParam("block_size") {
+		return db.DefaultBlockSize, nil
+	}
+
+	blockSize, err := strconv.ParseUint(dsn.GetParam("block_size"), 10, 32)
+	if err != nil || blockSize == 0 || blockSize > maxBlockSize {
+		return 0, fmt.Errorf("invalid block_size %q. Should be a number from 1 to %d", dsn.GetParam("block_size"), maxBlockSize)
+	}
+
+	return uint32(blockSize), nil
+}
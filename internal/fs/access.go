@@ -0,0 +1,367 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"bazil.org/fuse"
+	"bufio"
+	"fmt"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"golang.org/x/net/context"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Access masks of access(2).
+const (
+	accessExec  uint32 = 1
+	accessWrite uint32 = 2
+	accessRead  uint32 = 4
+)
+
+var (
+	errAccess   = fuse.Errno(syscall.EACCES)
+	errReadOnly = fuse.Errno(syscall.EROFS)
+)
+
+// caller is the process that made the request.
+type caller struct {
+	uid uint32
+	gid uint32
+	pid uint32
+	// groups are the supplementary groups of the process. They are not passed by the kernel
+	// and are read from procfs on the first check that needs them.
+	groups       []uint32
+	groupsLoaded bool
+}
+
+func newCaller(header fuse.Header) *caller {
+	return &caller{uid: header.Uid, gid: header.Gid, pid: header.Pid}
+}
+
+func (c *caller) isRoot() bool {
+	return c.uid == 0
+}
+
+func (c *caller) inGroup(gid uint32) bool {
+	if c.gid == gid {
+		return true
+	}
+
+	if !c.groupsLoaded {
+		c.groups = procGroups(c.pid)
+		c.groupsLoaded = true
+	}
+	for _, group := range c.groups {
+		if group == gid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// procGroups reads the supplementary groups of the process. The groups of a process that
+// has already exited or lives in another pid namespace are unknown, so none are returned.
+func procGroups(pid uint32) []uint32 {
+	if pid == 0 {
+		return nil
+	}
+
+	status, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil
+	}
+	defer status.Close()
+
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Groups:") {
+			continue
+		}
+
+		var groups []uint32
+		for _, field := range strings.Fields(strings.TrimPrefix(line, "Groups:")) {
+			gid, err := strconv.ParseUint(field, 10, 32)
+			if err == nil {
+				groups = append(groups, uint32(gid))
+			}
+		}
+		return groups
+	}
+
+	return nil
+}
+
+// admit rejects the requests of the users other than root and the owner of the mount
+// if the mount allows access only to them.
+func (f *FS) admit(header fuse.Header) error {
+	if !f.Options.AllowRoot || header.Uid == 0 || header.Uid == f.owner {
+		return nil
+	}
+
+	return errAccess
+}
+
+// checkWritable rejects the changes of a read-only mount.
+func (f *FS) checkWritable() error {
+	if f.Options.ReadOnly {
+		return errReadOnly
+	}
+
+	return nil
+}
+
+// checkChange admits the request that changes the volume and rejects it on a read-only mount.
+func (f *FS) checkChange(header fuse.Header) error {
+	if err := f.admit(header); err != nil {
+		return err
+	}
+
+	return f.checkWritable()
+}
+
+// checkAccessRequest admits the access(2) request and reports EROFS for the write access to a read-only mount.
+func (f *FS) checkAccessRequest(req *fuse.AccessRequest) error {
+	if req.Mask&accessWrite != 0 {
+		return f.checkChange(req.Header)
+	}
+
+	return f.admit(req.Header)
+}
+
+// checksPermissions reports whether the filesystem checks the permissions itself.
+// With default_permissions the kernel checks them before sending the requests.
+func (f *FS) checksPermissions() bool {
+	return !f.Options.DefaultPermissions
+}
+
+// checkAccess checks the mask of access(2) against the access ACL or the mode bits of the descriptor.
+// Root may read and write anything and execute directories and files with any execute bit set.
+func (f *FS) checkAccess(ctx context.Context, c *caller, descr db.DescriptorInterface, mask uint32) error {
+	mode := uint32(f.permission(descr).Perm())
+
+	if c.isRoot() {
+		if mask&accessExec == 0 || descr.GetType() == db.DT_Dir || mode&0111 != 0 {
+			return nil
+		}
+		return errAccess
+	}
+
+	if c.uid != f.uid(descr) {
+		entries, err := f.findACL(ctx, descr.GetInode(), aclAccessXattr)
+		if err != nil {
+			return err
+		}
+		if entries != nil {
+			if !entries.withMode(f.permission(descr)).permits(c, f.gid(descr), mask) {
+				return errAccess
+			}
+			return nil
+		}
+	}
+
+	var granted uint32
+	switch {
+	case c.uid == f.uid(descr):
+		granted = mode >> 6
+	case c.inGroup(f.gid(descr)):
+		granted = mode >> 3
+	default:
+		granted = mode
+	}
+	if granted&mask != mask {
+		return errAccess
+	}
+
+	return nil
+}
+
+// access finds the descriptor of the inode and checks the access of the caller to it.
+func (f *FS) access(ctx context.Context, c *caller, inode db.Inode, mask uint32) error {
+	if !f.checksPermissions() {
+		return nil
+	}
+
+	descr, err := f.findDescriptor(ctx, inode)
+	if err != nil {
+		return err
+	}
+
+	return f.checkAccess(ctx, c, descr, mask)
+}
+
+// checkOwner allows changing the metadata of the descriptor only to its owner and root.
+func (f *FS) checkOwner(c *caller, descr db.DescriptorInterface) error {
+	if c.isRoot() || c.uid == f.uid(descr) {
+		return nil
+	}
+
+	return fuse.EPERM
+}
+
+// checkUnlink checks that the caller may remove the entry from the directory: the directory
+// should be writable and searchable, and entries of a sticky directory may be removed only by
+// the owner of the entry or of the directory. A missing entry passes the check.
+func (f *FS) checkUnlink(ctx context.Context, c *caller, dir db.Inode, name string) error {
+	if !f.checksPermissions() {
+		return nil
+	}
+
+	dirDescr, err := f.findDescriptor(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if err := f.checkAccess(ctx, c, dirDescr, accessWrite|accessExec); err != nil {
+		return err
+	}
+	if f.permission(dirDescr)&os.ModeSticky == 0 || c.isRoot() || c.uid == f.uid(dirDescr) {
+		return nil
+	}
+
+	entry, err := f.RepositoryRegistry.GetDescriptorRepository().FindSingleByName(ctx, dir, name)
+	if err != nil {
+		return err
+	}
+	if entry != nil && c.uid != f.uid(entry) {
+		return fuse.EPERM
+	}
+
+	return nil
+}
+
+// checkRename checks the removal of the entry from the old directory and the replacement of
+// the new name. A directory moved to another parent should be writable itself, as its ".." changes.
+func (f *FS) checkRename(ctx context.Context, c *caller, oldDir db.Inode, oldName string, newDir db.Inode, newName string) error {
+	if !f.checksPermissions() {
+		return nil
+	}
+
+	if err := f.checkUnlink(ctx, c, oldDir, oldName); err != nil {
+		return err
+	}
+	if err := f.checkUnlink(ctx, c, newDir, newName); err != nil {
+		return err
+	}
+	if oldDir == newDir {
+		return nil
+	}
+
+	entry, err := f.RepositoryRegistry.GetDescriptorRepository().FindSingleByName(ctx, oldDir, oldName)
+	if err != nil || entry == nil || entry.GetType() != db.DT_Dir {
+		return err
+	}
+
+	return f.checkAccess(ctx, c, entry, accessWrite)
+}
+
+// checkOpen checks the access to the file required by the access mode of the open flags.
+func (f *FS) checkOpen(ctx context.Context, c *caller, descr db.DescriptorInterface, flags fuse.OpenFlags) error {
+	if !f.checksPermissions() {
+		return nil
+	}
+
+	var mask uint32
+	if !flags.IsWriteOnly() {
+		mask |= accessRead
+	}
+	if !flags.IsReadOnly() {
+		mask |= accessWrite
+	}
+
+	return f.checkAccess(ctx, c, descr, mask)
+}
+
+// checkSetattr applies the rules of chmod(2), chown(2), utimes(2) and truncate(2).
+func (f *FS) checkSetattr(ctx context.Context, c *caller, descr db.DescriptorInterface, req *fuse.SetattrRequest) error {
+	if !f.checksPermissions() || c.isRoot() {
+		return nil
+	}
+
+	isOwner := c.uid == f.uid(descr)
+	if req.Valid.Mode() && !isOwner {
+		return fuse.EPERM
+	}
+	if req.Valid.Uid() && req.Uid != f.uid(descr) {
+		return fuse.EPERM
+	}
+	if req.Valid.Gid() && req.Gid != f.gid(descr) && (!isOwner || !c.inGroup(req.Gid)) {
+		return fuse.EPERM
+	}
+
+	// Setting the times to the current time is allowed to anyone who may write the file.
+	setsExplicitTime := (req.Valid.Atime() && !req.Valid.AtimeNow()) || (req.Valid.Mtime() && !req.Valid.MtimeNow())
+	if setsExplicitTime && !isOwner {
+		return fuse.EPERM
+	}
+	if (req.Valid.AtimeNow() || req.Valid.MtimeNow()) && !isOwner {
+		if err := f.checkAccess(ctx, c, descr, accessWrite); err != nil {
+			return err
+		}
+	}
+
+	// Truncating an open file is checked by the open.
+	if req.Valid.Size() && !req.Valid.Handle() {
+		return f.checkAccess(ctx, c, descr, accessWrite)
+	}
+
+	return nil
+}
+
+// visibleXattrs hides the trusted attributes from the callers other than root, as Linux does.
+// The kernel does not filter the names listed by a FUSE filesystem, even with default_permissions.
+func visibleXattrs(c *caller, names []string) []string {
+	if c.isRoot() {
+		return names
+	}
+
+	visible := make([]string, 0, len(names))
+	for _, name := range names {
+		if !strings.HasPrefix(name, "trusted.") {
+			visible = append(visible, name)
+		}
+	}
+
+	return visible
+}
+
+// checkXattr checks the access to an extended attribute by its namespace: user attributes follow
+// the mode bits, trusted attributes are available only to root and the attributes of the other
+// namespaces may be changed only by the owner.
+func (f *FS) checkXattr(ctx context.Context, c *caller, inode db.Inode, name string, mask uint32) error {
+	if !f.checksPermissions() || c.isRoot() {
+		return nil
+	}
+
+	switch {
+	case isUserXattr(name):
+		return f.access(ctx, c, inode, mask)
+	case strings.HasPrefix(name, "trusted."):
+		return fuse.EPERM
+	case mask&accessWrite != 0:
+		descr, err := f.findDescriptor(ctx, inode)
+		if err != nil {
+			return err
+		}
+		return f.checkOwner(c, descr)
+	}
+
+	return nil
+}
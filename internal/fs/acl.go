@@ -0,0 +1,401 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"bazil.org/fuse"
+	"encoding/binary"
+	"errors"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"golang.org/x/net/context"
+	"os"
+	"sort"
+	"syscall"
+)
+
+// Names of the extended attributes of POSIX ACLs, as used by getfacl and setfacl.
+const (
+	aclAccessXattr  = "system.posix_acl_access"
+	aclDefaultXattr = "system.posix_acl_default"
+)
+
+// Tags of the ACL entries in the xattr format of the kernel.
+const (
+	aclUserObj  uint16 = 0x01
+	aclUser     uint16 = 0x02
+	aclGroupObj uint16 = 0x04
+	aclGroup    uint16 = 0x08
+	aclMask     uint16 = 0x10
+	aclOther    uint16 = 0x20
+)
+
+const (
+	aclVersion     = 2
+	aclHeaderSize  = 4
+	aclEntrySize   = 8
+	aclUndefinedID = ^uint32(0)
+)
+
+var errInvalidACL = fuse.Errno(syscall.EINVAL)
+
+type aclEntry struct {
+	tag  uint16
+	perm uint16
+	id   uint32
+}
+
+// acl is a POSIX ACL sorted by tag and id. The owner, mask (or owning group) and other entries
+// of an access ACL duplicate the mode bits, and the mode bits are the ones that are kept up to date.
+type acl []aclEntry
+
+// parseACL decodes and validates the ACL in the xattr format of the kernel.
+func parseACL(data []byte) (acl, error) {
+	if len(data) < aclHeaderSize || (len(data)-aclHeaderSize)%aclEntrySize != 0 {
+		return nil, errInvalidACL
+	}
+	if binary.LittleEndian.Uint32(data) != aclVersion {
+		return nil, fuse.Errno(syscall.EOPNOTSUPP)
+	}
+
+	var entries acl
+	for offset := aclHeaderSize; offset < len(data); offset += aclEntrySize {
+		entry := aclEntry{
+			tag:  binary.LittleEndian.Uint16(data[offset:]),
+			perm: binary.LittleEndian.Uint16(data[offset+2:]),
+			id:   binary.LittleEndian.Uint32(data[offset+4:]),
+		}
+		if entry.tag != aclUser && entry.tag != aclGroup {
+			entry.id = aclUndefinedID
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].tag != entries[j].tag {
+			return entries[i].tag < entries[j].tag
+		}
+		return entries[i].id < entries[j].id
+	})
+
+	if err := entries.validate(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// validate applies the rules of acl_valid(3): one owner, owning group and other entry, unique
+// named entries and a mask entry if there are named entries.
+func (a acl) validate() error {
+	counts := map[uint16]int{}
+	for i, entry := range a {
+		if entry.perm&^7 != 0 {
+			return errInvalidACL
+		}
+		switch entry.tag {
+		case aclUserObj, aclGroupObj, aclMask, aclOther:
+		case aclUser, aclGroup:
+			if i > 0 && a[i-1].tag == entry.tag && a[i-1].id == entry.id {
+				return errInvalidACL
+			}
+		default:
+			return errInvalidACL
+		}
+		counts[entry.tag]++
+	}
+
+	if counts[aclUserObj] != 1 || counts[aclGroupObj] != 1 || counts[aclOther] != 1 || counts[aclMask] > 1 {
+		return errInvalidACL
+	}
+	if counts[aclUser]+counts[aclGroup] > 0 && counts[aclMask] == 0 {
+		return errInvalidACL
+	}
+
+	return nil
+}
+
+func (a acl) bytes() []byte {
+	data := make([]byte, aclHeaderSize+len(a)*aclEntrySize)
+	binary.LittleEndian.PutUint32(data, aclVersion)
+	for i, entry := range a {
+		offset := aclHeaderSize + i*aclEntrySize
+		binary.LittleEndian.PutUint16(data[offset:], entry.tag)
+		binary.LittleEndian.PutUint16(data[offset+2:], entry.perm)
+		binary.LittleEndian.PutUint32(data[offset+4:], entry.id)
+	}
+
+	return data
+}
+
+// isMinimal reports whether the ACL has no entries beyond the mode bits.
+func (a acl) isMinimal() bool {
+	return len(a) == 3
+}
+
+// groupClassTag returns the tag of the entry that corresponds to the group bits of the mode.
+func (a acl) groupClassTag() uint16 {
+	for _, entry := range a {
+		if entry.tag == aclMask {
+			return aclMask
+		}
+	}
+
+	return aclGroupObj
+}
+
+// withMode returns a copy of the ACL with the owner, group class and other entries taken from the mode.
+func (a acl) withMode(mode os.FileMode) acl {
+	groupClass := a.groupClassTag()
+	result := make(acl, len(a))
+	for i, entry := range a {
+		switch entry.tag {
+		case aclUserObj:
+			entry.perm = uint16(mode>>6) & 7
+		case groupClass:
+			entry.perm = uint16(mode>>3) & 7
+		case aclOther:
+			entry.perm = uint16(mode) & 7
+		}
+		result[i] = entry
+	}
+
+	return result
+}
+
+// mode returns the permission bits that correspond to the ACL, keeping the special bits of the base mode.
+func (a acl) mode(base os.FileMode) os.FileMode {
+	groupClass := a.groupClassTag()
+	mode := base &^ os.ModePerm
+	for _, entry := range a {
+		switch entry.tag {
+		case aclUserObj:
+			mode |= os.FileMode(entry.perm) << 6
+		case groupClass:
+			mode |= os.FileMode(entry.perm) << 3
+		case aclOther:
+			mode |= os.FileMode(entry.perm)
+		}
+	}
+
+	return mode
+}
+
+// permits evaluates the ACL for a caller that is not the owner of the file, as the kernel does:
+// a named user entry, then the owning group and the named group entries, then the other entry.
+// Named entries and the owning group are limited by the mask.
+func (a acl) permits(c *caller, gid uint32, mask uint32) bool {
+	var maskPerm uint16 = 7
+	for _, entry := range a {
+		if entry.tag == aclMask {
+			maskPerm = entry.perm
+		}
+	}
+	grants := func(perm uint16) bool {
+		return uint32(perm&maskPerm)&mask == mask
+	}
+
+	for _, entry := range a {
+		if entry.tag == aclUser && entry.id == c.uid {
+			return grants(entry.perm)
+		}
+	}
+
+	groupMatched := false
+	for _, entry := range a {
+		isMember := (entry.tag == aclGroupObj && c.inGroup(gid)) || (entry.tag == aclGroup && c.inGroup(entry.id))
+		if !isMember {
+			continue
+		}
+		if grants(entry.perm) {
+			return true
+		}
+		groupMatched = true
+	}
+	if groupMatched {
+		return false
+	}
+
+	for _, entry := range a {
+		if entry.tag == aclOther {
+			return uint32(entry.perm)&mask == mask
+		}
+	}
+
+	return false
+}
+
+// findACL returns the stored ACL of the inode or nil if there is none.
+func (f *FS) findACL(ctx context.Context, inode db.Inode, name string) (acl, error) {
+	data, err := f.RepositoryRegistry.GetXattrRepository().Find(ctx, inode, name)
+	var notFoundErr *db.XattrNotFoundError
+	if errors.As(err, &notFoundErr) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return parseACL(data)
+}
+
+// getACL returns the ACL in the xattr format. The entries of an access ACL that duplicate
+// the mode bits are taken from the current mode, so chmod needs no update of the ACL.
+func (f *FS) getACL(ctx context.Context, inode db.Inode, name string) ([]byte, error) {
+	entries, err := f.findACL(ctx, inode, name)
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		return nil, fuse.ErrNoXattr
+	}
+	if name == aclDefaultXattr {
+		return entries.bytes(), nil
+	}
+
+	descr, err := f.findDescriptor(ctx, inode)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries.withMode(f.permission(descr)).bytes(), nil
+}
+
+// setACL stores the ACL set by setfacl. Setting an access ACL changes the mode bits, and an access
+// ACL that has no entries beyond the mode bits is stored only as the mode.
+// Default ACLs may be set only on directories. The flags apply to the stored ACL, so an ACL kept
+// only as the mode bits does not exist for them, as getxattr does not return it.
+func (f *FS) setACL(ctx context.Context, inode db.Inode, name string, data []byte, flags db.XattrSetFlags) error {
+	descr, err := f.findDescriptor(ctx, inode)
+	if err != nil {
+		return err
+	}
+	if name == aclDefaultXattr && descr.GetType() != db.DT_Dir {
+		return errAccess
+	}
+
+	existing, err := f.findACL(ctx, inode, name)
+	if err != nil {
+		return err
+	}
+	if flags&db.XattrCreate != 0 && existing != nil {
+		return &db.XattrExistsError{Inode: inode, Name: name}
+	}
+	if flags&db.XattrReplace != 0 && existing == nil {
+		return &db.XattrNotFoundError{Inode: inode, Name: name}
+	}
+
+	repo := f.RepositoryRegistry.GetXattrRepository()
+	if len(data) == 0 {
+		return f.removeACL(ctx, inode, name)
+	}
+	entries, err := parseACL(data)
+	if err != nil {
+		return err
+	}
+	if name == aclDefaultXattr {
+		return repo.Set(ctx, inode, name, entries.bytes(), 0)
+	}
+
+	if err := f.updateMode(ctx, descr, entries.mode(descr.GetPermission())); err != nil {
+		return err
+	}
+	if entries.isMinimal() {
+		return f.removeACL(ctx, inode, name)
+	}
+
+	return repo.Set(ctx, inode, name, entries.bytes(), 0)
+}
+
+// removeACL removes the stored ACL, if any.
+func (f *FS) removeACL(ctx context.Context, inode db.Inode, name string) error {
+	err := f.RepositoryRegistry.GetXattrRepository().Remove(ctx, inode, name)
+	var notFoundErr *db.XattrNotFoundError
+	if errors.As(err, &notFoundErr) {
+		return nil
+	}
+
+	return err
+}
+
+// inheritedACL holds the ACLs a new entry gets from the default ACL of its directory.
+type inheritedACL struct {
+	access   acl
+	defaults acl
+}
+
+// inheritACL applies the default ACL of the parent to the mode of a new entry, as the kernel does
+// for filesystems with ACLs: the entries of the default ACL are limited by the requested mode,
+// and the mode is taken from the result. New directories inherit the default ACL itself as well.
+//
+// The umask does not apply to entries created in a directory with a default ACL, but the kernel has
+// already cleared its bits from the mode of the request. The bits are given back within 0666 for files
+// and 0777 for directories, the modes applications normally request leaving the rest to the umask.
+func (f *FS) inheritACL(ctx context.Context, parent db.Inode, mode os.FileMode, umask os.FileMode, isDir bool) (os.FileMode, *inheritedACL, error) {
+	defaults, err := f.findACL(ctx, parent, aclDefaultXattr)
+	if err != nil || defaults == nil {
+		return mode, nil, err
+	}
+	requested := os.FileMode(0666)
+	if isDir {
+		requested = 0777
+	}
+	mode |= umask & requested
+
+	groupClass := defaults.groupClassTag()
+	access := make(acl, len(defaults))
+	for i, entry := range defaults {
+		switch entry.tag {
+		case aclUserObj:
+			entry.perm &= uint16(mode>>6) & 7
+		case groupClass:
+			entry.perm &= uint16(mode>>3) & 7
+		case aclOther:
+			entry.perm &= uint16(mode) & 7
+		}
+		access[i] = entry
+	}
+
+	inherited := &inheritedACL{}
+	if !access.isMinimal() {
+		inherited.access = access
+	}
+	if isDir {
+		inherited.defaults = defaults
+	}
+
+	return access.mode(mode), inherited, nil
+}
+
+// storeInheritedACL stores the ACLs inherited by the new entry.
+func (f *FS) storeInheritedACL(ctx context.Context, inode db.Inode, inherited *inheritedACL) error {
+	if inherited == nil {
+		return nil
+	}
+
+	repo := f.RepositoryRegistry.GetXattrRepository()
+	if inherited.access != nil {
+		if err := repo.Set(ctx, inode, aclAccessXattr, inherited.access.bytes(), 0); err != nil {
+			return err
+		}
+	}
+	if inherited.defaults != nil {
+		if err := repo.Set(ctx, inode, aclDefaultXattr, inherited.defaults.bytes(), 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
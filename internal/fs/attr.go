@@ -0,0 +1,176 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"bazil.org/fuse"
+	"github.com/kos-v/dbunderfs/internal/db"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"os"
+	"time"
+)
+
+// fillAttr copies the attributes of the descriptor. The kernel may cache them for the TTL
+// of the descriptor cache.
+func (f *FS) fillAttr(descr db.DescriptorInterface, attr *fuse.Attr) {
+	attr.Valid = f.Options.CacheTTL
+	attr.Inode = uint64(descr.GetInode())
+	attr.Mode = f.permission(descr)
+	switch descr.GetType() {
+	case db.DT_Dir:
+		attr.Mode |= os.ModeDir
+	case db.DT_Symlink:
+		attr.Mode |= os.ModeSymlink
+	}
+	attr.Nlink = descr.GetNlink()
+	attr.Uid = f.uid(descr)
+	attr.Gid = f.gid(descr)
+	attr.Size = descr.GetSize()
+	attr.Atime = descr.GetAtime()
+	attr.Mtime = descr.GetMtime()
+	attr.Ctime = descr.GetCtime()
+	attr.Crtime = descr.GetCrtime()
+}
+
+// permission returns the permission bits of the descriptor with the umask of the mount applied.
+func (f *FS) permission(descr db.DescriptorInterface) os.FileMode {
+	return descr.GetPermission() &^ f.Options.Umask
+}
+
+// uid returns the owner of the descriptor or the owner forced by the mount.
+func (f *FS) uid(descr db.DescriptorInterface) uint32 {
+	if f.Options.UID != nil {
+		return *f.Options.UID
+	}
+
+	return descr.GetUID()
+}
+
+// gid returns the group of the descriptor or the group forced by the mount.
+func (f *FS) gid(descr db.DescriptorInterface) uint32 {
+	if f.Options.GID != nil {
+		return *f.Options.GID
+	}
+
+	return descr.GetGID()
+}
+
+func direntType(descr db.DescriptorInterface) fuse.DirentType {
+	switch descr.GetType() {
+	case db.DT_Dir:
+		return fuse.DT_Dir
+	case db.DT_Symlink:
+		return fuse.DT_Link
+	}
+
+	return fuse.DT_File
+}
+
+func (f *FS) findDescriptor(ctx context.Context, inode db.Inode) (db.DescriptorInterface, error) {
+	descr, err := f.RepositoryRegistry.GetDescriptorRepository().FindSingleByInode(ctx, inode)
+	if err != nil {
+		return nil, err
+	}
+	if descr == nil {
+		return nil, fuse.ENOENT
+	}
+
+	return descr, nil
+}
+
+// setAttr applies the fields of the request marked as valid to the descriptor and returns
+// the updated descriptor.
+func (f *FS) setAttr(ctx context.Context, inode db.Inode, req *fuse.SetattrRequest) (db.DescriptorInterface, error) {
+	descr, err := f.findDescriptor(ctx, inode)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkSetattr(ctx, newCaller(req.Header), descr, req); err != nil {
+		return nil, err
+	}
+	if req.Valid.Size() && req.Size > descr.GetSize() {
+		if err := f.reserve(ctx, req.Size-descr.GetSize(), 0); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Valid.Mode() || req.Valid.Uid() || req.Valid.Gid() || req.Valid.Atime() || req.Valid.Mtime() {
+		attrs := db.DescriptorAttrs{
+			Permission: Permission(descr.GetPermission()).ToOctalString(),
+			UID:        descr.GetUID(),
+			GID:        descr.GetGID(),
+			Atime:      descr.GetAtime(),
+			Mtime:      descr.GetMtime(),
+		}
+		if req.Valid.Mode() {
+			attrs.Permission = Permission(req.Mode).ToOctalString()
+		}
+		if req.Valid.Uid() {
+			attrs.UID = req.Uid
+		}
+		if req.Valid.Gid() {
+			attrs.GID = req.Gid
+		}
+		if req.Valid.AtimeNow() {
+			attrs.Atime = time.Now()
+		} else if req.Valid.Atime() {
+			attrs.Atime = req.Atime
+		}
+		if req.Valid.MtimeNow() {
+			attrs.Mtime = time.Now()
+		} else if req.Valid.Mtime() {
+			attrs.Mtime = req.Mtime
+		}
+
+		if err := f.RepositoryRegistry.GetDescriptorRepository().UpdateAttrs(ctx, inode, attrs); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Valid.Size() {
+		if err := f.RepositoryRegistry.GetDataBlockRepository().Truncate(ctx, descr, req.Size); err != nil {
+			return nil, err
+		}
+	}
+
+	return f.findDescriptor(ctx, inode)
+}
+
+// updateMode changes the permission bits of the descriptor keeping its other attributes.
+func (f *FS) updateMode(ctx context.Context, descr db.DescriptorInterface, mode os.FileMode) error {
+	return f.RepositoryRegistry.GetDescriptorRepository().UpdateAttrs(ctx, descr.GetInode(), db.DescriptorAttrs{
+		Permission: Permission(mode).ToOctalString(),
+		UID:        descr.GetUID(),
+		GID:        descr.GetGID(),
+		Atime:      descr.GetAtime(),
+		Mtime:      descr.GetMtime(),
+	})
+}
+
+// touchAtime updates the access time of the descriptor according to the atime mode of the mount.
+// Failures are only logged, as they must not break the read operation itself.
+func (f *FS) touchAtime(ctx context.Context, inode db.Inode) {
+	if f.Options.Atime == AtimeNone || f.Options.ReadOnly {
+		return
+	}
+
+	err := f.RepositoryRegistry.GetDescriptorRepository().UpdateAtime(ctx, inode, f.Options.Atime != AtimeStrict)
+	if err != nil {
+		log.Warnf("Error updating atime of %d: %s", inode, err.Error())
+	}
+}
@@ -19,11 +19,13 @@ package fs
 import (
 	"bazil.org/fuse"
 	fuseFS "bazil.org/fuse/fs"
-	"fmt"
 	"github.com/kos-v/dbunderfs/internal/db"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	"os"
+	"strings"
+	"syscall"
+	"unicode/utf8"
 )
 
 type Dir struct {
@@ -34,56 +36,126 @@ type Dir struct {
 var _ fuseFS.Node = (*Dir)(nil)
 
 func (d *Dir) Attr(ctx context.Context, attr *fuse.Attr) error {
+	ctx, cancel := d.fs.opContext(ctx)
+	defer cancel()
+
 	log.Infof("Reads dir attrs of %d:%s", d.descriptor.GetInode(), db.RootName)
 
-	attr.Inode = uint64(d.descriptor.GetInode())
-	attr.Mode = os.ModeDir | d.descriptor.GetPermission()
-	attr.Uid = d.descriptor.GetUID()
-	attr.Gid = d.descriptor.GetGID()
-	attr.Size = d.descriptor.GetSize()
+	descr, err := d.fs.findDescriptor(ctx, d.descriptor.GetInode())
+	if err != nil {
+		log.Warnf("Error: %s", err.Error())
+		return toFuseError(ctx, err)
+	}
+	d.fs.fillAttr(descr, attr)
 
 	return nil
 }
 
+var _ = fuseFS.NodeSetattrer(&Dir{})
+
+func (d *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	ctx, cancel := d.fs.opContext(ctx)
+	defer cancel()
+
+	log.Infof("Setattr for dir %d:%s. Valid: %s", d.descriptor.GetInode(), d.descriptor.GetName(), req.Valid)
+
+	if err := d.fs.checkChange(req.Header); err != nil {
+		return err
+	}
+
+	if req.Valid.Size() {
+		return fuse.Errno(syscall.EISDIR)
+	}
+
+	descr, err := d.fs.setAttr(ctx, d.descriptor.GetInode(), req)
+	if err != nil {
+		log.Warnf("Error: %s", err.Error())
+		return toFuseError(ctx, err)
+	}
+	d.fs.fillAttr(descr, &resp.Attr)
+
+	return nil
+}
+
+var _ = fuseFS.NodeAccesser(&Dir{})
+
+func (d *Dir) Access(ctx context.Context, req *fuse.AccessRequest) error {
+	ctx, cancel := d.fs.opContext(ctx)
+	defer cancel()
+
+	if err := d.fs.checkAccessRequest(req); err != nil {
+		return err
+	}
+	if err := d.fs.access(ctx, newCaller(req.Header), d.descriptor.GetInode(), req.Mask); err != nil {
+		return toFuseError(ctx, err)
+	}
+
+	return nil
+}
+
+var _ = fuseFS.NodeOpener(&Dir{})
+
+// Open checks that the directory may be listed. The directory itself is used as the handle.
+func (d *Dir) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fuseFS.Handle, error) {
+	ctx, cancel := d.fs.opContext(ctx)
+	defer cancel()
+
+	if err := d.fs.admit(req.Header); err != nil {
+		return nil, err
+	}
+	if err := d.fs.access(ctx, newCaller(req.Header), d.descriptor.GetInode(), accessRead); err != nil {
+		return nil, toFuseError(ctx, err)
+	}
+
+	return d, nil
+}
+
 var _ = fuseFS.NodeRequestLookuper(&Dir{})
 
 func (d *Dir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fuseFS.Node, error) {
+	ctx, cancel := d.fs.opContext(ctx)
+	defer cancel()
+
 	requestName := req.Name
 	log.Infof("Lookup in \"%d:%s\". Request: %s", d.descriptor.GetInode(), d.descriptor.GetName(), requestName)
 
+	if err := d.fs.admit(req.Header); err != nil {
+		return nil, err
+	}
+	if err := validateName(requestName); err != nil {
+		return nil, err
+	}
+	if err := d.fs.access(ctx, newCaller(req.Header), d.descriptor.GetInode(), accessExec); err != nil {
+		return nil, toFuseError(ctx, err)
+	}
+
 	repo := d.fs.RepositoryRegistry.GetDescriptorRepository()
-	descr, err := repo.FindSingleByName(d.descriptor.GetInode(), requestName)
+	descr, err := repo.FindSingleByName(ctx, d.descriptor.GetInode(), requestName)
 	if err != nil {
 		log.Warnf("Error: %s", err.Error())
-		return nil, err
+		return nil, toFuseError(ctx, err)
 	}
 
 	if descr == nil {
 		log.Warnf("Request path not exists")
 		return nil, fuse.ENOENT
 	}
+	resp.EntryValid = d.fs.Options.CacheTTL
 
-	if descr.GetType() == db.DT_Dir {
-		return &Dir{
-			descriptor: descr,
-			fs:         d.fs,
-		}, nil
-	}
-
-	return &File{
-		descriptor: descr,
-		fs:         d.fs,
-	}, nil
+	return d.fs.newNode(descr), nil
 }
 
 var _ = fuseFS.HandleReadDirAller(&Dir{})
 
 func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	ctx, cancel := d.fs.opContext(ctx)
+	defer cancel()
+
 	log.Infof("Read dir all in %d:%s", d.descriptor.GetInode(), d.descriptor.GetName())
 
-	collection, err := d.fs.RepositoryRegistry.GetDescriptorRepository().FindChildrenByInode(d.descriptor.GetInode())
+	collection, err := d.fs.RepositoryRegistry.GetDescriptorRepository().FindChildrenByInode(ctx, d.descriptor.GetInode())
 	if err != nil {
-		return nil, err
+		return nil, toFuseError(ctx, err)
 	}
 
 	var res []fuse.Dirent
@@ -91,37 +163,46 @@ func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 		item := item.(db.DescriptorInterface)
 
 		var de fuse.Dirent
-		if item.GetType() == db.DT_Dir {
-			de.Type = fuse.DT_Dir
-		} else {
-			de.Type = fuse.DT_File
-		}
+		de.Type = direntType(item)
 		de.Name = item.GetName()
 		res = append(res, de)
 	}
 
+	d.fs.touchAtime(ctx, d.descriptor.GetInode())
+
 	return res, nil
 }
 
 var _ fuseFS.NodeMkdirer = (*Dir)(nil)
 
 func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fuseFS.Node, error) {
+	ctx, cancel := d.fs.opContext(ctx)
+	defer cancel()
+
 	log.Infof("Mkdir for %d:%s", d.descriptor.GetInode(), req.Name)
 
-	repo := d.fs.RepositoryRegistry.GetDescriptorRepository()
-	isExists, err := repo.IsExistsByName(d.descriptor.GetInode(), req.Name)
-	if err != nil {
-		log.Warnf("Error: %s: ", err.Error())
+	if err := d.fs.checkChange(req.Header); err != nil {
 		return nil, err
 	}
-	if isExists {
-		msg := fmt.Sprintf("Directory with name \"%s\" already exists", req.Name)
-		log.Warn(msg)
-		return nil, fmt.Errorf(msg)
+	if err := validateName(req.Name); err != nil {
+		return nil, err
+	}
+	if err := d.fs.access(ctx, newCaller(req.Header), d.descriptor.GetInode(), accessWrite|accessExec); err != nil {
+		return nil, toFuseError(ctx, err)
+	}
+	if err := d.fs.reserve(ctx, 0, 1); err != nil {
+		return nil, toFuseError(ctx, err)
 	}
 
-	perm := Permission(req.Mode.Perm())
-	newDescr, err := repo.Create(d.descriptor.GetInode(), req.Name, db.DT_Dir, db.DescriptorAttrs{
+	mode, inherited, err := d.fs.inheritACL(ctx, d.descriptor.GetInode(), req.Mode, req.Umask, true)
+	if err != nil {
+		log.Errorf("Error reading default ACL. Error: %s", err.Error())
+		return nil, toFuseError(ctx, err)
+	}
+
+	repo := d.fs.RepositoryRegistry.GetDescriptorRepository()
+	perm := Permission(mode)
+	newDescr, err := repo.Create(ctx, d.descriptor.GetInode(), req.Name, db.DT_Dir, db.DescriptorAttrs{
 		GID:        req.Gid,
 		UID:        req.Uid,
 		Permission: perm.ToOctalString(),
@@ -129,7 +210,11 @@ func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fuseFS.Node, e
 	})
 	if err != nil {
 		log.Errorf("Error creating directory. Error: %s", err.Error())
-		return nil, err
+		return nil, toFuseError(ctx, err)
+	}
+	if err := d.fs.storeInheritedACL(ctx, newDescr.GetInode(), inherited); err != nil {
+		log.Errorf("Error storing inherited ACL. Error: %s", err.Error())
+		return nil, toFuseError(ctx, err)
 	}
 
 	return &Dir{
@@ -141,22 +226,46 @@ func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fuseFS.Node, e
 var _ = fuseFS.NodeCreater(&Dir{})
 
 func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fuseFS.Node, fuseFS.Handle, error) {
-	log.Infof("Create file %s in %s[%d]", req.Name, d.descriptor.GetName(), d.descriptor.GetInode())
+	ctx, cancel := d.fs.opContext(ctx)
+	defer cancel()
+
+	log.Infof("Create file %s in %s[%d]. Flags: %s", req.Name, d.descriptor.GetName(), d.descriptor.GetInode(), req.Flags)
+
+	if err := d.fs.admit(req.Header); err != nil {
+		return nil, nil, err
+	}
+	if err := validateName(req.Name); err != nil {
+		return nil, nil, err
+	}
+	if err := d.fs.access(ctx, newCaller(req.Header), d.descriptor.GetInode(), accessWrite|accessExec); err != nil {
+		return nil, nil, toFuseError(ctx, err)
+	}
 
 	repo := d.fs.RepositoryRegistry.GetDescriptorRepository()
-	isExists, err := repo.IsExistsByName(d.descriptor.GetInode(), req.Name)
+	existing, err := repo.FindSingleByName(ctx, d.descriptor.GetInode(), req.Name)
 	if err != nil {
 		log.Warnf("Error: %s: ", err.Error())
+		return nil, nil, toFuseError(ctx, err)
+	}
+	if existing != nil {
+		return d.openExisting(ctx, existing, req, resp)
+	}
+
+	if err := d.fs.checkWritable(); err != nil {
 		return nil, nil, err
 	}
-	if isExists {
-		msg := fmt.Sprintf("File with name \"%s\" already exists", req.Name)
-		log.Warn(msg)
-		return nil, nil, fmt.Errorf(msg)
+	if err := d.fs.reserve(ctx, 0, 1); err != nil {
+		return nil, nil, toFuseError(ctx, err)
 	}
 
-	perm := Permission(req.Mode.Perm())
-	newDescr, err := repo.Create(d.descriptor.GetInode(), req.Name, db.DT_File, db.DescriptorAttrs{
+	mode, inherited, err := d.fs.inheritACL(ctx, d.descriptor.GetInode(), req.Mode, req.Umask, false)
+	if err != nil {
+		log.Errorf("Error reading default ACL. Error: %s", err.Error())
+		return nil, nil, toFuseError(ctx, err)
+	}
+
+	perm := Permission(mode)
+	newDescr, err := repo.Create(ctx, d.descriptor.GetInode(), req.Name, db.DT_File, db.DescriptorAttrs{
 		GID:        req.Gid,
 		UID:        req.Uid,
 		Permission: perm.ToOctalString(),
@@ -164,23 +273,209 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
 	})
 	if err != nil {
 		log.Errorf("Error creating file. Error: %s", err.Error())
-		return nil, nil, err
+		return nil, nil, toFuseError(ctx, err)
+	}
+	if err := d.fs.storeInheritedACL(ctx, newDescr.GetInode(), inherited); err != nil {
+		log.Errorf("Error storing inherited ACL. Error: %s", err.Error())
+		return nil, nil, toFuseError(ctx, err)
 	}
 
-	file := File{
+	file := &File{
 		descriptor: newDescr,
 		fs:         d.fs,
 	}
-	handle := FileHandle{file: &file}
+	handle, err := file.open(ctx, req.Flags&^fuse.OpenTruncate, &resp.OpenResponse)
+	if err != nil {
+		log.Errorf("Error opening file. Error: %s", err.Error())
+		return nil, nil, toFuseError(ctx, err)
+	}
+	resp.EntryValid = d.fs.Options.CacheTTL
+
+	return file, handle, nil
+}
+
+// openExisting opens the file that was created after the lookup of the kernel, as open(2)
+// does with O_CREAT for an existing file.
+func (d *Dir) openExisting(ctx context.Context, existing db.DescriptorInterface, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fuseFS.Node, fuseFS.Handle, error) {
+	if req.Flags&fuse.OpenExclusive != 0 {
+		log.Warnf("Entry with name \"%s\" already exists", req.Name)
+		return nil, nil, fuse.EEXIST
+	}
+	if existing.GetType() == db.DT_Dir {
+		return nil, nil, fuse.Errno(syscall.EISDIR)
+	}
+	if existing.GetType() != db.DT_File {
+		return nil, nil, fuse.EEXIST
+	}
+	if err := d.fs.checkOpen(ctx, newCaller(req.Header), existing, req.Flags); err != nil {
+		return nil, nil, err
+	}
+
+	file := &File{
+		descriptor: existing,
+		fs:         d.fs,
+	}
+	handle, err := file.open(ctx, req.Flags, &resp.OpenResponse)
+	if err != nil {
+		log.Errorf("Error opening file. Error: %s", err.Error())
+		return nil, nil, toFuseError(ctx, err)
+	}
+	resp.EntryValid = d.fs.Options.CacheTTL
 
-	return &file, &handle, nil
+	return file, handle, nil
+}
+
+var _ = fuseFS.NodeLinker(&Dir{})
+
+func (d *Dir) Link(ctx context.Context, req *fuse.LinkRequest, old fuseFS.Node) (fuseFS.Node, error) {
+	ctx, cancel := d.fs.opContext(ctx)
+	defer cancel()
+
+	var inode db.Inode
+	switch node := old.(type) {
+	case *File:
+		inode = node.descriptor.GetInode()
+	case *Symlink:
+		inode = node.descriptor.GetInode()
+	default:
+		return nil, fuse.EPERM
+	}
+
+	log.Infof("Link %d as %s in %s[%d]", inode, req.NewName, d.descriptor.GetName(), d.descriptor.GetInode())
+
+	if err := d.fs.checkChange(req.Header); err != nil {
+		return nil, err
+	}
+	if err := validateName(req.NewName); err != nil {
+		return nil, err
+	}
+	if err := d.fs.access(ctx, newCaller(req.Header), d.descriptor.GetInode(), accessWrite|accessExec); err != nil {
+		return nil, toFuseError(ctx, err)
+	}
+	// A link takes no inode, it is rejected only when the volume is already over its limits.
+	if err := d.fs.reserve(ctx, 0, 0); err != nil {
+		return nil, toFuseError(ctx, err)
+	}
+
+	repo := d.fs.RepositoryRegistry.GetDescriptorRepository()
+	if _, err := repo.Link(ctx, inode, d.descriptor.GetInode(), req.NewName); err != nil {
+		log.Errorf("Error creating link. Error: %s", err.Error())
+		return nil, toFuseError(ctx, err)
+	}
+
+	return old, nil
+}
+
+var _ = fuseFS.NodeSymlinker(&Dir{})
+
+func (d *Dir) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fuseFS.Node, error) {
+	ctx, cancel := d.fs.opContext(ctx)
+	defer cancel()
+
+	log.Infof("Symlink %s -> %s in %s[%d]", req.NewName, req.Target, d.descriptor.GetName(), d.descriptor.GetInode())
+
+	if err := d.fs.checkChange(req.Header); err != nil {
+		return nil, err
+	}
+	if err := validateName(req.NewName); err != nil {
+		return nil, err
+	}
+	if err := d.fs.access(ctx, newCaller(req.Header), d.descriptor.GetInode(), accessWrite|accessExec); err != nil {
+		return nil, toFuseError(ctx, err)
+	}
+	if err := d.fs.reserve(ctx, uint64(len(req.Target)), 1); err != nil {
+		return nil, toFuseError(ctx, err)
+	}
+
+	repo := d.fs.RepositoryRegistry.GetDescriptorRepository()
+	newDescr, err := repo.CreateSymlink(ctx, d.descriptor.GetInode(), req.NewName, req.Target, db.DescriptorAttrs{
+		GID:        req.Gid,
+		UID:        req.Uid,
+		Permission: Permission(os.ModePerm).ToOctalString(),
+		Size:       uint64(len(req.Target)),
+	})
+	if err != nil {
+		log.Errorf("Error creating symlink. Error: %s", err.Error())
+		return nil, toFuseError(ctx, err)
+	}
+
+	return d.fs.newNode(newDescr), nil
 }
 
 var _ = fuseFS.NodeRemover(&Dir{})
 
 func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	ctx, cancel := d.fs.opContext(ctx)
+	defer cancel()
+
 	log.Infof("Removing entry %s in %s[%d]", req.Name, d.descriptor.GetName(), d.descriptor.GetInode())
 
+	if err := d.fs.checkChange(req.Header); err != nil {
+		return err
+	}
+
+	if err := d.fs.checkUnlink(ctx, newCaller(req.Header), d.descriptor.GetInode(), req.Name); err != nil {
+		return toFuseError(ctx, err)
+	}
+
+	repo := d.fs.RepositoryRegistry.GetDescriptorRepository()
+	if err := repo.RemoveByName(ctx, d.descriptor.GetInode(), req.Name, req.Dir); err != nil {
+		log.Warnf("Error: %s", err.Error())
+		return toFuseError(ctx, err)
+	}
+
+	return nil
+}
+
+var _ = fuseFS.NodeRenamer(&Dir{})
+
+func (d *Dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fuseFS.Node) error {
+	ctx, cancel := d.fs.opContext(ctx)
+	defer cancel()
+
+	target, ok := newDir.(*Dir)
+	if !ok {
+		return fuse.Errno(syscall.ENOTDIR)
+	}
+
+	log.Infof("Renaming entry %s in %s[%d] to %s in %s[%d]",
+		req.OldName, d.descriptor.GetName(), d.descriptor.GetInode(),
+		req.NewName, target.descriptor.GetName(), target.descriptor.GetInode(),
+	)
+
+	if err := d.fs.checkChange(req.Header); err != nil {
+		return err
+	}
+	if err := validateName(req.NewName); err != nil {
+		return err
+	}
+	err := d.fs.checkRename(ctx, newCaller(req.Header), d.descriptor.GetInode(), req.OldName, target.descriptor.GetInode(), req.NewName)
+	if err != nil {
+		return toFuseError(ctx, err)
+	}
+
 	repo := d.fs.RepositoryRegistry.GetDescriptorRepository()
-	return repo.RemoveByName(d.descriptor.GetInode(), req.Name)
+	err = repo.Rename(ctx, d.descriptor.GetInode(), req.OldName, target.descriptor.GetInode(), req.NewName)
+	if err != nil {
+		log.Warnf("Error: %s", err.Error())
+		return toFuseError(ctx, err)
+	}
+
+	return nil
+}
+
+// validateName checks the name of a new or looked up entry. The names are stored as is,
+// so a name should be a single valid UTF-8 path component no longer than db.MaxNameLength bytes.
+func validateName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/\x00") {
+		return fuse.Errno(syscall.EINVAL)
+	}
+	if len(name) > db.MaxNameLength {
+		return fuse.Errno(syscall.ENAMETOOLONG)
+	}
+	if !utf8.ValidString(name) {
+		return fuse.Errno(syscall.EILSEQ)
+	}
+
+	return nil
 }
@@ -0,0 +1,80 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"golang.org/x/net/context"
+	"syscall"
+)
+
+// toFuseError converts repository errors to the errno values expected by the kernel.
+// Errors of an interrupted request are reported as EINTR and errors of a timed out request as EIO,
+// whatever error the database driver returned for the aborted query.
+// Unknown errors are passed as is and are reported as EIO.
+func toFuseError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
+
+	var (
+		notFoundErr      *db.NotFoundError
+		existsErr        *db.ExistsError
+		nameTooLongErr   *db.NameTooLongError
+		constraintErr    *db.ConstraintError
+		notEmptyErr      *db.NotEmptyError
+		isDirErr         *db.IsDirError
+		notDirErr        *db.NotDirError
+		subtreeMoveErr   *db.SubtreeMoveError
+		xattrNotFoundErr *db.XattrNotFoundError
+		xattrExistsErr   *db.XattrExistsError
+		noSpaceErr       *db.NoSpaceError
+	)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return fuse.EINTR
+	case errors.Is(err, context.DeadlineExceeded):
+		return fuse.EIO
+	case errors.As(err, &notFoundErr):
+		return fuse.ENOENT
+	case errors.As(err, &existsErr):
+		return fuse.EEXIST
+	case errors.As(err, &nameTooLongErr):
+		return fuse.Errno(syscall.ENAMETOOLONG)
+	case errors.As(err, &notEmptyErr):
+		return fuse.Errno(syscall.ENOTEMPTY)
+	case errors.As(err, &isDirErr):
+		return fuse.Errno(syscall.EISDIR)
+	case errors.As(err, &notDirErr):
+		return fuse.Errno(syscall.ENOTDIR)
+	case errors.As(err, &subtreeMoveErr):
+		return fuse.Errno(syscall.EINVAL)
+	case errors.As(err, &xattrNotFoundErr):
+		return fuse.ErrNoXattr
+	case errors.As(err, &xattrExistsErr):
+		return fuse.EEXIST
+	case errors.As(err, &noSpaceErr):
+		return fuse.Errno(syscall.ENOSPC)
+	case errors.As(err, &constraintErr):
+		return fuse.Errno(syscall.EINVAL)
+	}
+
+	return err
+}
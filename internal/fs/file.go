@@ -32,13 +32,61 @@ type File struct {
 var _ fuseFS.Node = (*File)(nil)
 
 func (f *File) Attr(ctx context.Context, attr *fuse.Attr) error {
+	ctx, cancel := f.fs.opContext(ctx)
+	defer cancel()
+
 	log.Infof("Reads file attrs of %d:%s", f.descriptor.GetInode(), db.RootName)
 
-	attr.Inode = uint64(f.descriptor.GetInode())
-	attr.Mode = f.descriptor.GetPermission()
-	attr.Uid = f.descriptor.GetUID()
-	attr.Gid = f.descriptor.GetGID()
-	attr.Size = f.descriptor.GetSize()
+	descr, err := f.fs.findDescriptor(ctx, f.descriptor.GetInode())
+	if err != nil {
+		log.Warnf("Error: %s", err.Error())
+		return toFuseError(ctx, err)
+	}
+	f.fs.fillAttr(descr, attr)
+	attr.Size = f.fs.bufferedSize(descr.GetInode(), attr.Size)
+
+	return nil
+}
+
+var _ = fuseFS.NodeSetattrer(&File{})
+
+func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	ctx, cancel := f.fs.opContext(ctx)
+	defer cancel()
+
+	log.Infof("Setattr for file %d:%s. Valid: %s", f.descriptor.GetInode(), f.descriptor.GetName(), req.Valid)
+
+	if err := f.fs.checkChange(req.Header); err != nil {
+		return err
+	}
+
+	if err := f.fs.writeBackFile(ctx, f.descriptor.GetInode()); err != nil {
+		log.Errorf("Error: %s", err.Error())
+		return toFuseError(ctx, err)
+	}
+
+	descr, err := f.fs.setAttr(ctx, f.descriptor.GetInode(), req)
+	if err != nil {
+		log.Errorf("Error: %s", err.Error())
+		return toFuseError(ctx, err)
+	}
+	f.fs.fillAttr(descr, &resp.Attr)
+
+	return nil
+}
+
+var _ = fuseFS.NodeAccesser(&File{})
+
+func (f *File) Access(ctx context.Context, req *fuse.AccessRequest) error {
+	ctx, cancel := f.fs.opContext(ctx)
+	defer cancel()
+
+	if err := f.fs.checkAccessRequest(req); err != nil {
+		return err
+	}
+	if err := f.fs.access(ctx, newCaller(req.Header), f.descriptor.GetInode(), req.Mask); err != nil {
+		return toFuseError(ctx, err)
+	}
 
 	return nil
 }
@@ -46,10 +94,70 @@ func (f *File) Attr(ctx context.Context, attr *fuse.Attr) error {
 var _ = fuseFS.NodeOpener(&File{})
 
 func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fuseFS.Handle, error) {
-	log.Infof("Opening file %d:%s", f.descriptor.GetInode(), f.descriptor.GetName())
+	ctx, cancel := f.fs.opContext(ctx)
+	defer cancel()
 
-	//resp.Flags |= fuse.OpenNonSeekable
-	resp.Flags |= fuse.OpenDirectIO
+	log.Infof("Opening file %d:%s. Flags: %s", f.descriptor.GetInode(), f.descriptor.GetName(), req.Flags)
 
-	return &FileHandle{file: f}, nil
+	if err := f.fs.admit(req.Header); err != nil {
+		return nil, err
+	}
+
+	if f.fs.checksPermissions() {
+		descr, err := f.fs.findDescriptor(ctx, f.descriptor.GetInode())
+		if err != nil {
+			return nil, toFuseError(ctx, err)
+		}
+		if err := f.fs.checkOpen(ctx, newCaller(req.Header), descr, req.Flags); err != nil {
+			return nil, err
+		}
+	}
+
+	handle, err := f.open(ctx, req.Flags, resp)
+	if err != nil {
+		log.Errorf("Error: %s", err.Error())
+		return nil, toFuseError(ctx, err)
+	}
+
+	return handle, nil
+}
+
+// open truncates the file if O_TRUNC is requested for writing and creates the handle.
+func (f *File) open(ctx context.Context, flags fuse.OpenFlags, resp *fuse.OpenResponse) (*FileHandle, error) {
+	if !flags.IsReadOnly() {
+		if err := f.fs.checkWritable(); err != nil {
+			return nil, err
+		}
+	}
+
+	if flags&fuse.OpenTruncate != 0 && !flags.IsReadOnly() {
+		if err := f.fs.writeBackFile(ctx, f.descriptor.GetInode()); err != nil {
+			return nil, err
+		}
+		if err := f.fs.RepositoryRegistry.GetDataBlockRepository().Truncate(ctx, f.descriptor, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.fs.Options.DirectIO {
+		resp.Flags |= fuse.OpenDirectIO
+	}
+
+	return f.fs.openHandle(f, flags), nil
+}
+
+var _ = fuseFS.NodeFsyncer(&File{})
+
+func (f *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	ctx, cancel := f.fs.opContext(ctx)
+	defer cancel()
+
+	log.Infof("Fsync file %d:%s", f.descriptor.GetInode(), f.descriptor.GetName())
+
+	if err := f.fs.writeBackFile(ctx, f.descriptor.GetInode()); err != nil {
+		log.Errorf("Error: %s", err.Error())
+		return toFuseError(ctx, err)
+	}
+
+	return nil
 }
@@ -19,52 +19,89 @@ package fs
 import (
 	"bazil.org/fuse"
 	fuseFS "bazil.org/fuse/fs"
-	"fmt"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
+	"sync"
+	"syscall"
 )
 
+// FileHandle buffers the written data until Flush, Fsync or Release, or until the buffer
+// exceeds the write buffer size of the mount.
 type FileHandle struct {
-	file *File
+	file  *File
+	flags fuse.OpenFlags
+
+	mu     sync.Mutex
+	buffer writeBuffer
+	// err is the error of a failed write back, reported by the next Flush of the handle.
+	err error
 }
 
 var _ fuseFS.Handle = (*FileHandle)(nil)
 
+var _ fuseFS.HandleFlusher = (*FileHandle)(nil)
+
+func (fh *FileHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	ctx, cancel := fh.file.fs.opContext(ctx)
+	defer cancel()
+
+	descr := fh.file.descriptor
+	log.Infof("Flush file %d:%s", descr.GetInode(), descr.GetName())
+
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	fh.writeBackLocked(ctx)
+	err := fh.err
+	fh.err = nil
+	if err != nil {
+		log.Errorf("Error flushing %s[%d] file. Error: %s", descr.GetName(), descr.GetInode(), err)
+		return toFuseError(ctx, err)
+	}
+
+	return nil
+}
+
 var _ fuseFS.HandleReleaser = (*FileHandle)(nil)
 
 func (fh *FileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	ctx, cancel := fh.file.fs.opContext(ctx)
+	defer cancel()
+
+	defer fh.file.fs.releaseHandle(fh)
+
+	// The kernel ignores the result of Release, the data is normally persisted by Flush before.
+	if err := fh.writeBack(ctx); err != nil {
+		descr := fh.file.descriptor
+		log.Errorf("Error writing back %s[%d] file on release. Error: %s", descr.GetName(), descr.GetInode(), err)
+	}
+
 	return nil
 }
 
 var _ = fuseFS.HandleReader(&FileHandle{})
 
 func (fh *FileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	ctx, cancel := fh.file.fs.opContext(ctx)
+	defer cancel()
+
 	descr := fh.file.descriptor
 	log.Infof("Reading file %d:%s", descr.GetInode(), descr.GetName())
 
+	if err := fh.file.fs.writeBackFile(ctx, descr.GetInode()); err != nil {
+		log.Errorf("Error: %s", err.Error())
+		return toFuseError(ctx, err)
+	}
+
 	repo := fh.file.fs.RepositoryRegistry.GetDataBlockRepository()
-	dataBlock, err := repo.FindFirst(descr)
+	data, err := repo.Read(ctx, descr, uint64(req.Offset), req.Size)
 	if err != nil {
 		log.Errorf("Error: %s", err.Error())
-		return err
-	}
-	if dataBlock == nil {
-		msg := fmt.Sprintf("Data block for descriptor %d:%s not found", descr.GetInode(), descr.GetName())
-		log.Error(msg)
-		return fmt.Errorf(msg)
+		return toFuseError(ctx, err)
 	}
+	resp.Data = data
 
-	data := *dataBlock.GetData()
-	if req.Offset >= int64(len(data)) {
-		data = nil
-	} else {
-		data = data[req.Offset:]
-	}
-	if len(data) > req.Size {
-		data = data[:req.Size]
-	}
-	n := copy(resp.Data[:req.Size], data)
-	resp.Data = resp.Data[:n]
+	fh.file.fs.touchAtime(ctx, descr.GetInode())
 
 	return nil
 }
@@ -72,25 +109,101 @@ func (fh *FileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fus
 var _ = fuseFS.HandleWriter(&FileHandle{})
 
 func (fh *FileHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	ctx, cancel := fh.file.fs.opContext(ctx)
+	defer cancel()
+
 	descr := fh.file.descriptor
 	log.Infof("Write file %d:%s", descr.GetInode(), descr.GetName())
 
-	repo := fh.file.fs.RepositoryRegistry.GetDataBlockRepository()
-	dataBlock, err := repo.FindFirst(descr)
-	if err != nil {
-		log.Errorf("Error: %s", err.Error())
-		return err
+	if fh.flags.IsReadOnly() {
+		return fuse.Errno(syscall.EBADF)
 	}
 
-	addedSize := dataBlock.Add(uint64(req.Offset), &req.Data)
+	if fh.flags&fuse.OpenAppend != 0 {
+		return fh.append(ctx, req, resp)
+	}
 
-	err = repo.Write(descr, dataBlock.GetData())
-	if err != nil {
+	if err := fh.file.fs.reserveWrite(ctx, descr.GetInode(), uint64(req.Offset), len(req.Data)); err != nil {
 		log.Errorf("Error write to %s[%d] file. Error: %s", descr.GetName(), descr.GetInode(), err)
-		return err
+		return toFuseError(ctx, err)
 	}
 
+	if fh.file.fs.Options.WriteBuffer <= 0 {
+		repo := fh.file.fs.RepositoryRegistry.GetDataBlockRepository()
+		addedSize, err := repo.Write(ctx, descr, uint64(req.Offset), req.Data)
+		if err != nil {
+			log.Errorf("Error write to %s[%d] file. Error: %s", descr.GetName(), descr.GetInode(), err)
+			return toFuseError(ctx, err)
+		}
+		resp.Size = addedSize
+
+		return nil
+	}
+
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	fh.buffer.add(uint64(req.Offset), req.Data)
+	if fh.buffer.size >= fh.file.fs.Options.WriteBuffer {
+		if err := fh.writeBackLocked(ctx); err != nil {
+			log.Errorf("Error write to %s[%d] file. Error: %s", descr.GetName(), descr.GetInode(), err)
+			fh.err = nil
+			return toFuseError(ctx, err)
+		}
+	}
+	resp.Size = len(req.Data)
+
+	return nil
+}
+
+// append writes the data at the end of the file whatever the offset of the request is. The data is not
+// buffered, as the end of the file is only known to the database when other mounts append to it too.
+func (fh *FileHandle) append(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	descr := fh.file.descriptor
+
+	if err := fh.file.fs.writeBackFile(ctx, descr.GetInode()); err != nil {
+		log.Errorf("Error write to %s[%d] file. Error: %s", descr.GetName(), descr.GetInode(), err)
+		return toFuseError(ctx, err)
+	}
+	if err := fh.file.fs.reserve(ctx, uint64(len(req.Data)), 0); err != nil {
+		log.Errorf("Error append to %s[%d] file. Error: %s", descr.GetName(), descr.GetInode(), err)
+		return toFuseError(ctx, err)
+	}
+
+	addedSize, err := fh.file.fs.RepositoryRegistry.GetDataBlockRepository().Append(ctx, descr, req.Data)
+	if err != nil {
+		log.Errorf("Error append to %s[%d] file. Error: %s", descr.GetName(), descr.GetInode(), err)
+		return toFuseError(ctx, err)
+	}
 	resp.Size = addedSize
 
 	return nil
 }
+
+func (fh *FileHandle) bufferEnd() uint64 {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	return fh.buffer.end()
+}
+
+func (fh *FileHandle) writeBack(ctx context.Context) error {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+
+	return fh.writeBackLocked(ctx)
+}
+
+// writeBackLocked persists the buffered data. The data is dropped on failure and the error is kept
+// to be reported by the next Flush, so the application learns about the lost writes on close().
+func (fh *FileHandle) writeBackLocked(ctx context.Context) error {
+	repo := fh.file.fs.RepositoryRegistry.GetDataBlockRepository()
+	for _, e := range fh.buffer.take() {
+		if _, err := repo.Write(ctx, fh.file.descriptor, e.offset, e.data); err != nil {
+			fh.err = err
+			return err
+		}
+	}
+
+	return nil
+}
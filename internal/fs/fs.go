@@ -22,7 +22,10 @@ import (
 	"fmt"
 	"github.com/kos-v/dbunderfs/internal/db"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
 	"io/fs"
+	"os"
+	"sync"
 )
 
 type RootNotFoundError struct{ err string }
@@ -31,8 +34,8 @@ func (err *RootNotFoundError) Error() string {
 	return fmt.Sprintf("Root \"%s\" not found.", err.err)
 }
 
-func Mount(point string, repositoryRegistry db.RepositoryRegistry) error {
-	conn, err := fuse.Mount(point)
+func Mount(point string, repositoryRegistry db.RepositoryRegistry, opts Options) error {
+	conn, err := fuse.Mount(point, mountOptions(opts)...)
 	if err != nil {
 		return err
 	}
@@ -40,6 +43,19 @@ func Mount(point string, repositoryRegistry db.RepositoryRegistry) error {
 
 	filesys := &FS{
 		RepositoryRegistry: repositoryRegistry,
+		Options:            opts,
+		owner:              uint32(os.Getuid()),
+	}
+
+	// The root is checked before the mount is reported as ready, so a volume without the root
+	// fails the mount instead of every request to it.
+	if _, err := filesys.Root(); err != nil {
+		_ = fuse.Unmount(point)
+		return err
+	}
+
+	if opts.OnReady != nil {
+		opts.OnReady()
 	}
 
 	if err := fuseFS.Serve(conn, filesys); err != nil {
@@ -49,14 +65,53 @@ func Mount(point string, repositoryRegistry db.RepositoryRegistry) error {
 	return nil
 }
 
+// mountOptions returns the options passed to the kernel. Allowing access to root only is not
+// an option of the kernel: the mount allows access to all the users and the filesystem rejects
+// the requests of the others.
+func mountOptions(opts Options) []fuse.MountOption {
+	var options []fuse.MountOption
+	if opts.DefaultPermissions {
+		options = append(options, fuse.DefaultPermissions())
+	}
+	if opts.ReadOnly {
+		options = append(options, fuse.ReadOnly())
+	}
+	if opts.AllowOther || opts.AllowRoot {
+		options = append(options, fuse.AllowOther())
+	}
+	if opts.FSName != "" {
+		options = append(options, fuse.FSName(opts.FSName))
+	}
+	if opts.Subtype != "" {
+		options = append(options, fuse.Subtype(opts.Subtype))
+	}
+	if opts.MaxReadahead > 0 {
+		options = append(options, fuse.MaxReadahead(opts.MaxReadahead))
+	}
+
+	return options
+}
+
 type FS struct {
 	RepositoryRegistry db.RepositoryRegistry
+	Options            Options
+
+	// owner is the user who mounted the filesystem.
+	owner uint32
+
+	handlesMu sync.Mutex
+	// handles are the open file handles by inode. The buffered data of the handles is written
+	// back before the file is read or changed through any other handle or node.
+	handles map[db.Inode]map[*FileHandle]struct{}
+
+	usageMu sync.Mutex
+	usage   *usageCache
 }
 
 func (f *FS) Root() (fuseFS.Node, error) {
 	log.Infof("Finding root %s", db.RootName)
 
-	descr, err := f.RepositoryRegistry.GetDescriptorRepository().FindRoot()
+	descr, err := f.RepositoryRegistry.GetDescriptorRepository().FindRoot(context.Background())
 	if err != nil {
 		log.Warnf("Root finding error: %s", err.Error())
 		return nil, err
@@ -74,8 +129,105 @@ func (f *FS) Root() (fuseFS.Node, error) {
 
 var _ fuseFS.FS = (*FS)(nil)
 
+// opContext limits the context of a request with the operation timeout of the mount.
+// The context is canceled by the FUSE server if the kernel interrupts the request.
+func (f *FS) opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if f.Options.OpTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, f.Options.OpTimeout)
+}
+
+func (f *FS) openHandle(file *File, flags fuse.OpenFlags) *FileHandle {
+	fh := &FileHandle{file: file, flags: flags}
+
+	f.handlesMu.Lock()
+	defer f.handlesMu.Unlock()
+
+	if f.handles == nil {
+		f.handles = make(map[db.Inode]map[*FileHandle]struct{})
+	}
+	inode := file.descriptor.GetInode()
+	if f.handles[inode] == nil {
+		f.handles[inode] = make(map[*FileHandle]struct{})
+	}
+	f.handles[inode][fh] = struct{}{}
+
+	return fh
+}
+
+func (f *FS) releaseHandle(fh *FileHandle) {
+	f.handlesMu.Lock()
+	defer f.handlesMu.Unlock()
+
+	inode := fh.file.descriptor.GetInode()
+	delete(f.handles[inode], fh)
+	if len(f.handles[inode]) == 0 {
+		delete(f.handles, inode)
+	}
+}
+
+func (f *FS) fileHandles(inode db.Inode) []*FileHandle {
+	f.handlesMu.Lock()
+	defer f.handlesMu.Unlock()
+
+	var handles []*FileHandle
+	for fh := range f.handles[inode] {
+		handles = append(handles, fh)
+	}
+
+	return handles
+}
+
+// writeBackFile persists the buffered data of all open handles of the file.
+func (f *FS) writeBackFile(ctx context.Context, inode db.Inode) error {
+	for _, fh := range f.fileHandles(inode) {
+		if err := fh.writeBack(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bufferedSize returns the size of the file including the data buffered by its open handles.
+func (f *FS) bufferedSize(inode db.Inode, size uint64) uint64 {
+	for _, fh := range f.fileHandles(inode) {
+		if end := fh.bufferEnd(); end > size {
+			size = end
+		}
+	}
+
+	return size
+}
+
+func (f *FS) newNode(descr db.DescriptorInterface) fuseFS.Node {
+	switch descr.GetType() {
+	case db.DT_Dir:
+		return &Dir{descriptor: descr, fs: f}
+	case db.DT_Symlink:
+		return &Symlink{descriptor: descr, fs: f}
+	}
+
+	return &File{descriptor: descr, fs: f}
+}
+
 type Permission fs.FileMode
 
 func (p Permission) ToOctalString() string {
-	return fmt.Sprintf("%#o", p)
+	mode := fs.FileMode(p)
+
+	octal := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		octal |= 04000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		octal |= 02000
+	}
+	if mode&fs.ModeSticky != 0 {
+		octal |= 01000
+	}
+
+	return fmt.Sprintf("%04o", octal)
 }
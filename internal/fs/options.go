@@ -0,0 +1,85 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"fmt"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"os"
+	"time"
+)
+
+type AtimeMode string
+
+const (
+	AtimeStrict   AtimeMode = "strict"
+	AtimeRelative AtimeMode = "relatime"
+	AtimeNone     AtimeMode = "noatime"
+)
+
+func ParseAtimeMode(mode string) (AtimeMode, error) {
+	switch AtimeMode(mode) {
+	case AtimeStrict, AtimeRelative, AtimeNone:
+		return AtimeMode(mode), nil
+	}
+
+	return "", fmt.Errorf("unexpected value %q for atime mode. Should be %q, %q or %q", mode, AtimeStrict, AtimeRelative, AtimeNone)
+}
+
+type Options struct {
+	Atime AtimeMode
+	// DefaultPermissions delegates the permission checks to the kernel, which checks the mode bits
+	// of the attributes before sending the requests. Otherwise the filesystem checks them itself.
+	DefaultPermissions bool
+	// DirectIO disables the page cache of the kernel for the files, so the data written by other
+	// mounts of the volume is visible on the next read.
+	DirectIO bool
+	// CacheTTL is the time for which the descriptors may be cached by the repositories and the kernel.
+	// Zero disables caching.
+	CacheTTL time.Duration
+	// Limits are the capacity and the number of inodes reported by statfs. Creating entries and writing
+	// data beyond them fails with ENOSPC.
+	Limits db.VolumeLimits
+	// StatfsTTL is the time for which the usage of the volume reported by statfs is cached.
+	StatfsTTL time.Duration
+	// OpTimeout limits the duration of the database queries of a single request. Zero disables the limit.
+	OpTimeout time.Duration
+	// WriteBuffer is the size in bytes of the data an open file handle buffers before writing it to
+	// the database. Zero disables buffering.
+	WriteBuffer int
+
+	// ReadOnly rejects all the changes of the volume with EROFS.
+	ReadOnly bool
+	// AllowOther lets all the users access the mount. AllowRoot lets only root access it besides
+	// the user who mounted it.
+	AllowOther bool
+	AllowRoot  bool
+	// UID and GID, if set, override the owner and the group of all the entries.
+	UID *uint32
+	GID *uint32
+	// Umask clears the permission bits of all the entries.
+	Umask os.FileMode
+	// FSName and Subtype are the source and the type of the mount shown in the mount table.
+	FSName  string
+	Subtype string
+	// MaxReadahead limits the readahead of the kernel in bytes. Zero keeps the default of the kernel.
+	MaxReadahead uint32
+
+	// OnReady, if set, is called once the volume is mounted and its root is found, before the filesystem
+	// starts serving requests.
+	OnReady func()
+}
@@ -0,0 +1,156 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"bazil.org/fuse"
+	fuseFS "bazil.org/fuse/fs"
+	"github.com/kos-v/dbunderfs/internal/db"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"time"
+)
+
+const (
+	// statfsBlockSize is the unit of the block counts reported by statfs.
+	statfsBlockSize = 4096
+
+	// The limits reported for volumes without the configured capacity or inode limit.
+	unlimitedCapacity uint64 = 1 << 50
+	unlimitedInodes   uint64 = 1 << 32
+)
+
+// usageCache keeps the result of the aggregate queries of the volume usage for the statfs TTL.
+type usageCache struct {
+	usage   db.VolumeUsage
+	expires time.Time
+}
+
+var _ = fuseFS.FSStatfser(&FS{})
+
+func (f *FS) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.StatfsResponse) error {
+	ctx, cancel := f.opContext(ctx)
+	defer cancel()
+
+	log.Infof("Statfs")
+
+	if err := f.admit(req.Header); err != nil {
+		return err
+	}
+
+	usage, err := f.getUsage(ctx)
+	if err != nil {
+		log.Errorf("Error: %s", err.Error())
+		return toFuseError(ctx, err)
+	}
+
+	capacity := f.Options.Limits.Capacity
+	if capacity == 0 {
+		capacity = unlimitedCapacity
+	}
+	maxInodes := f.Options.Limits.MaxInodes
+	if maxInodes == 0 {
+		maxInodes = unlimitedInodes
+	}
+
+	resp.Bsize = statfsBlockSize
+	resp.Frsize = statfsBlockSize
+	resp.Namelen = db.MaxNameLength
+	resp.Blocks = capacity / statfsBlockSize
+	resp.Files = maxInodes
+
+	usedBlocks := (usage.Bytes + statfsBlockSize - 1) / statfsBlockSize
+	if usedBlocks < resp.Blocks {
+		resp.Bfree = resp.Blocks - usedBlocks
+		resp.Bavail = resp.Bfree
+	}
+	if usage.Inodes < maxInodes {
+		resp.Ffree = maxInodes - usage.Inodes
+	}
+
+	return nil
+}
+
+// getUsage returns the usage of the volume. The aggregate queries scan the whole descriptors table,
+// so their result is reused for the statfs TTL.
+func (f *FS) getUsage(ctx context.Context) (db.VolumeUsage, error) {
+	f.usageMu.Lock()
+	defer f.usageMu.Unlock()
+
+	return f.loadUsageLocked(ctx)
+}
+
+func (f *FS) loadUsageLocked(ctx context.Context) (db.VolumeUsage, error) {
+	if f.usage != nil && time.Now().Before(f.usage.expires) {
+		return f.usage.usage, nil
+	}
+
+	usage, err := f.RepositoryRegistry.GetDescriptorRepository().GetUsage(ctx)
+	if err != nil {
+		return usage, err
+	}
+	f.usage = &usageCache{usage: usage, expires: time.Now().Add(f.Options.StatfsTTL)}
+
+	return usage, nil
+}
+
+// reserve checks that the volume has room for the bytes and the inodes a change adds and counts them
+// in the cached usage. The usage of the volume is read once per statfs TTL, so the changes made through
+// other mounts of the volume within the TTL may exceed the limits.
+func (f *FS) reserve(ctx context.Context, bytes uint64, inodes uint64) error {
+	limits := f.Options.Limits
+	if limits.Capacity == 0 && limits.MaxInodes == 0 {
+		return nil
+	}
+
+	f.usageMu.Lock()
+	defer f.usageMu.Unlock()
+
+	usage, err := f.loadUsageLocked(ctx)
+	if err != nil {
+		return err
+	}
+	if limits.Capacity > 0 && usage.Bytes+bytes > limits.Capacity {
+		return &db.NoSpaceError{Limit: db.SettingCapacity, Value: limits.Capacity}
+	}
+	if limits.MaxInodes > 0 && usage.Inodes+inodes > limits.MaxInodes {
+		return &db.NoSpaceError{Limit: db.SettingMaxInodes, Value: limits.MaxInodes}
+	}
+	f.usage.usage.Bytes += bytes
+	f.usage.usage.Inodes += inodes
+
+	return nil
+}
+
+// reserveWrite reserves the bytes by which a write at the offset grows the file, including the data
+// buffered by the open handles of the file.
+func (f *FS) reserveWrite(ctx context.Context, inode db.Inode, offset uint64, size int) error {
+	if f.Options.Limits.Capacity == 0 {
+		return nil
+	}
+
+	descr, err := f.findDescriptor(ctx, inode)
+	if err != nil {
+		return err
+	}
+	end := offset + uint64(size)
+	if fileSize := f.bufferedSize(inode, descr.GetSize()); end > fileSize {
+		return f.reserve(ctx, end-fileSize, 0)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"bazil.org/fuse"
+	fuseFS "bazil.org/fuse/fs"
+	"github.com/kos-v/dbunderfs/internal/db"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+type Symlink struct {
+	descriptor db.DescriptorInterface
+	fs         *FS
+}
+
+var _ fuseFS.Node = (*Symlink)(nil)
+
+func (s *Symlink) Attr(ctx context.Context, attr *fuse.Attr) error {
+	ctx, cancel := s.fs.opContext(ctx)
+	defer cancel()
+
+	log.Infof("Reads symlink attrs of %d:%s", s.descriptor.GetInode(), s.descriptor.GetName())
+
+	descr, err := s.fs.findDescriptor(ctx, s.descriptor.GetInode())
+	if err != nil {
+		log.Warnf("Error: %s", err.Error())
+		return toFuseError(ctx, err)
+	}
+	s.fs.fillAttr(descr, attr)
+
+	return nil
+}
+
+var _ = fuseFS.NodeReadlinker(&Symlink{})
+
+func (s *Symlink) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	log.Infof("Reading symlink %d:%s", s.descriptor.GetInode(), s.descriptor.GetName())
+
+	if err := s.fs.admit(req.Header); err != nil {
+		return "", err
+	}
+
+	return s.descriptor.GetTarget(), nil
+}
+
+var _ = fuseFS.NodeSetattrer(&Symlink{})
+
+func (s *Symlink) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	ctx, cancel := s.fs.opContext(ctx)
+	defer cancel()
+
+	log.Infof("Setattr for symlink %d:%s. Valid: %s", s.descriptor.GetInode(), s.descriptor.GetName(), req.Valid)
+
+	if err := s.fs.checkChange(req.Header); err != nil {
+		return err
+	}
+
+	if req.Valid.Size() {
+		return fuse.EPERM
+	}
+
+	descr, err := s.fs.setAttr(ctx, s.descriptor.GetInode(), req)
+	if err != nil {
+		log.Warnf("Error: %s", err.Error())
+		return toFuseError(ctx, err)
+	}
+	s.fs.fillAttr(descr, &resp.Attr)
+
+	return nil
+}
@@ -0,0 +1,110 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import "sort"
+
+type extent struct {
+	offset uint64
+	data   []byte
+}
+
+func (e *extent) end() uint64 {
+	return e.offset + uint64(len(e.data))
+}
+
+// writeBuffer keeps the written data as sorted non-overlapping extents. Adjacent and overlapping
+// writes are merged, so sequential writes are persisted with a single repository call.
+type writeBuffer struct {
+	extents []extent
+	size    int
+}
+
+func (b *writeBuffer) add(offset uint64, data []byte) {
+	if b.extend(offset, data) {
+		return
+	}
+
+	added := extent{offset: offset, data: append([]byte(nil), data...)}
+
+	var extents []extent
+	for _, e := range b.extents {
+		if e.end() < added.offset || added.end() < e.offset {
+			extents = append(extents, e)
+			continue
+		}
+
+		start, end := e.offset, e.end()
+		if added.offset < start {
+			start = added.offset
+		}
+		if added.end() > end {
+			end = added.end()
+		}
+		merged := make([]byte, end-start)
+		copy(merged[e.offset-start:], e.data)
+		copy(merged[added.offset-start:], added.data)
+		added = extent{offset: start, data: merged}
+	}
+	extents = append(extents, added)
+	sort.Slice(extents, func(i, j int) bool {
+		return extents[i].offset < extents[j].offset
+	})
+
+	b.extents = extents
+	b.size = 0
+	for _, e := range extents {
+		b.size += len(e.data)
+	}
+}
+
+// extend writes the data into the last extent in place if the data starts within it or right after it,
+// which is the case of sequential writes. The extents before the last one end before it with a gap,
+// so they cannot be touched by the data.
+func (b *writeBuffer) extend(offset uint64, data []byte) bool {
+	if len(b.extents) == 0 {
+		return false
+	}
+	last := &b.extents[len(b.extents)-1]
+	if offset < last.offset || offset > last.end() {
+		return false
+	}
+
+	pos := offset - last.offset
+	n := copy(last.data[pos:], data)
+	last.data = append(last.data, data[n:]...)
+	b.size += len(data) - n
+
+	return true
+}
+
+// end returns the offset after the last buffered byte.
+func (b *writeBuffer) end() uint64 {
+	if len(b.extents) == 0 {
+		return 0
+	}
+
+	return b.extents[len(b.extents)-1].end()
+}
+
+func (b *writeBuffer) take() []extent {
+	extents := b.extents
+	b.extents = nil
+	b.size = 0
+
+	return extents
+}
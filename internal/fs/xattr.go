@@ -0,0 +1,214 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"bazil.org/fuse"
+	fuseFS "bazil.org/fuse/fs"
+	"github.com/kos-v/dbunderfs/internal/db"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"strings"
+)
+
+func (f *FS) getxattr(ctx context.Context, inode db.Inode, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	ctx, cancel := f.opContext(ctx)
+	defer cancel()
+
+	log.Infof("Getxattr %s of %d", req.Name, inode)
+
+	if err := f.admit(req.Header); err != nil {
+		return err
+	}
+
+	if err := f.checkXattr(ctx, newCaller(req.Header), inode, req.Name, accessRead); err != nil {
+		return toFuseError(ctx, err)
+	}
+
+	var value []byte
+	var err error
+	if isACLXattr(req.Name) {
+		value, err = f.getACL(ctx, inode, req.Name)
+	} else {
+		value, err = f.RepositoryRegistry.GetXattrRepository().Find(ctx, inode, req.Name)
+	}
+	if err != nil {
+		return toFuseError(ctx, err)
+	}
+	resp.Xattr = value
+
+	return nil
+}
+
+func (f *FS) listxattr(ctx context.Context, inode db.Inode, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	ctx, cancel := f.opContext(ctx)
+	defer cancel()
+
+	log.Infof("Listxattr of %d", inode)
+
+	if err := f.admit(req.Header); err != nil {
+		return err
+	}
+
+	names, err := f.RepositoryRegistry.GetXattrRepository().FindNames(ctx, inode)
+	if err != nil {
+		log.Errorf("Error: %s", err.Error())
+		return toFuseError(ctx, err)
+	}
+	resp.Append(visibleXattrs(newCaller(req.Header), names)...)
+
+	return nil
+}
+
+func (f *FS) removexattr(ctx context.Context, inode db.Inode, req *fuse.RemovexattrRequest) error {
+	ctx, cancel := f.opContext(ctx)
+	defer cancel()
+
+	log.Infof("Removexattr %s of %d", req.Name, inode)
+
+	if err := f.checkChange(req.Header); err != nil {
+		return err
+	}
+
+	if err := f.checkXattr(ctx, newCaller(req.Header), inode, req.Name, accessWrite); err != nil {
+		return toFuseError(ctx, err)
+	}
+
+	if err := f.RepositoryRegistry.GetXattrRepository().Remove(ctx, inode, req.Name); err != nil {
+		return toFuseError(ctx, err)
+	}
+
+	return nil
+}
+
+func (f *FS) setxattr(ctx context.Context, inode db.Inode, req *fuse.SetxattrRequest) error {
+	ctx, cancel := f.opContext(ctx)
+	defer cancel()
+
+	log.Infof("Setxattr %s of %d", req.Name, inode)
+
+	if err := f.checkChange(req.Header); err != nil {
+		return err
+	}
+
+	if err := f.checkXattr(ctx, newCaller(req.Header), inode, req.Name, accessWrite); err != nil {
+		return toFuseError(ctx, err)
+	}
+
+	var err error
+	if isACLXattr(req.Name) {
+		err = f.setACL(ctx, inode, req.Name, req.Xattr, db.XattrSetFlags(req.Flags))
+	} else {
+		err = f.RepositoryRegistry.GetXattrRepository().Set(ctx, inode, req.Name, req.Xattr, db.XattrSetFlags(req.Flags))
+	}
+	if err != nil {
+		return toFuseError(ctx, err)
+	}
+
+	return nil
+}
+
+func isACLXattr(name string) bool {
+	return name == aclAccessXattr || name == aclDefaultXattr
+}
+
+// isUserXattr reports the attributes of the user namespace, which Linux allows only on regular
+// files and directories.
+func isUserXattr(name string) bool {
+	return strings.HasPrefix(name, "user.")
+}
+
+var _ = fuseFS.NodeGetxattrer(&Dir{})
+
+func (d *Dir) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return d.fs.getxattr(ctx, d.descriptor.GetInode(), req, resp)
+}
+
+var _ = fuseFS.NodeListxattrer(&Dir{})
+
+func (d *Dir) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	return d.fs.listxattr(ctx, d.descriptor.GetInode(), req, resp)
+}
+
+var _ = fuseFS.NodeRemovexattrer(&Dir{})
+
+func (d *Dir) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	return d.fs.removexattr(ctx, d.descriptor.GetInode(), req)
+}
+
+var _ = fuseFS.NodeSetxattrer(&Dir{})
+
+func (d *Dir) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	return d.fs.setxattr(ctx, d.descriptor.GetInode(), req)
+}
+
+var _ = fuseFS.NodeGetxattrer(&File{})
+
+func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return f.fs.getxattr(ctx, f.descriptor.GetInode(), req, resp)
+}
+
+var _ = fuseFS.NodeListxattrer(&File{})
+
+func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	return f.fs.listxattr(ctx, f.descriptor.GetInode(), req, resp)
+}
+
+var _ = fuseFS.NodeRemovexattrer(&File{})
+
+func (f *File) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	return f.fs.removexattr(ctx, f.descriptor.GetInode(), req)
+}
+
+var _ = fuseFS.NodeSetxattrer(&File{})
+
+func (f *File) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	return f.fs.setxattr(ctx, f.descriptor.GetInode(), req)
+}
+
+var _ = fuseFS.NodeGetxattrer(&Symlink{})
+
+func (s *Symlink) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	if isUserXattr(req.Name) {
+		return fuse.ErrNoXattr
+	}
+	return s.fs.getxattr(ctx, s.descriptor.GetInode(), req, resp)
+}
+
+var _ = fuseFS.NodeListxattrer(&Symlink{})
+
+func (s *Symlink) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	return s.fs.listxattr(ctx, s.descriptor.GetInode(), req, resp)
+}
+
+var _ = fuseFS.NodeRemovexattrer(&Symlink{})
+
+func (s *Symlink) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	if isUserXattr(req.Name) {
+		return fuse.EPERM
+	}
+	return s.fs.removexattr(ctx, s.descriptor.GetInode(), req)
+}
+
+var _ = fuseFS.NodeSetxattrer(&Symlink{})
+
+func (s *Symlink) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	if isUserXattr(req.Name) {
+		return fuse.EPERM
+	}
+	return s.fs.setxattr(ctx, s.descriptor.GetInode(), req)
+}
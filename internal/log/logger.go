@@ -18,6 +18,9 @@ package log
 
 import (
 	"github.com/sirupsen/logrus"
+	logrusSyslog "github.com/sirupsen/logrus/hooks/syslog"
+	"io/ioutil"
+	"log/syslog"
 	"os"
 )
 
@@ -28,3 +31,26 @@ func NewStdoutLogger() *logrus.Logger {
 
 	return logger
 }
+
+// RedirectToFile appends the messages of the standard logger to the file.
+func RedirectToFile(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	logrus.SetOutput(file)
+
+	return nil
+}
+
+// RedirectToSyslog sends the messages of the standard logger to the daemon facility of syslog.
+func RedirectToSyslog(tag string) error {
+	hook, err := logrusSyslog.NewSyslogHook("", "", syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return err
+	}
+	logrus.AddHook(hook)
+	logrus.SetOutput(ioutil.Discard)
+
+	return nil
+}
@@ -0,0 +1,54 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mysql
+
+import "github.com/kos-v/dbunderfs/internal/db/migration"
+
+func migration202610181000() *migration.Migration {
+	return migration.NewMigration(
+		"202610181000",
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`
+				ALTER TABLE {%prefix%}descriptors
+					ADD COLUMN atime  bigint(20) NOT NULL DEFAULT '0' AFTER gid,
+					ADD COLUMN mtime  bigint(20) NOT NULL DEFAULT '0' AFTER atime,
+					ADD COLUMN ctime  bigint(20) NOT NULL DEFAULT '0' AFTER mtime,
+					ADD COLUMN crtime bigint(20) NOT NULL DEFAULT '0' AFTER ctime`,
+			)
+
+			migration.QueryBag.AddQuery(`
+				UPDATE {%prefix%}descriptors
+				SET atime  = UNIX_TIMESTAMP() * 1000000000,
+					mtime  = UNIX_TIMESTAMP() * 1000000000,
+					ctime  = UNIX_TIMESTAMP() * 1000000000,
+					crtime = UNIX_TIMESTAMP() * 1000000000`,
+			)
+
+			return nil
+		},
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`
+				ALTER TABLE {%prefix%}descriptors
+					DROP COLUMN atime,
+					DROP COLUMN mtime,
+					DROP COLUMN ctime,
+					DROP COLUMN crtime`,
+			)
+
+			return nil
+		})
+}
@@ -0,0 +1,51 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mysql
+
+import "github.com/kos-v/dbunderfs/internal/db/migration"
+
+func migration202610181100() *migration.Migration {
+	return migration.NewMigration(
+		"202610181100",
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`
+				ALTER TABLE {%prefix%}descriptors
+					ADD COLUMN block_size int(11) unsigned NOT NULL DEFAULT '0' AFTER size`,
+			)
+
+			migration.QueryBag.AddQuery(`
+				CREATE TABLE {%prefix%}blocks (
+					inode bigint(20) unsigned NOT NULL,
+					idx   bigint(20) unsigned NOT NULL,
+					data  longblob            NOT NULL,
+					PRIMARY KEY (inode, idx),
+					CONSTRAINT ` + "`FK__{%prefix%}blocks-inode__{%prefix%}descriptors-inode`" + `
+						FOREIGN KEY (inode) REFERENCES {%prefix%}descriptors (inode)
+							ON UPDATE CASCADE ON DELETE CASCADE
+				) ENGINE = InnoDB
+				DEFAULT CHARSET = utf8`,
+			)
+
+			return nil
+		},
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`DROP TABLE {%prefix%}blocks`)
+			migration.QueryBag.AddQuery(`ALTER TABLE {%prefix%}descriptors DROP COLUMN block_size`)
+
+			return nil
+		})
+}
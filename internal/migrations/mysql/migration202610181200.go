@@ -0,0 +1,43 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mysql
+
+import "github.com/kos-v/dbunderfs/internal/db/migration"
+
+func migration202610181200() *migration.Migration {
+	return migration.NewMigration(
+		"202610181200",
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`
+				ALTER TABLE {%prefix%}descriptors
+					MODIFY COLUMN type enum ('DIR','FILE','SYMLINK') NOT NULL,
+					ADD COLUMN target varchar(4096) DEFAULT NULL AFTER crtime`,
+			)
+
+			return nil
+		},
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`DELETE FROM {%prefix%}descriptors WHERE type = 'SYMLINK'`)
+			migration.QueryBag.AddQuery(`
+				ALTER TABLE {%prefix%}descriptors
+					MODIFY COLUMN type enum ('DIR','FILE') NOT NULL,
+					DROP COLUMN target`,
+			)
+
+			return nil
+		})
+}
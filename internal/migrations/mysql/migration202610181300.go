@@ -0,0 +1,47 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mysql
+
+import "github.com/kos-v/dbunderfs/internal/db/migration"
+
+func migration202610181300() *migration.Migration {
+	return migration.NewMigration(
+		"202610181300",
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`
+				ALTER TABLE {%prefix%}descriptors
+					ADD COLUMN origin bigint(20) unsigned DEFAULT NULL AFTER parent,
+					ADD COLUMN nlink  int(11) unsigned    NOT NULL DEFAULT '1' AFTER gid,
+					ADD CONSTRAINT ` + "`FK__{%prefix%}descriptors-origin__{%prefix%}descriptors-inode`" + `
+						FOREIGN KEY (origin) REFERENCES {%prefix%}descriptors (inode)
+							ON UPDATE CASCADE ON DELETE CASCADE`,
+			)
+
+			return nil
+		},
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`DELETE FROM {%prefix%}descriptors WHERE origin IS NOT NULL`)
+			migration.QueryBag.AddQuery(`
+				ALTER TABLE {%prefix%}descriptors
+					DROP FOREIGN KEY ` + "`FK__{%prefix%}descriptors-origin__{%prefix%}descriptors-inode`" + `,
+					DROP COLUMN origin,
+					DROP COLUMN nlink`,
+			)
+
+			return nil
+		})
+}
@@ -0,0 +1,54 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mysql
+
+import "github.com/kos-v/dbunderfs/internal/db/migration"
+
+// migration202610181500 stops the removal of a directory from deleting its entries. Directories
+// are removed only when they are empty, the entries of a tree are removed one by one.
+func migration202610181500() *migration.Migration {
+	return migration.NewMigration(
+		"202610181500",
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`
+				ALTER TABLE {%prefix%}descriptors
+					DROP FOREIGN KEY ` + "`FK__{%prefix%}descriptors-parent__{%prefix%}descriptors-inode`",
+			)
+			migration.QueryBag.AddQuery(`
+				ALTER TABLE {%prefix%}descriptors
+					ADD CONSTRAINT ` + "`FK__{%prefix%}descriptors-parent__{%prefix%}descriptors-inode`" + `
+						FOREIGN KEY (parent) REFERENCES {%prefix%}descriptors (inode)
+							ON UPDATE CASCADE ON DELETE RESTRICT`,
+			)
+
+			return nil
+		},
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`
+				ALTER TABLE {%prefix%}descriptors
+					DROP FOREIGN KEY ` + "`FK__{%prefix%}descriptors-parent__{%prefix%}descriptors-inode`",
+			)
+			migration.QueryBag.AddQuery(`
+				ALTER TABLE {%prefix%}descriptors
+					ADD CONSTRAINT ` + "`FK__{%prefix%}descriptors-parent__{%prefix%}descriptors-inode`" + `
+						FOREIGN KEY (parent) REFERENCES {%prefix%}descriptors (inode)
+							ON UPDATE CASCADE ON DELETE CASCADE`,
+			)
+
+			return nil
+		})
+}
@@ -0,0 +1,47 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mysql
+
+import "github.com/kos-v/dbunderfs/internal/db/migration"
+
+// migration202610181600 stores the names and the symlink targets in utf8mb4 with the binary collation,
+// so names are case-sensitive and may contain any Unicode characters. Rolling back fails if
+// there are names that do not fit in utf8 or differ only in case within a directory.
+func migration202610181600() *migration.Migration {
+	return migration.NewMigration(
+		"202610181600",
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`
+				ALTER TABLE {%prefix%}descriptors
+					DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin,
+					MODIFY COLUMN name   varchar(255)  CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+					MODIFY COLUMN target varchar(4096) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin DEFAULT NULL`,
+			)
+
+			return nil
+		},
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`
+				ALTER TABLE {%prefix%}descriptors
+					DEFAULT CHARSET = utf8,
+					MODIFY COLUMN name   varchar(255)  CHARACTER SET utf8 NOT NULL,
+					MODIFY COLUMN target varchar(4096) CHARACTER SET utf8 DEFAULT NULL`,
+			)
+
+			return nil
+		})
+}
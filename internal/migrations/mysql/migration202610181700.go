@@ -0,0 +1,50 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mysql
+
+import "github.com/kos-v/dbunderfs/internal/db/migration"
+
+// migration202610181700 adds the folded_name column, which holds the key of the name the entries
+// are unique and looked up by: the case fold of the name on case-insensitive volumes and the name
+// itself otherwise. The existing names are copied, as new volumes are case-sensitive.
+func migration202610181700() *migration.Migration {
+	return migration.NewMigration(
+		"202610181700",
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`
+				ALTER TABLE {%prefix%}descriptors
+					ADD COLUMN folded_name varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL DEFAULT '' AFTER name`,
+			)
+			migration.QueryBag.AddQuery(`UPDATE {%prefix%}descriptors SET folded_name = name`)
+			migration.QueryBag.AddQuery(`
+				ALTER TABLE {%prefix%}descriptors
+					MODIFY COLUMN folded_name varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+					ADD UNIQUE INDEX ` + "`UNQ__{%prefix%}descriptors__parent-folded_name`" + ` (parent, folded_name)`,
+			)
+
+			return nil
+		},
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`
+				ALTER TABLE {%prefix%}descriptors
+					DROP INDEX ` + "`UNQ__{%prefix%}descriptors__parent-folded_name`" + `,
+					DROP COLUMN folded_name`,
+			)
+
+			return nil
+		})
+}
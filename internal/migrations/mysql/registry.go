@@ -21,5 +21,14 @@ import "github.com/kos-v/dbunderfs/internal/db/migration"
 func Migrations() []*migration.Migration {
 	return []*migration.Migration{
 		migration000000000000(),
+		migration202610181000(),
+		migration202610181100(),
+		migration202610181200(),
+		migration202610181300(),
+		migration202610181400(),
+		migration202610181500(),
+		migration202610181600(),
+		migration202610181700(),
+		migration202610181800(),
 	}
 }
@@ -0,0 +1,125 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package postgres
+
+import (
+	"fmt"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"github.com/kos-v/dbunderfs/internal/db/migration"
+	"os/user"
+)
+
+func migration000000000000() *migration.Migration {
+	return migration.NewMigration(
+		"000000000000",
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`
+				CREATE TABLE {%prefix%}migrations (
+					id          varchar(255) NOT NULL,
+					migrated_at bigint       NOT NULL,
+					PRIMARY KEY (id)
+				)`,
+			)
+
+			migration.QueryBag.AddQuery(`
+				CREATE TABLE {%prefix%}descriptors (
+					inode      bigserial     NOT NULL,
+					parent     bigint                 DEFAULT NULL,
+					origin     bigint                 DEFAULT NULL,
+					name       varchar(255)  NOT NULL,
+					type       varchar(7)    NOT NULL,
+					size       bigint        NOT NULL DEFAULT 0,
+					block_size integer       NOT NULL DEFAULT 0,
+					permission varchar(4)    NOT NULL,
+					uid        bigint        NOT NULL,
+					gid        bigint        NOT NULL,
+					nlink      bigint        NOT NULL DEFAULT 1,
+					atime      bigint        NOT NULL DEFAULT 0,
+					mtime      bigint        NOT NULL DEFAULT 0,
+					ctime      bigint        NOT NULL DEFAULT 0,
+					crtime     bigint        NOT NULL DEFAULT 0,
+					target     varchar(4096)          DEFAULT NULL,
+					fast_block bytea,
+					PRIMARY KEY (inode),
+					CONSTRAINT "CHK__{%prefix%}descriptors__type" CHECK (type IN ('DIR', 'FILE', 'SYMLINK')),
+					CONSTRAINT "UNQ__{%prefix%}descriptors__parent-name" UNIQUE (parent, name),
+					CONSTRAINT "FK__{%prefix%}descriptors-parent__{%prefix%}descriptors-inode"
+						FOREIGN KEY (parent) REFERENCES {%prefix%}descriptors (inode)
+							ON UPDATE CASCADE ON DELETE CASCADE,
+					CONSTRAINT "FK__{%prefix%}descriptors-origin__{%prefix%}descriptors-inode"
+						FOREIGN KEY (origin) REFERENCES {%prefix%}descriptors (inode)
+							ON UPDATE CASCADE ON DELETE CASCADE
+				)`,
+			)
+
+			migration.QueryBag.AddQuery(`
+				CREATE TABLE {%prefix%}blocks (
+					inode bigint NOT NULL,
+					idx   bigint NOT NULL,
+					data  bytea  NOT NULL,
+					PRIMARY KEY (inode, idx),
+					CONSTRAINT "FK__{%prefix%}blocks-inode__{%prefix%}descriptors-inode"
+						FOREIGN KEY (inode) REFERENCES {%prefix%}descriptors (inode)
+							ON UPDATE CASCADE ON DELETE CASCADE
+				)`,
+			)
+
+			migration.QueryBag.AddQuery(`
+				CREATE TABLE {%prefix%}xattrs (
+					inode bigint       NOT NULL,
+					name  varchar(255) NOT NULL,
+					value bytea        NOT NULL,
+					PRIMARY KEY (inode, name),
+					CONSTRAINT "FK__{%prefix%}xattrs-inode__{%prefix%}descriptors-inode"
+						FOREIGN KEY (inode) REFERENCES {%prefix%}descriptors (inode)
+							ON UPDATE CASCADE ON DELETE CASCADE
+				)`,
+			)
+
+			currentUser, err := user.Current()
+			if err != nil {
+				return err
+			}
+
+			migration.QueryBag.AddQuery(fmt.Sprintf(
+				`INSERT INTO {%%prefix%%}descriptors (parent, name, type, size, permission, uid, gid, atime, mtime, ctime, crtime)
+				VALUES (NULL, '%s', '%s', 0, '%s', %s, %s, 0, 0, 0, 0)`,
+				db.RootName,
+				string(db.DT_Dir),
+				"0775",
+				currentUser.Uid,
+				currentUser.Gid,
+			))
+			migration.QueryBag.AddQuery(`
+				UPDATE {%prefix%}descriptors
+				SET atime  = CAST(EXTRACT(EPOCH FROM NOW()) * 1000000000 AS bigint),
+					mtime  = CAST(EXTRACT(EPOCH FROM NOW()) * 1000000000 AS bigint),
+					ctime  = CAST(EXTRACT(EPOCH FROM NOW()) * 1000000000 AS bigint),
+					crtime = CAST(EXTRACT(EPOCH FROM NOW()) * 1000000000 AS bigint)`,
+			)
+
+			return nil
+		},
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`DROP TABLE {%prefix%}xattrs`)
+			migration.QueryBag.AddQuery(`DROP TABLE {%prefix%}blocks`)
+			migration.QueryBag.AddQuery(`DROP TABLE {%prefix%}descriptors`)
+			migration.QueryBag.AddQuery(`DROP TABLE {%prefix%}migrations`)
+
+			return nil
+		})
+}
@@ -14,18 +14,15 @@
    limitations under the License.
 */
 
-package mysql
+package postgres
 
-import "github.com/kos-v/dbunderfs/internal/db"
+import "github.com/kos-v/dbunderfs/internal/db/migration"
 
-type RepositoryRegistry struct {
-	Instance db.Instance
-}
-
-func (f *RepositoryRegistry) GetDataBlockRepository() db.DataBlockRepository {
-	return &DataBlockRepository{instance: f.Instance}
-}
-
-func (f *RepositoryRegistry) GetDescriptorRepository() db.DescriptorRepository {
-	return &DescriptorRepository{instance: f.Instance}
+func Migrations() []*migration.Migration {
+	return []*migration.Migration{
+		migration000000000000(),
+		migration202610181500(),
+		migration202610181700(),
+		migration202610181800(),
+	}
 }
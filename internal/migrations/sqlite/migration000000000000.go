@@ -0,0 +1,114 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sqlite
+
+import (
+	"fmt"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"github.com/kos-v/dbunderfs/internal/db/migration"
+	"os/user"
+)
+
+func migration000000000000() *migration.Migration {
+	return migration.NewMigration(
+		"000000000000",
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`
+				CREATE TABLE {%prefix%}migrations (
+					id          TEXT    NOT NULL PRIMARY KEY,
+					migrated_at INTEGER NOT NULL
+				)`,
+			)
+
+			migration.QueryBag.AddQuery(`
+				CREATE TABLE {%prefix%}descriptors (
+					inode      INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+					parent     INTEGER          DEFAULT NULL
+						REFERENCES {%prefix%}descriptors (inode) ON UPDATE CASCADE ON DELETE CASCADE,
+					origin     INTEGER          DEFAULT NULL
+						REFERENCES {%prefix%}descriptors (inode) ON UPDATE CASCADE ON DELETE CASCADE,
+					name       TEXT    NOT NULL,
+					type       TEXT    NOT NULL CHECK (type IN ('DIR', 'FILE', 'SYMLINK')),
+					size       INTEGER NOT NULL DEFAULT 0,
+					block_size INTEGER NOT NULL DEFAULT 0,
+					permission TEXT    NOT NULL,
+					uid        INTEGER NOT NULL,
+					gid        INTEGER NOT NULL,
+					nlink      INTEGER NOT NULL DEFAULT 1,
+					atime      INTEGER NOT NULL DEFAULT 0,
+					mtime      INTEGER NOT NULL DEFAULT 0,
+					ctime      INTEGER NOT NULL DEFAULT 0,
+					crtime     INTEGER NOT NULL DEFAULT 0,
+					target     TEXT             DEFAULT NULL,
+					fast_block BLOB,
+					UNIQUE (parent, name)
+				)`,
+			)
+
+			migration.QueryBag.AddQuery(`
+				CREATE TABLE {%prefix%}blocks (
+					inode INTEGER NOT NULL
+						REFERENCES {%prefix%}descriptors (inode) ON UPDATE CASCADE ON DELETE CASCADE,
+					idx   INTEGER NOT NULL,
+					data  BLOB    NOT NULL,
+					PRIMARY KEY (inode, idx)
+				)`,
+			)
+
+			migration.QueryBag.AddQuery(`
+				CREATE TABLE {%prefix%}xattrs (
+					inode INTEGER NOT NULL
+						REFERENCES {%prefix%}descriptors (inode) ON UPDATE CASCADE ON DELETE CASCADE,
+					name  TEXT    NOT NULL,
+					value BLOB    NOT NULL,
+					PRIMARY KEY (inode, name)
+				)`,
+			)
+
+			currentUser, err := user.Current()
+			if err != nil {
+				return err
+			}
+
+			migration.QueryBag.AddQuery(fmt.Sprintf(
+				`INSERT INTO {%%prefix%%}descriptors (parent, name, type, size, permission, uid, gid, atime, mtime, ctime, crtime)
+				VALUES (NULL, '%s', '%s', 0, '%s', %s, %s, 0, 0, 0, 0)`,
+				db.RootName,
+				string(db.DT_Dir),
+				"0775",
+				currentUser.Uid,
+				currentUser.Gid,
+			))
+			migration.QueryBag.AddQuery(`
+				UPDATE {%prefix%}descriptors
+				SET atime  = CAST(strftime('%s', 'now') AS INTEGER) * 1000000000,
+					mtime  = CAST(strftime('%s', 'now') AS INTEGER) * 1000000000,
+					ctime  = CAST(strftime('%s', 'now') AS INTEGER) * 1000000000,
+					crtime = CAST(strftime('%s', 'now') AS INTEGER) * 1000000000`,
+			)
+
+			return nil
+		},
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`DROP TABLE {%prefix%}xattrs`)
+			migration.QueryBag.AddQuery(`DROP TABLE {%prefix%}blocks`)
+			migration.QueryBag.AddQuery(`DROP TABLE {%prefix%}descriptors`)
+			migration.QueryBag.AddQuery(`DROP TABLE {%prefix%}migrations`)
+
+			return nil
+		})
+}
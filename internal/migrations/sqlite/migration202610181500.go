@@ -0,0 +1,44 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sqlite
+
+import "github.com/kos-v/dbunderfs/internal/db/migration"
+
+// migration202610181500 stops the removal of a directory from deleting its entries. SQLite cannot
+// change the action of a foreign key without rebuilding the table, so the deletion of a directory
+// with entries is aborted by a trigger that runs before the cascade.
+func migration202610181500() *migration.Migration {
+	return migration.NewMigration(
+		"202610181500",
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`
+				CREATE TRIGGER "TRG__{%prefix%}descriptors__restrict-parent"
+					BEFORE DELETE ON {%prefix%}descriptors
+					WHEN EXISTS (SELECT 1 FROM {%prefix%}descriptors WHERE parent = OLD.inode)
+				BEGIN
+					SELECT RAISE(ABORT, 'directory is not empty');
+				END`,
+			)
+
+			return nil
+		},
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`DROP TRIGGER "TRG__{%prefix%}descriptors__restrict-parent"`)
+
+			return nil
+		})
+}
@@ -0,0 +1,40 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sqlite
+
+import "github.com/kos-v/dbunderfs/internal/db/migration"
+
+// migration202610181800 adds the table of the volume settings.
+func migration202610181800() *migration.Migration {
+	return migration.NewMigration(
+		"202610181800",
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`
+				CREATE TABLE {%prefix%}settings (
+					name  TEXT NOT NULL PRIMARY KEY,
+					value TEXT NOT NULL
+				)`,
+			)
+
+			return nil
+		},
+		func(migration *migration.Migration) error {
+			migration.QueryBag.AddQuery(`DROP TABLE {%prefix%}settings`)
+
+			return nil
+		})
+}
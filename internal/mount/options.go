@@ -0,0 +1,105 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mount
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"strings"
+)
+
+// ignoredOptions are the options mount(8) passes to the helpers that have no meaning for dbfs.
+var ignoredOptions = map[string]bool{
+	"defaults": true,
+	"auto":     true,
+	"noauto":   true,
+	"user":     true,
+	"nouser":   true,
+	"users":    true,
+	"owner":    true,
+	"group":    true,
+	"nofail":   true,
+	"_netdev":  true,
+	"async":    true,
+	"sync":     true,
+	"dev":      true,
+	"nodev":    true,
+	"suid":     true,
+	"nosuid":   true,
+	"exec":     true,
+	"noexec":   true,
+}
+
+// atimeOptions are the atime options of mount(8) and the matching values of the atime flag.
+var atimeOptions = map[string]string{
+	"strictatime": "strict",
+	"relatime":    "relatime",
+	"noatime":     "noatime",
+}
+
+// ApplyOptions sets the flags of the mount command from the options in the format of mount(8):
+// comma separated flag names with underscores or dashes and optional values, e.g.
+// "ro,allow_other,uid=1000,cache_ttl=5s". Unknown options fail the mount unless sloppy is set.
+func ApplyOptions(cmd *cobra.Command, options []string, sloppy bool) error {
+	for _, list := range options {
+		for _, option := range strings.Split(list, ",") {
+			if err := applyOption(cmd, option, sloppy); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func applyOption(cmd *cobra.Command, option string, sloppy bool) error {
+	name, value := option, ""
+	hasValue := false
+	if i := strings.IndexByte(option, '='); i >= 0 {
+		name, value, hasValue = option[:i], option[i+1:], true
+	}
+
+	switch {
+	case name == "" || ignoredOptions[name] || strings.HasPrefix(name, "x-"):
+		return nil
+	case name == "ro" || name == "rw":
+		name, value, hasValue = "read-only", fmt.Sprint(name == "ro"), true
+	case atimeOptions[name] != "" && !hasValue:
+		name, value, hasValue = "atime", atimeOptions[name], true
+	}
+
+	flagName := strings.Replace(name, "_", "-", -1)
+	flag := cmd.Flags().Lookup(flagName)
+	if flag == nil || flag.Hidden || flagName == "options" || flagName == "help" {
+		if sloppy {
+			return nil
+		}
+		return fmt.Errorf("unknown mount option %q", name)
+	}
+
+	if !hasValue {
+		if flag.Value.Type() != "bool" {
+			return fmt.Errorf("mount option %q requires a value", name)
+		}
+		value = "true"
+	}
+	if err := cmd.Flags().Set(flagName, value); err != nil {
+		return fmt.Errorf("invalid value %q of mount option %q: %s", value, name, err)
+	}
+
+	return nil
+}
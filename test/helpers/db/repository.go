@@ -0,0 +1,779 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// CheckDataBlockRepository writes, truncates and reads a file with the block size of 4 bytes.
+func CheckDataBlockRepository(t *testing.T, registry db.RepositoryRegistry) {
+	ctx := context.Background()
+	descrRepo := registry.GetDescriptorRepository()
+	blockRepo := registry.GetDataBlockRepository()
+
+	root, err := descrRepo.FindRoot(ctx)
+	if err != nil || root == nil {
+		t.Fatalf("FindRoot fail: %v", err)
+	}
+	file, err := descrRepo.Create(ctx, root.GetInode(), "file", db.DT_File, db.DescriptorAttrs{Permission: "0644"})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+
+	tests := []struct {
+		offset       uint64
+		data         []byte
+		truncate     int64
+		appending    bool
+		expectedData []byte
+	}{
+		{0, []byte{1, 2}, -1, false, []byte{1, 2}},
+		{3, []byte{4, 5, 6, 7, 8}, -1, false, []byte{1, 2, 0, 4, 5, 6, 7, 8}},
+		{1, []byte{9}, -1, false, []byte{1, 9, 0, 4, 5, 6, 7, 8}},
+		{0, nil, 5, false, []byte{1, 9, 0, 4, 5}},
+		{0, nil, 7, false, []byte{1, 9, 0, 4, 5, 0, 0}},
+		{0, []byte{3, 3}, -1, true, []byte{1, 9, 0, 4, 5, 0, 0, 3, 3}},
+	}
+
+	for id, test := range tests {
+		id += 1
+		if test.truncate >= 0 {
+			err = blockRepo.Truncate(ctx, file, uint64(test.truncate))
+		} else if test.appending {
+			_, err = blockRepo.Append(ctx, file, test.data)
+		} else {
+			_, err = blockRepo.Write(ctx, file, test.offset, test.data)
+		}
+		if err != nil {
+			t.Fatalf("Test %v fail: %s", id, err)
+		}
+
+		data, err := blockRepo.Read(ctx, file, 0, 100)
+		if err != nil {
+			t.Fatalf("Test %v fail: %s", id, err)
+		}
+		if !bytes.Equal(data, test.expectedData) {
+			t.Errorf("Test %v fail: result data is not as expected.\nExpected: %v. Result: %v.\n", id, test.expectedData, data)
+		}
+	}
+}
+
+// CheckDescriptorRepository builds a small tree with a symlink and a hard link, renames and removes entries.
+func CheckDescriptorRepository(t *testing.T, registry db.RepositoryRegistry) {
+	ctx := context.Background()
+	repo := registry.GetDescriptorRepository()
+
+	root, _ := repo.FindRoot(ctx)
+	dir, err := repo.Create(ctx, root.GetInode(), "dir", db.DT_Dir, db.DescriptorAttrs{Permission: "0755"})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	file, err := repo.Create(ctx, dir.GetInode(), "file", db.DT_File, db.DescriptorAttrs{Permission: "0644"})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	if _, err = repo.CreateSymlink(ctx, root.GetInode(), "link", "dir/file", db.DescriptorAttrs{Permission: "0777"}); err != nil {
+		t.Fatalf("CreateSymlink fail: %s", err)
+	}
+	if _, err = repo.Link(ctx, file.GetInode(), root.GetInode(), "hard"); err != nil {
+		t.Fatalf("Link fail: %s", err)
+	}
+	if err = repo.Rename(ctx, dir.GetInode(), "file", root.GetInode(), "moved"); err != nil {
+		t.Fatalf("Rename fail: %s", err)
+	}
+
+	children, err := repo.FindChildrenByInode(ctx, root.GetInode())
+	if err != nil {
+		t.Fatalf("FindChildrenByInode fail: %s", err)
+	}
+	names := []string{}
+	for _, child := range children.ToList() {
+		names = append(names, child.(db.DescriptorInterface).GetName())
+	}
+	expectedNames := []string{"dir", "hard", "moved", "link"}
+	if len(names) != len(expectedNames) {
+		t.Fatalf("FindChildrenByInode fail.\nExpected: %v. Result: %v.\n", expectedNames, names)
+	}
+	for i := range names {
+		if names[i] != expectedNames[i] {
+			t.Errorf("FindChildrenByInode fail.\nExpected: %v. Result: %v.\n", expectedNames, names)
+		}
+	}
+
+	if err = repo.RemoveByName(ctx, root.GetInode(), "moved", false); err != nil {
+		t.Fatalf("RemoveByName fail: %s", err)
+	}
+	hard, err := repo.FindSingleByName(ctx, root.GetInode(), "hard")
+	if err != nil || hard == nil {
+		t.Fatalf("FindSingleByName fail: %v", err)
+	}
+	if hard.GetInode() != file.GetInode() || hard.GetNlink() != 1 {
+		t.Errorf("Hard link is not as expected.\nExpected: %v, 1. Result: %v, %v.\n", file.GetInode(), hard.GetInode(), hard.GetNlink())
+	}
+
+	checkDescriptorRepositoryErrors(t, registry)
+}
+
+// checkDescriptorRepositoryErrors checks the typed errors of the operations failed on names.
+func checkDescriptorRepositoryErrors(t *testing.T, registry db.RepositoryRegistry) {
+	ctx := context.Background()
+	repo := registry.GetDescriptorRepository()
+
+	root, _ := repo.FindRoot(ctx)
+	file, err := repo.Create(ctx, root.GetInode(), "taken", db.DT_File, db.DescriptorAttrs{Permission: "0644"})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	full, err := repo.Create(ctx, root.GetInode(), "full", db.DT_Dir, db.DescriptorAttrs{Permission: "0755"})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	if _, err = repo.Create(ctx, full.GetInode(), "child", db.DT_File, db.DescriptorAttrs{Permission: "0644"}); err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	longName := strings.Repeat("n", db.MaxNameLength+1)
+
+	var (
+		existsErr      *db.ExistsError
+		nameTooLongErr *db.NameTooLongError
+		notFoundErr    *db.NotFoundError
+		notEmptyErr    *db.NotEmptyError
+		isDirErr       *db.IsDirError
+		notDirErr      *db.NotDirError
+	)
+	tests := []struct {
+		op       func() error
+		expected interface{}
+	}{
+		{func() error {
+			_, err := repo.Create(ctx, root.GetInode(), "taken", db.DT_Dir, db.DescriptorAttrs{Permission: "0755"})
+			return err
+		}, &existsErr},
+		{func() error {
+			_, err := repo.CreateSymlink(ctx, root.GetInode(), "taken", "target", db.DescriptorAttrs{Permission: "0777"})
+			return err
+		}, &existsErr},
+		{func() error {
+			_, err := repo.Link(ctx, file.GetInode(), root.GetInode(), "taken")
+			return err
+		}, &existsErr},
+		{func() error {
+			_, err := repo.Create(ctx, root.GetInode(), longName, db.DT_File, db.DescriptorAttrs{Permission: "0644"})
+			return err
+		}, &nameTooLongErr},
+		{func() error {
+			return repo.Rename(ctx, root.GetInode(), "taken", root.GetInode(), longName)
+		}, &nameTooLongErr},
+		{func() error {
+			return repo.RemoveByName(ctx, root.GetInode(), "missing", false)
+		}, &notFoundErr},
+		{func() error {
+			return repo.RemoveByName(ctx, root.GetInode(), "full", true)
+		}, &notEmptyErr},
+		{func() error {
+			return repo.RemoveByName(ctx, root.GetInode(), "full", false)
+		}, &isDirErr},
+		{func() error {
+			return repo.RemoveByName(ctx, root.GetInode(), "taken", true)
+		}, &notDirErr},
+	}
+
+	for id, test := range tests {
+		id += 1
+		if err := test.op(); !errors.As(err, test.expected) {
+			t.Errorf("Test %v fail: error is not as expected.\nExpected: %T. Result: %v.\n", id, test.expected, err)
+		}
+	}
+
+	if err = repo.RemoveByName(ctx, full.GetInode(), "child", false); err != nil {
+		t.Fatalf("RemoveByName fail: %s", err)
+	}
+	if err = repo.RemoveByName(ctx, root.GetInode(), "full", true); err != nil {
+		t.Errorf("RemoveByName of empty directory fail: %s", err)
+	}
+}
+
+// CheckRename moves entries between directories, replaces existing targets and checks the refused renames.
+func CheckRename(t *testing.T, registry db.RepositoryRegistry) {
+	ctx := context.Background()
+	repo := registry.GetDescriptorRepository()
+
+	root, _ := repo.FindRoot(ctx)
+	create := func(parent db.Inode, name string, dType db.DescriptorType) db.DescriptorInterface {
+		descr, err := repo.Create(ctx, parent, name, dType, db.DescriptorAttrs{Permission: "0755"})
+		if err != nil {
+			t.Fatalf("Create of %s fail: %s", name, err)
+		}
+		return descr
+	}
+	src := create(root.GetInode(), "src", db.DT_Dir)
+	dst := create(root.GetInode(), "dst", db.DT_Dir)
+	sub := create(src.GetInode(), "sub", db.DT_Dir)
+	file := create(src.GetInode(), "file", db.DT_File)
+	old := create(dst.GetInode(), "old", db.DT_File)
+	full := create(dst.GetInode(), "full", db.DT_Dir)
+	create(full.GetInode(), "child", db.DT_File)
+	empty := create(dst.GetInode(), "empty", db.DT_Dir)
+
+	if err := repo.Rename(ctx, src.GetInode(), "file", dst.GetInode(), "old"); err != nil {
+		t.Fatalf("Rename to another directory over an existing file fail: %s", err)
+	}
+	moved, err := repo.FindSingleByName(ctx, dst.GetInode(), "old")
+	if err != nil || moved == nil || moved.GetInode() != file.GetInode() {
+		t.Errorf("Replaced target is not as expected.\nExpected: %v. Result: %v, %v.\n", file.GetInode(), moved, err)
+	}
+	if descr, _ := repo.FindSingleByName(ctx, src.GetInode(), "file"); descr != nil {
+		t.Errorf("Source entry after rename is not as expected.\nExpected: %v. Result: %v.\n", nil, descr.GetInode())
+	}
+	if descr, _ := repo.FindSingleByInode(ctx, old.GetInode()); descr != nil {
+		t.Errorf("Replaced descriptor is not as expected.\nExpected: %v. Result: %v.\n", nil, descr.GetInode())
+	}
+
+	if err = repo.Rename(ctx, src.GetInode(), "sub", dst.GetInode(), "empty"); err != nil {
+		t.Fatalf("Rename over an empty directory fail: %s", err)
+	}
+	moved, _ = repo.FindSingleByName(ctx, dst.GetInode(), "empty")
+	if moved == nil || moved.GetInode() != sub.GetInode() || moved.GetParent() != dst.GetInode() {
+		t.Errorf("Moved directory is not as expected.\nExpected: %v in %v. Result: %v.\n", sub.GetInode(), dst.GetInode(), moved)
+	}
+	if descr, _ := repo.FindSingleByInode(ctx, empty.GetInode()); descr != nil {
+		t.Errorf("Replaced directory is not as expected.\nExpected: %v. Result: %v.\n", nil, descr.GetInode())
+	}
+
+	var (
+		subtreeMoveErr *db.SubtreeMoveError
+		notEmptyErr    *db.NotEmptyError
+		isDirErr       *db.IsDirError
+		notDirErr      *db.NotDirError
+		notFoundErr    *db.NotFoundError
+	)
+	tests := []struct {
+		oldParent db.Inode
+		oldName   string
+		newParent db.Inode
+		newName   string
+		expected  interface{}
+	}{
+		{root.GetInode(), "dst", dst.GetInode(), "inner", &subtreeMoveErr},
+		{root.GetInode(), "dst", sub.GetInode(), "inner", &subtreeMoveErr},
+		{root.GetInode(), "src", dst.GetInode(), "full", &notEmptyErr},
+		{dst.GetInode(), "old", dst.GetInode(), "full", &isDirErr},
+		{dst.GetInode(), "full", dst.GetInode(), "old", &notDirErr},
+		{src.GetInode(), "missing", dst.GetInode(), "new", &notFoundErr},
+	}
+
+	for id, test := range tests {
+		id += 1
+		err := repo.Rename(ctx, test.oldParent, test.oldName, test.newParent, test.newName)
+		if !errors.As(err, test.expected) {
+			t.Errorf("Test %v fail: error is not as expected.\nExpected: %T. Result: %v.\n", id, test.expected, err)
+		}
+	}
+
+	if descr, _ := repo.FindSingleByName(ctx, full.GetInode(), "child"); descr == nil {
+		t.Errorf("Entry of the refused target is not as expected.\nExpected: found. Result: %v.\n", descr)
+	}
+	if descr, _ := repo.FindSingleByName(ctx, root.GetInode(), "dst"); descr == nil || descr.GetInode() != dst.GetInode() {
+		t.Errorf("Directory refused to move into its subtree is not as expected.\nExpected: %v. Result: %v.\n", dst.GetInode(), descr)
+	}
+}
+
+// CheckLink links a file into two directories and removes the entries one by one, the entry of the origin first.
+func CheckLink(t *testing.T, registry db.RepositoryRegistry) {
+	ctx := context.Background()
+	repo := registry.GetDescriptorRepository()
+
+	root, _ := repo.FindRoot(ctx)
+	dir, err := repo.Create(ctx, root.GetInode(), "dir", db.DT_Dir, db.DescriptorAttrs{Permission: "0755"})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	file, err := repo.Create(ctx, root.GetInode(), "file", db.DT_File, db.DescriptorAttrs{Permission: "0644"})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	if _, err = registry.GetDataBlockRepository().Write(ctx, file, 0, []byte("data")); err != nil {
+		t.Fatalf("Write fail: %s", err)
+	}
+	if _, err = repo.Link(ctx, file.GetInode(), dir.GetInode(), "first"); err != nil {
+		t.Fatalf("Link fail: %s", err)
+	}
+	if _, err = repo.Link(ctx, file.GetInode(), dir.GetInode(), "second"); err != nil {
+		t.Fatalf("Link fail: %s", err)
+	}
+
+	type entry struct {
+		parent db.Inode
+		name   string
+	}
+	tests := []struct {
+		remove        *entry
+		entries       []entry
+		expectedNlink uint32
+	}{
+		{nil, []entry{{root.GetInode(), "file"}, {dir.GetInode(), "first"}, {dir.GetInode(), "second"}}, 3},
+		{&entry{root.GetInode(), "file"}, []entry{{dir.GetInode(), "first"}, {dir.GetInode(), "second"}}, 2},
+		{&entry{dir.GetInode(), "first"}, []entry{{dir.GetInode(), "second"}}, 1},
+	}
+
+	for id, test := range tests {
+		id += 1
+		if test.remove != nil {
+			if err := repo.RemoveByName(ctx, test.remove.parent, test.remove.name, false); err != nil {
+				t.Fatalf("Test %v fail: %s", id, err)
+			}
+		}
+
+		for _, e := range test.entries {
+			descr, err := repo.FindSingleByName(ctx, e.parent, e.name)
+			if err != nil || descr == nil {
+				t.Fatalf("Test %v fail: entry %s is not found: %v", id, e.name, err)
+			}
+			if descr.GetInode() != file.GetInode() || descr.GetNlink() != test.expectedNlink {
+				t.Errorf("Test %v fail: entry %s is not as expected.\nExpected: %v, %v. Result: %v, %v.\n", id, e.name, file.GetInode(), test.expectedNlink, descr.GetInode(), descr.GetNlink())
+			}
+			data, err := registry.GetDataBlockRepository().Read(ctx, descr, 0, 100)
+			if err != nil || string(data) != "data" {
+				t.Errorf("Test %v fail: data of entry %s is not as expected.\nExpected: %q. Result: %q, %v.\n", id, e.name, "data", data, err)
+			}
+		}
+	}
+
+	descr, err := repo.FindSingleByInode(ctx, file.GetInode())
+	if err != nil || descr == nil || descr.GetParent() != dir.GetInode() || descr.GetName() != "second" {
+		t.Errorf("Descriptor after the origin takeover is not as expected.\nExpected: second in %v. Result: %v, %v.\n", dir.GetInode(), descr, err)
+	}
+	if err = repo.RemoveByName(ctx, dir.GetInode(), "second", false); err != nil {
+		t.Fatalf("RemoveByName fail: %s", err)
+	}
+	if descr, _ = repo.FindSingleByInode(ctx, file.GetInode()); descr != nil {
+		t.Errorf("Descriptor after removing the last link is not as expected.\nExpected: %v. Result: %v.\n", nil, descr.GetInode())
+	}
+
+	var (
+		isDirErr    *db.IsDirError
+		notFoundErr *db.NotFoundError
+	)
+	if _, err = repo.Link(ctx, dir.GetInode(), root.GetInode(), "dirlink"); !errors.As(err, &isDirErr) {
+		t.Errorf("Link of a directory error is not as expected.\nExpected: %T. Result: %v.\n", isDirErr, err)
+	}
+	if _, err = repo.Link(ctx, file.GetInode(), root.GetInode(), "removed"); !errors.As(err, &notFoundErr) {
+		t.Errorf("Link of a removed file error is not as expected.\nExpected: %T. Result: %v.\n", notFoundErr, err)
+	}
+}
+
+// CheckSymlink creates symlinks with relative, absolute, non-ASCII and long targets and links one of them.
+func CheckSymlink(t *testing.T, registry db.RepositoryRegistry) {
+	ctx := context.Background()
+	repo := registry.GetDescriptorRepository()
+
+	root, _ := repo.FindRoot(ctx)
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"relative", "../dir/file"},
+		{"absolute", "/usr/lib/node_modules/.bin/tsc"},
+		{"unicode", "каталог/файл"},
+		{"long", strings.Repeat("d/", 2047) + "f"},
+		{"dangling", "missing"},
+	}
+
+	for id, test := range tests {
+		id += 1
+		created, err := repo.CreateSymlink(ctx, root.GetInode(), test.name, test.target, db.DescriptorAttrs{Permission: "0777", Size: uint64(len(test.target))})
+		if err != nil {
+			t.Fatalf("Test %v fail: %s", id, err)
+		}
+
+		descr, err := repo.FindSingleByName(ctx, root.GetInode(), test.name)
+		if err != nil || descr == nil {
+			t.Fatalf("Test %v fail: symlink is not found: %v", id, err)
+		}
+		if descr.GetInode() != created.GetInode() || descr.GetType() != db.DT_Symlink {
+			t.Errorf("Test %v fail: symlink is not as expected.\nExpected: %v, %v. Result: %v, %v.\n", id, created.GetInode(), db.DT_Symlink, descr.GetInode(), descr.GetType())
+		}
+		if descr.GetTarget() != test.target || descr.GetSize() != uint64(len(test.target)) {
+			t.Errorf("Test %v fail: target is not as expected.\nExpected: %q, %v. Result: %q, %v.\n", id, test.target, len(test.target), descr.GetTarget(), descr.GetSize())
+		}
+	}
+
+	if _, err := repo.Link(ctx, mustFind(t, repo, root.GetInode(), "relative").GetInode(), root.GetInode(), "hard"); err != nil {
+		t.Fatalf("Link fail: %s", err)
+	}
+	if err := repo.RemoveByName(ctx, root.GetInode(), "relative", false); err != nil {
+		t.Fatalf("RemoveByName fail: %s", err)
+	}
+	hard := mustFind(t, repo, root.GetInode(), "hard")
+	if hard.GetType() != db.DT_Symlink || hard.GetTarget() != "../dir/file" {
+		t.Errorf("Link of the symlink is not as expected.\nExpected: %v, %q. Result: %v, %q.\n", db.DT_Symlink, "../dir/file", hard.GetType(), hard.GetTarget())
+	}
+}
+
+func mustFind(t *testing.T, repo db.DescriptorRepository, parent db.Inode, name string) db.DescriptorInterface {
+	descr, err := repo.FindSingleByName(context.Background(), parent, name)
+	if err != nil || descr == nil {
+		t.Fatalf("FindSingleByName of %s fail: %v", name, err)
+	}
+
+	return descr
+}
+
+// CheckTimestamps checks the times set by the creation, the attribute update, the write, the rename
+// and the strict and relative access time updates of a file.
+func CheckTimestamps(t *testing.T, registry db.RepositoryRegistry) {
+	ctx := context.Background()
+	repo := registry.GetDescriptorRepository()
+	old := time.Unix(1000, 0)
+	future := time.Now().Add(time.Hour)
+
+	root, _ := repo.FindRoot(ctx)
+	dir, err := repo.Create(ctx, root.GetInode(), "dir", db.DT_Dir, db.DescriptorAttrs{Permission: "0755"})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	created := time.Now()
+	file, err := repo.Create(ctx, root.GetInode(), "file", db.DT_File, db.DescriptorAttrs{Permission: "0644"})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	crtime := file.GetCrtime()
+
+	// updated marks the times expected to be set to the current time by the operation. The times set
+	// explicitly are expected as is and the others are expected to be kept.
+	const (
+		atime = 1 << iota
+		mtime
+		ctime
+	)
+	tests := []struct {
+		op            func() error
+		updated       int
+		expectedAtime time.Time
+		expectedMtime time.Time
+	}{
+		{func() error { return nil }, atime | mtime | ctime, time.Time{}, time.Time{}},
+		{func() error {
+			return repo.UpdateAttrs(ctx, file.GetInode(), db.DescriptorAttrs{Permission: "0600", Atime: old, Mtime: old})
+		}, ctime, old, old},
+		{func() error {
+			_, err := registry.GetDataBlockRepository().Write(ctx, file, 0, []byte("data"))
+			return err
+		}, mtime | ctime, time.Time{}, time.Time{}},
+		{func() error {
+			return registry.GetDataBlockRepository().Truncate(ctx, file, 2)
+		}, mtime | ctime, time.Time{}, time.Time{}},
+		{func() error {
+			return repo.UpdateAttrs(ctx, file.GetInode(), db.DescriptorAttrs{Permission: "0600", Atime: future, Mtime: old})
+		}, ctime, future, old},
+		{func() error {
+			return repo.UpdateAtime(ctx, file.GetInode(), true)
+		}, 0, time.Time{}, time.Time{}},
+		{func() error {
+			return repo.UpdateAtime(ctx, file.GetInode(), false)
+		}, atime, time.Time{}, time.Time{}},
+		{func() error {
+			return repo.UpdateAttrs(ctx, file.GetInode(), db.DescriptorAttrs{Permission: "0600", Atime: old, Mtime: old})
+		}, ctime, old, old},
+		{func() error {
+			return repo.UpdateAtime(ctx, file.GetInode(), true)
+		}, atime, time.Time{}, time.Time{}},
+		{func() error {
+			return repo.Rename(ctx, root.GetInode(), "file", dir.GetInode(), "file")
+		}, ctime, time.Time{}, time.Time{}},
+	}
+
+	prev := file
+	for id, test := range tests {
+		id += 1
+		started := time.Now()
+		if id == 1 {
+			started = created
+		}
+		if err := test.op(); err != nil {
+			t.Fatalf("Test %v fail: %s", id, err)
+		}
+
+		descr, err := repo.FindSingleByInode(ctx, file.GetInode())
+		if err != nil || descr == nil {
+			t.Fatalf("Test %v fail: descriptor is not found: %v", id, err)
+		}
+		times := []struct {
+			name     string
+			flag     int
+			expected time.Time
+			prev     time.Time
+			current  time.Time
+		}{
+			{"atime", atime, test.expectedAtime, prev.GetAtime(), descr.GetAtime()},
+			{"mtime", mtime, test.expectedMtime, prev.GetMtime(), descr.GetMtime()},
+			{"ctime", ctime, time.Time{}, prev.GetCtime(), descr.GetCtime()},
+		}
+		for _, tm := range times {
+			switch {
+			case !tm.expected.IsZero():
+				if !tm.current.Equal(tm.expected) {
+					t.Errorf("Test %v fail: %s is not as expected.\nExpected: %v. Result: %v.\n", id, tm.name, tm.expected, tm.current)
+				}
+			case test.updated&tm.flag != 0:
+				if tm.current.Before(started) {
+					t.Errorf("Test %v fail: %s is not updated.\nExpected: not before %v. Result: %v.\n", id, tm.name, started, tm.current)
+				}
+			default:
+				if !tm.current.Equal(tm.prev) {
+					t.Errorf("Test %v fail: %s is changed.\nExpected: %v. Result: %v.\n", id, tm.name, tm.prev, tm.current)
+				}
+			}
+		}
+		if !descr.GetCrtime().Equal(crtime) {
+			t.Errorf("Test %v fail: crtime is changed.\nExpected: %v. Result: %v.\n", id, crtime, descr.GetCrtime())
+		}
+		prev = descr
+	}
+
+	target, _ := repo.FindSingleByInode(ctx, dir.GetInode())
+	if target == nil || !target.GetMtime().Equal(prev.GetCtime()) {
+		t.Errorf("Modification time of the target directory of the rename is not as expected.\nExpected: %v. Result: %v.\n", prev.GetCtime(), target)
+	}
+}
+
+// CheckUsage creates files and a hard link and checks the usage of the volume.
+func CheckUsage(t *testing.T, registry db.RepositoryRegistry) {
+	ctx := context.Background()
+	repo := registry.GetDescriptorRepository()
+
+	root, _ := repo.FindRoot(ctx)
+	before, err := repo.GetUsage(ctx)
+	if err != nil {
+		t.Fatalf("GetUsage fail: %s", err)
+	}
+
+	file, err := repo.Create(ctx, root.GetInode(), "file", db.DT_File, db.DescriptorAttrs{Permission: "0644"})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	if _, err = registry.GetDataBlockRepository().Write(ctx, file, 0, []byte("0123456789")); err != nil {
+		t.Fatalf("Write fail: %s", err)
+	}
+	if _, err = repo.Link(ctx, file.GetInode(), root.GetInode(), "hard"); err != nil {
+		t.Fatalf("Link fail: %s", err)
+	}
+	if _, err = repo.Create(ctx, root.GetInode(), "dir", db.DT_Dir, db.DescriptorAttrs{Permission: "0755"}); err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+
+	after, err := repo.GetUsage(ctx)
+	if err != nil {
+		t.Fatalf("GetUsage fail: %s", err)
+	}
+	if after.Bytes-before.Bytes != 10 || after.Inodes-before.Inodes != 2 {
+		t.Errorf("Usage is not as expected.\nExpected: 10 bytes, 2 inodes. Result: %v bytes, %v inodes.\n", after.Bytes-before.Bytes, after.Inodes-before.Inodes)
+	}
+}
+
+// CheckXattrRepository sets, lists and removes extended attributes of the root with the create/replace flags.
+func CheckXattrRepository(t *testing.T, registry db.RepositoryRegistry) {
+	ctx := context.Background()
+	root, _ := registry.GetDescriptorRepository().FindRoot(ctx)
+	inode := root.GetInode()
+	repo := registry.GetXattrRepository()
+
+	var (
+		existsErr   *db.XattrExistsError
+		notFoundErr *db.XattrNotFoundError
+	)
+	tests := []struct {
+		op       func() error
+		expected interface{}
+	}{
+		{func() error { return repo.Set(ctx, inode, "user.a", []byte("1"), db.XattrCreate) }, nil},
+		{func() error { return repo.Set(ctx, inode, "user.a", []byte("2"), db.XattrCreate) }, &existsErr},
+		{func() error { return repo.Set(ctx, inode, "user.b", []byte("2"), db.XattrReplace) }, &notFoundErr},
+		{func() error { return repo.Set(ctx, inode, "user.a", []byte("3"), db.XattrReplace) }, nil},
+		{func() error { return repo.Set(ctx, inode, "user.c", []byte{0, 255, 0}, 0) }, nil},
+		{func() error { return repo.Set(ctx, inode, "user.e", []byte{}, 0) }, nil},
+		{func() error { return repo.Set(ctx, inode, "trusted.d", []byte("4"), 0) }, nil},
+		{func() error { return repo.Remove(ctx, inode, "trusted.d") }, nil},
+		{func() error { return repo.Remove(ctx, inode, "trusted.d") }, &notFoundErr},
+		{func() error {
+			_, err := repo.Find(ctx, inode, "user.missing")
+			return err
+		}, &notFoundErr},
+	}
+
+	for id, test := range tests {
+		id += 1
+		err := test.op()
+		if test.expected == nil && err != nil || test.expected != nil && !errors.As(err, test.expected) {
+			t.Errorf("Test %v fail: error is not as expected.\nExpected: %T. Result: %v.\n", id, test.expected, err)
+		}
+	}
+
+	values := map[string]string{"user.a": "3", "user.c": "\x00\xff\x00", "user.e": ""}
+	for name, expected := range values {
+		value, err := repo.Find(ctx, inode, name)
+		if err != nil || string(value) != expected {
+			t.Errorf("Value of %s is not as expected.\nExpected: %q. Result: %q, %v.\n", name, expected, value, err)
+		}
+	}
+	names, err := repo.FindNames(ctx, inode)
+	if err != nil || strings.Join(names, " ") != "user.a user.c user.e" {
+		t.Errorf("Names are not as expected.\nExpected: [user.a user.c user.e]. Result: %v, %v.\n", names, err)
+	}
+}
+
+// CheckCaseInsensitive looks up, creates, renames and removes entries by names that differ in case.
+// The registry must be case-insensitive.
+func CheckCaseInsensitive(t *testing.T, registry db.RepositoryRegistry) {
+	ctx := context.Background()
+	repo := registry.GetDescriptorRepository()
+
+	root, _ := repo.FindRoot(ctx)
+	file, err := repo.Create(ctx, root.GetInode(), "Readme.md", db.DT_File, db.DescriptorAttrs{Permission: "0644"})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	dir, err := repo.Create(ctx, root.GetInode(), "Ärger", db.DT_Dir, db.DescriptorAttrs{Permission: "0755"})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+
+	lookups := []struct {
+		name         string
+		expectedName string
+	}{
+		{"Readme.md", "Readme.md"},
+		{"README.MD", "Readme.md"},
+		{"readme.md", "Readme.md"},
+		{"äRGER", "Ärger"},
+		{"readme", ""},
+	}
+	for id, test := range lookups {
+		id += 1
+		descr, err := repo.FindSingleByName(ctx, root.GetInode(), test.name)
+		if err != nil {
+			t.Fatalf("Test %v fail: %s", id, err)
+		}
+		name := ""
+		if descr != nil {
+			name = descr.GetName()
+		}
+		if name != test.expectedName {
+			t.Errorf("Test %v fail: found entry is not as expected.\nExpected: %q. Result: %q.\n", id, test.expectedName, name)
+		}
+	}
+
+	children, err := repo.FindChildrenByInode(ctx, root.GetInode())
+	if err != nil {
+		t.Fatalf("FindChildrenByInode fail: %s", err)
+	}
+	var names []string
+	for _, item := range children.ToList() {
+		names = append(names, item.(db.DescriptorInterface).GetName())
+	}
+	if strings.Join(names, " ") != "Ärger Readme.md" {
+		t.Errorf("Listed names are not as expected.\nExpected: %q. Result: %q.\n", "Ärger Readme.md", strings.Join(names, " "))
+	}
+
+	var existsErr *db.ExistsError
+	if _, err = repo.Create(ctx, root.GetInode(), "README.md", db.DT_File, db.DescriptorAttrs{Permission: "0644"}); !errors.As(err, &existsErr) {
+		t.Errorf("Create of a name differing in case error is not as expected.\nExpected: %T. Result: %v.\n", existsErr, err)
+	}
+	if _, err = repo.Link(ctx, file.GetInode(), root.GetInode(), "README.MD"); !errors.As(err, &existsErr) {
+		t.Errorf("Link to a name differing in case error is not as expected.\nExpected: %T. Result: %v.\n", existsErr, err)
+	}
+
+	if err = repo.Rename(ctx, root.GetInode(), "readme.MD", root.GetInode(), "README.md"); err != nil {
+		t.Fatalf("Rename fail: %s", err)
+	}
+	descr := mustFind(t, repo, root.GetInode(), "readme.md")
+	if descr.GetName() != "README.md" || descr.GetInode() != file.GetInode() {
+		t.Errorf("Rename to another case is not as expected.\nExpected: README.md, %v. Result: %s, %v.\n", file.GetInode(), descr.GetName(), descr.GetInode())
+	}
+
+	other, err := repo.Create(ctx, root.GetInode(), "other", db.DT_File, db.DescriptorAttrs{Permission: "0644"})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	if err = repo.Rename(ctx, root.GetInode(), "OTHER", root.GetInode(), "readme.md"); err != nil {
+		t.Fatalf("Rename fail: %s", err)
+	}
+	descr = mustFind(t, repo, root.GetInode(), "Readme.MD")
+	if descr.GetName() != "readme.md" || descr.GetInode() != other.GetInode() {
+		t.Errorf("Rename over a name differing in case is not as expected.\nExpected: readme.md, %v. Result: %s, %v.\n", other.GetInode(), descr.GetName(), descr.GetInode())
+	}
+
+	// The link that takes over the place of the removed origin keeps its name key.
+	if _, err = repo.Link(ctx, other.GetInode(), dir.GetInode(), "Link"); err != nil {
+		t.Fatalf("Link fail: %s", err)
+	}
+	if err = repo.RemoveByName(ctx, root.GetInode(), "README.MD", false); err != nil {
+		t.Fatalf("RemoveByName fail: %s", err)
+	}
+	if exists, _ := repo.IsExistsByName(ctx, root.GetInode(), "readme.md"); exists {
+		t.Errorf("RemoveByName of a name differing in case is not removed the entry")
+	}
+	descr = mustFind(t, repo, dir.GetInode(), "LINK")
+	if descr.GetName() != "Link" || descr.GetInode() != other.GetInode() {
+		t.Errorf("Link after the origin takeover is not as expected.\nExpected: Link, %v. Result: %s, %v.\n", other.GetInode(), descr.GetName(), descr.GetInode())
+	}
+	if _, err = repo.Create(ctx, dir.GetInode(), "link", db.DT_File, db.DescriptorAttrs{Permission: "0644"}); !errors.As(err, &existsErr) {
+		t.Errorf("Create next to the link after the origin takeover error is not as expected.\nExpected: %T. Result: %v.\n", existsErr, err)
+	}
+}
+
+// CheckSettingRepository stores and overwrites settings of the volume.
+func CheckSettingRepository(t *testing.T, registry db.RepositoryRegistry) {
+	ctx := context.Background()
+	repo := registry.GetSettingRepository()
+
+	tests := []struct {
+		name     string
+		value    string
+		expected map[string]string
+	}{
+		{"", "", map[string]string{}},
+		{"first", "1", map[string]string{"first": "1"}},
+		{"second", "2", map[string]string{"first": "1", "second": "2"}},
+		{"first", "one", map[string]string{"first": "one", "second": "2"}},
+	}
+
+	for id, test := range tests {
+		id += 1
+		if test.name != "" {
+			if err := repo.Set(ctx, test.name, test.value); err != nil {
+				t.Fatalf("Test %v fail: %s", id, err)
+			}
+		}
+
+		settings, err := repo.FindAll(ctx)
+		if err != nil {
+			t.Fatalf("Test %v fail: %s", id, err)
+		}
+		if !reflect.DeepEqual(settings, test.expected) {
+			t.Errorf("Test %v fail: settings are not as expected.\nExpected: %v. Result: %v.\n", id, test.expected, settings)
+		}
+	}
+}
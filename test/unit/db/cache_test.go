@@ -0,0 +1,168 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"context"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"github.com/kos-v/dbunderfs/internal/db/memory"
+	helpers "github.com/kos-v/dbunderfs/test/helpers/db"
+	"testing"
+	"time"
+)
+
+// createCachedRegistry returns a cached registry and an uncached registry of the same storage.
+// Changes made through the uncached one are invisible to the cache, as changes of other mounts.
+func createCachedRegistry(t *testing.T, ttl time.Duration) (db.RepositoryRegistry, db.RepositoryRegistry) {
+	storage, err := memory.NewStorage()
+	if err != nil {
+		t.Fatalf("NewStorage fail: %s", err)
+	}
+	registry := &memory.RepositoryRegistry{BlockSize: 4, Storage: storage}
+
+	return db.NewCachedRepositoryRegistry(registry, ttl, false), registry
+}
+
+func TestCachedRepositoryRegistry(t *testing.T) {
+	cached, _ := createCachedRegistry(t, time.Minute)
+	helpers.CheckDataBlockRepository(t, cached)
+
+	cached, _ = createCachedRegistry(t, time.Minute)
+	helpers.CheckDescriptorRepository(t, cached)
+
+	cached, _ = createCachedRegistry(t, time.Minute)
+	helpers.CheckXattrRepository(t, cached)
+}
+
+func TestCachedDescriptorRepository_Invalidation(t *testing.T) {
+	ctx := context.Background()
+	cached, external := createCachedRegistry(t, time.Minute)
+	repo := cached.GetDescriptorRepository()
+	externalRepo := external.GetDescriptorRepository()
+	root, _ := repo.FindRoot(ctx)
+
+	if descr, err := repo.FindSingleByName(ctx, root.GetInode(), "external"); err != nil || descr != nil {
+		t.Fatalf("FindSingleByName fail: %v, %v", descr, err)
+	}
+	if _, err := externalRepo.Create(ctx, root.GetInode(), "external", db.DT_File, db.DescriptorAttrs{Permission: "0644"}); err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	if exists, _ := repo.IsExistsByName(ctx, root.GetInode(), "external"); exists {
+		t.Errorf("Test negative entry fail: external entry is visible before the TTL.\nExpected: %v. Result: %v.\n", false, exists)
+	}
+
+	file, err := repo.Create(ctx, root.GetInode(), "file", db.DT_File, db.DescriptorAttrs{Permission: "0644"})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	if exists, _ := repo.IsExistsByName(ctx, root.GetInode(), "file"); !exists {
+		t.Errorf("Test create fail: created entry is not found.\nExpected: %v. Result: %v.\n", true, exists)
+	}
+	children, _ := repo.FindChildrenByInode(ctx, root.GetInode())
+	if children.Len() != 2 {
+		t.Errorf("Test create fail: listing is not invalidated.\nExpected: %v. Result: %v.\n", 2, children.Len())
+	}
+
+	err = externalRepo.UpdateAttrs(ctx, file.GetInode(), db.DescriptorAttrs{Permission: "0600", Atime: file.GetAtime(), Mtime: file.GetMtime()})
+	if err != nil {
+		t.Fatalf("UpdateAttrs fail: %s", err)
+	}
+	if descr, _ := repo.FindSingleByInode(ctx, file.GetInode()); descr.GetPermission() != 0644 {
+		t.Errorf("Test cached attrs fail: external change is visible before the TTL.\nExpected: %v. Result: %v.\n", 0644, descr.GetPermission())
+	}
+	if _, err = cached.GetDataBlockRepository().Write(ctx, file, 0, []byte("data")); err != nil {
+		t.Fatalf("Write fail: %s", err)
+	}
+	descr, _ := repo.FindSingleByName(ctx, root.GetInode(), "file")
+	if descr.GetSize() != 4 || descr.GetPermission() != 0600 {
+		t.Errorf("Test write fail: descriptor is not invalidated.\nExpected: %v, %v. Result: %v, %v.\n", 4, 0600, descr.GetSize(), descr.GetPermission())
+	}
+
+	if _, err = repo.Link(ctx, file.GetInode(), root.GetInode(), "link"); err != nil {
+		t.Fatalf("Link fail: %s", err)
+	}
+	if descr, _ = repo.FindSingleByName(ctx, root.GetInode(), "file"); descr.GetNlink() != 2 {
+		t.Errorf("Test link fail: nlink is not invalidated.\nExpected: %v. Result: %v.\n", 2, descr.GetNlink())
+	}
+	if descr, _ = repo.FindSingleByName(ctx, root.GetInode(), "link"); descr.GetName() != "link" {
+		t.Errorf("Test link fail: name of the link is not as expected.\nExpected: %v. Result: %v.\n", "link", descr.GetName())
+	}
+
+	if err = repo.Rename(ctx, root.GetInode(), "link", root.GetInode(), "moved"); err != nil {
+		t.Fatalf("Rename fail: %s", err)
+	}
+	if exists, _ := repo.IsExistsByName(ctx, root.GetInode(), "link"); exists {
+		t.Errorf("Test rename fail: old entry is found.\nExpected: %v. Result: %v.\n", false, exists)
+	}
+
+	if err = repo.RemoveByName(ctx, root.GetInode(), "moved", false); err != nil {
+		t.Fatalf("RemoveByName fail: %s", err)
+	}
+	if descr, _ = repo.FindSingleByInode(ctx, file.GetInode()); descr.GetNlink() != 1 {
+		t.Errorf("Test remove fail: nlink is not invalidated.\nExpected: %v. Result: %v.\n", 1, descr.GetNlink())
+	}
+	if exists, _ := repo.IsExistsByName(ctx, root.GetInode(), "moved"); exists {
+		t.Errorf("Test remove fail: removed entry is found.\nExpected: %v. Result: %v.\n", false, exists)
+	}
+}
+
+func TestCachedDescriptorRepository_CaseInsensitive(t *testing.T) {
+	storage, err := memory.NewStorage()
+	if err != nil {
+		t.Fatalf("NewStorage fail: %s", err)
+	}
+	cached := db.NewCachedRepositoryRegistry(&memory.RepositoryRegistry{BlockSize: 4, Storage: storage, CaseInsensitive: true}, time.Minute, true)
+	helpers.CheckCaseInsensitive(t, cached)
+
+	ctx := context.Background()
+	repo := cached.GetDescriptorRepository()
+	root, _ := repo.FindRoot(ctx)
+	if exists, _ := repo.IsExistsByName(ctx, root.GetInode(), "NOTES"); exists {
+		t.Fatalf("IsExistsByName fail: %v", exists)
+	}
+	if _, err = repo.Create(ctx, root.GetInode(), "Notes", db.DT_File, db.DescriptorAttrs{Permission: "0644"}); err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	if descr, _ := repo.FindSingleByName(ctx, root.GetInode(), "NOTES"); descr == nil || descr.GetName() != "Notes" {
+		t.Errorf("Test negative entry fail: entry created in another case is not found.\nExpected: %v. Result: %v.\n", "Notes", descr)
+	}
+	if err = repo.Rename(ctx, root.GetInode(), "notes", root.GetInode(), "NOTES"); err != nil {
+		t.Fatalf("Rename fail: %s", err)
+	}
+	if descr, _ := repo.FindSingleByName(ctx, root.GetInode(), "Notes"); descr == nil || descr.GetName() != "NOTES" {
+		t.Errorf("Test rename fail: name is not invalidated.\nExpected: %v. Result: %v.\n", "NOTES", descr)
+	}
+}
+
+func TestCachedDescriptorRepository_TTL(t *testing.T) {
+	ctx := context.Background()
+	cached, external := createCachedRegistry(t, 10*time.Millisecond)
+	repo := cached.GetDescriptorRepository()
+	root, _ := repo.FindRoot(ctx)
+
+	if exists, _ := repo.IsExistsByName(ctx, root.GetInode(), "file"); exists {
+		t.Fatalf("IsExistsByName fail: %v", exists)
+	}
+	if _, err := external.GetDescriptorRepository().Create(ctx, root.GetInode(), "file", db.DT_File, db.DescriptorAttrs{Permission: "0644"}); err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if exists, _ := repo.IsExistsByName(ctx, root.GetInode(), "file"); !exists {
+		t.Errorf("Test TTL fail: negative entry is not expired.\nExpected: %v. Result: %v.\n", true, exists)
+	}
+}
@@ -0,0 +1,46 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"github.com/kos-v/dbunderfs/internal/db"
+	"testing"
+)
+
+func TestNameKey(t *testing.T) {
+	tests := []struct {
+		name            string
+		caseInsensitive bool
+		expected        string
+	}{
+		{"README.md", false, "README.md"},
+		{"README.md", true, "readme.md"},
+		{"Ärger", true, "ärger"},
+		{"ΣΊΣΥΦΟΣ", true, "σίσυφοσ"},
+		{"σίσυφος", true, "σίσυφοσ"},
+		{"K", true, "k"},
+		{"straſſe", true, "strasse"},
+		{"Файл 🙂", true, "файл 🙂"},
+	}
+
+	for id, test := range tests {
+		id += 1
+		if result := db.NameKey(test.name, test.caseInsensitive); result != test.expected {
+			t.Errorf("Test %v fail: key is not as expected.\nExpected: %q. Result: %q.\n", id, test.expected, result)
+		}
+	}
+}
@@ -16,41 +16,82 @@
 
 package db
 
-import "testing"
-import "github.com/kos-v/dbunderfs/internal/db"
+import (
+	"bytes"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"io/fs"
+	"testing"
+)
 
-func TestDataBlockNode_Add(t *testing.T) {
+func TestDescriptor_GetPermission(t *testing.T) {
 	tests := []struct {
-		defaultData    []byte
-		offset         uint64
-		addData        []byte
-		expectedData   []byte
-		expectedAddLen int
+		permission   string
+		expectedMode fs.FileMode
 	}{
-		{[]byte{}, 0, []byte{}, []byte{}, 0},
-		{[]byte{}, 0, []byte{1, 2, 3}, []byte{1, 2, 3}, 3},
-		{[]byte{}, 1, []byte{1, 2, 3}, []byte{1, 2, 3}, 3},
-		{[]byte{1, 2, 3}, 0, []byte{4, 5, 6}, []byte{4, 5, 6}, 3},
-		{[]byte{1, 2, 3}, 1, []byte{4, 5, 6}, []byte{1, 4, 5, 6}, 3},
-		{[]byte{1, 2, 3}, 2, []byte{4, 5, 6}, []byte{1, 2, 4, 5, 6}, 3},
-		{[]byte{1, 2, 3}, 3, []byte{4, 5, 6}, []byte{1, 2, 3, 4, 5, 6}, 3},
-		{[]byte{1, 2, 3}, 4, []byte{4, 5, 6}, []byte{1, 2, 3, 4, 5, 6}, 3},
+		{"0755", 0755},
+		{"0644", 0644},
+		{"0000", 0},
+		{"4755", fs.ModeSetuid | 0755},
+		{"2775", fs.ModeSetgid | 0775},
+		{"1777", fs.ModeSticky | 0777},
+		{"7777", fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky | 0777},
+		{"invalid", 0},
 	}
 
 	for id, test := range tests {
 		id += 1
-		dataBlock := db.DataBlockNode{Data: test.defaultData}
-		addLen := dataBlock.Add(test.offset, &test.addData)
-		if addLen != test.expectedAddLen {
-			t.Errorf("Test %v fail: the number of items added is not as expected.\nExpected: %v. Result: %v.\n", id, test.expectedAddLen, addLen)
+		descr := db.Descriptor{DescriptorAttrs: db.DescriptorAttrs{Permission: test.permission}}
+		if mode := descr.GetPermission(); mode != test.expectedMode {
+			t.Errorf("Test %v fail: unexpected mode.\nExpected: %v. Result: %v.\n", id, test.expectedMode, mode)
 		}
-		if len(*dataBlock.GetData()) != len(test.expectedData) {
-			t.Errorf("Test %v fail: object contains unexpected number of items.\nExpected: %v. Result: %v.\n", id, len(test.expectedData), len(*dataBlock.GetData()))
+	}
+}
+
+func TestSplice(t *testing.T) {
+	tests := []struct {
+		data         []byte
+		offset       uint64
+		chunk        []byte
+		expectedData []byte
+	}{
+		{[]byte{}, 0, []byte{}, []byte{}},
+		{nil, 0, []byte{1, 2}, []byte{1, 2}},
+		{[]byte{}, 2, []byte{1, 2}, []byte{0, 0, 1, 2}},
+		{[]byte{1, 2, 3}, 0, []byte{4}, []byte{4, 2, 3}},
+		{[]byte{1, 2, 3}, 1, []byte{4}, []byte{1, 4, 3}},
+		{[]byte{1, 2, 3}, 2, []byte{4, 5}, []byte{1, 2, 4, 5}},
+		{[]byte{1, 2, 3}, 3, []byte{4}, []byte{1, 2, 3, 4}},
+		{[]byte{1, 2, 3}, 5, []byte{4}, []byte{1, 2, 3, 0, 0, 4}},
+	}
+
+	for id, test := range tests {
+		id += 1
+		data := db.Splice(test.data, test.offset, test.chunk)
+		if !bytes.Equal(data, test.expectedData) {
+			t.Errorf("Test %v fail: result data is not as expected.\nExpected: %v. Result: %v.\n", id, test.expectedData, data)
 		}
-		for i, item := range *dataBlock.GetData() {
-			if item != test.expectedData[i] {
-				t.Errorf("Test %v fail: result data is not as expected.\nExpected: %v. Result: %v.\n", id, test.expectedData, *dataBlock.GetData())
-			}
+	}
+}
+
+func TestResize(t *testing.T) {
+	tests := []struct {
+		data         []byte
+		size         uint64
+		expectedData []byte
+	}{
+		{nil, 0, []byte{}},
+		{nil, 2, []byte{0, 0}},
+		{[]byte{1, 2, 3}, 0, []byte{}},
+		{[]byte{1, 2, 3}, 2, []byte{1, 2}},
+		{[]byte{1, 2, 3}, 3, []byte{1, 2, 3}},
+		{[]byte{1, 2, 3}, 5, []byte{1, 2, 3, 0, 0}},
+	}
+
+	for id, test := range tests {
+		id += 1
+		data := db.Resize(test.data, test.size)
+		if !bytes.Equal(data, test.expectedData) {
+			t.Errorf("Test %v fail: result data is not as expected.\nExpected: %v. Result: %v.\n", id, test.expectedData, data)
 		}
 	}
 }
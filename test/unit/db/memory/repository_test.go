@@ -0,0 +1,129 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"github.com/kos-v/dbunderfs/internal/db/memory"
+	helpers "github.com/kos-v/dbunderfs/test/helpers/db"
+	"sync"
+	"testing"
+)
+
+func createRegistry(t *testing.T) db.RepositoryRegistry {
+	storage, err := memory.NewStorage()
+	if err != nil {
+		t.Fatalf("NewStorage fail: %s", err)
+	}
+
+	return &memory.RepositoryRegistry{BlockSize: 4, Storage: storage}
+}
+
+func TestDataBlockRepository(t *testing.T) {
+	helpers.CheckDataBlockRepository(t, createRegistry(t))
+}
+
+func TestDescriptorRepository(t *testing.T) {
+	helpers.CheckDescriptorRepository(t, createRegistry(t))
+}
+
+func TestRename(t *testing.T) {
+	helpers.CheckRename(t, createRegistry(t))
+}
+
+func TestLink(t *testing.T) {
+	helpers.CheckLink(t, createRegistry(t))
+}
+
+func TestSymlink(t *testing.T) {
+	helpers.CheckSymlink(t, createRegistry(t))
+}
+
+func TestTimestamps(t *testing.T) {
+	helpers.CheckTimestamps(t, createRegistry(t))
+}
+
+func TestXattrRepository(t *testing.T) {
+	helpers.CheckXattrRepository(t, createRegistry(t))
+}
+
+func TestUsage(t *testing.T) {
+	helpers.CheckUsage(t, createRegistry(t))
+}
+
+func TestSettingRepository(t *testing.T) {
+	helpers.CheckSettingRepository(t, createRegistry(t))
+}
+
+func createCaseInsensitiveRegistry(t *testing.T) db.RepositoryRegistry {
+	registry := createRegistry(t).(*memory.RepositoryRegistry)
+	registry.CaseInsensitive = true
+
+	return registry
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	helpers.CheckCaseInsensitive(t, createCaseInsensitiveRegistry(t))
+}
+
+func TestCaseInsensitive_Rename(t *testing.T) {
+	helpers.CheckRename(t, createCaseInsensitiveRegistry(t))
+}
+
+func TestDescriptorRepository_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	registry := createRegistry(t)
+	repo := registry.GetDescriptorRepository()
+	root, _ := repo.FindRoot(ctx)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				name := fmt.Sprintf("file-%d-%d", i, j)
+				file, err := repo.Create(ctx, root.GetInode(), name, db.DT_File, db.DescriptorAttrs{Permission: "0644"})
+				if err != nil {
+					t.Errorf("Create fail: %s", err)
+					return
+				}
+				if _, err = registry.GetDataBlockRepository().Write(ctx, file, 0, []byte(name)); err != nil {
+					t.Errorf("Write fail: %s", err)
+					return
+				}
+				if j%2 == 0 {
+					if err = repo.RemoveByName(ctx, root.GetInode(), name, false); err != nil {
+						t.Errorf("RemoveByName fail: %s", err)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	children, err := repo.FindChildrenByInode(ctx, root.GetInode())
+	if err != nil {
+		t.Fatalf("FindChildrenByInode fail: %s", err)
+	}
+	if children.Len() != 16*25 {
+		t.Errorf("Children count is not as expected.\nExpected: %v. Result: %v.\n", 16*25, children.Len())
+	}
+}
@@ -0,0 +1,49 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package postgres
+
+import (
+	"github.com/kos-v/dbunderfs/internal/db/postgres"
+	"testing"
+)
+
+func TestDialect_Rebind(t *testing.T) {
+	tests := []struct {
+		query         string
+		expectedQuery string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT * FROM t WHERE a = ? AND b = ?", "SELECT * FROM t WHERE a = $1 AND b = $2"},
+		{"SELECT '?' FROM t WHERE a = ?", "SELECT '?' FROM t WHERE a = $1"},
+		{"SELECT 'it''s ?' FROM t WHERE a = ?", "SELECT 'it''s ?' FROM t WHERE a = $1"},
+		{"SUBSTRING(data FROM ? FOR ?)", "SUBSTRING(data FROM $1 FOR $2)"},
+	}
+
+	for id, test := range tests {
+		id += 1
+		if query := (postgres.Dialect{}).Rebind(test.query); query != test.expectedQuery {
+			t.Errorf("Test %v fail: query is not as expected.\nExpected: %q. Result: %q.\n", id, test.expectedQuery, query)
+		}
+	}
+}
+
+func TestDialect_Upsert(t *testing.T) {
+	expected := "ON CONFLICT (inode, idx) DO UPDATE SET data = EXCLUDED.data"
+	if clause := (postgres.Dialect{}).Upsert("inode, idx", "data"); clause != expected {
+		t.Errorf("Upsert clause is not as expected.\nExpected: %q. Result: %q.\n", expected, clause)
+	}
+}
@@ -0,0 +1,254 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"github.com/kos-v/dbunderfs/internal/db/sqldb"
+	"github.com/kos-v/dbunderfs/internal/db/sqlite"
+	"github.com/kos-v/dsnparser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	errConflict   = errors.New("deadlock")
+	errConnection = errors.New("server gone away")
+	errPermanent  = errors.New("syntax error")
+)
+
+func classifyStub(err error) db.ErrorClass {
+	switch err {
+	case errConflict:
+		return db.ErrorRetryable
+	case errConnection:
+		return db.ErrorConnection
+	}
+	return db.ErrorPermanent
+}
+
+// instanceStub fails the operations with the queued errors and counts the calls.
+type instanceStub struct {
+	db.Instance
+	errs       []error
+	calls      int
+	reconnects int
+}
+
+func (inst *instanceStub) next() error {
+	inst.calls++
+	if len(inst.errs) == 0 {
+		return nil
+	}
+	err := inst.errs[0]
+	inst.errs = inst.errs[1:]
+	return err
+}
+
+func (inst *instanceStub) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return nil, inst.next()
+}
+
+func (inst *instanceStub) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, inst.next()
+}
+
+func (inst *instanceStub) Reconnect() (*sql.DB, error) {
+	inst.reconnects++
+	return nil, nil
+}
+
+func (inst *instanceStub) Transaction(ctx context.Context, handler db.TxHandler) error {
+	if err := handler(nil); err != nil {
+		return err
+	}
+	return inst.next()
+}
+
+func TestRetryInstance(t *testing.T) {
+	tests := []struct {
+		operation          string
+		errs               []error
+		expectedErr        error
+		expectedCalls      int
+		expectedReconnects int
+	}{
+		{"exec", nil, nil, 1, 0},
+		{"exec", []error{errConflict, errConflict}, nil, 3, 0},
+		{"exec", []error{errConflict, errConflict, errConflict, errConflict}, errConflict, 3, 0},
+		{"exec", []error{errConnection}, errConnection, 1, 1},
+		{"exec", []error{errPermanent}, errPermanent, 1, 0},
+		{"query", []error{errConnection, errConflict}, nil, 3, 1},
+		{"query", []error{errPermanent}, errPermanent, 1, 0},
+		{"commit", []error{errConflict}, nil, 2, 0},
+		{"commit", []error{errConnection}, errConnection, 1, 1},
+	}
+
+	for id, test := range tests {
+		id += 1
+		stub := &instanceStub{errs: test.errs}
+		inst := &db.RetryInstance{Instance: stub, Classify: classifyStub, Policy: db.RetryPolicy{MaxRetries: 2}}
+
+		var err error
+		switch test.operation {
+		case "exec":
+			_, err = inst.ExecContext(context.Background(), "")
+		case "query":
+			_, err = inst.QueryContext(context.Background(), "")
+		case "commit":
+			err = inst.Transaction(context.Background(), func(tx db.Queryer) error { return nil })
+		}
+
+		if err != test.expectedErr {
+			t.Errorf("Test %v fail: error is not as expected.\nExpected: %v. Result: %v.\n", id, test.expectedErr, err)
+		}
+		if stub.calls != test.expectedCalls {
+			t.Errorf("Test %v fail: calls count is not as expected.\nExpected: %v. Result: %v.\n", id, test.expectedCalls, stub.calls)
+		}
+		if stub.reconnects != test.expectedReconnects {
+			t.Errorf("Test %v fail: reconnects count is not as expected.\nExpected: %v. Result: %v.\n", id, test.expectedReconnects, stub.reconnects)
+		}
+	}
+}
+
+func TestRetryInstance_Transaction(t *testing.T) {
+	stub := &instanceStub{}
+	inst := &db.RetryInstance{Instance: stub, Classify: classifyStub, Policy: db.RetryPolicy{MaxRetries: 2}}
+
+	attempts := 0
+	err := inst.Transaction(context.Background(), func(tx db.Queryer) error {
+		attempts++
+		if attempts == 1 {
+			return errConnection
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("Transaction lost before the commit is not repeated.\nExpected: <nil>, 2. Result: %v, %v.\n", err, attempts)
+	}
+}
+
+// TestRetryInstance_ConcurrentReconnect reconnects while a transaction holds the only connection
+// of the pool and a query waits for it. Both must finish on the old pool.
+func TestRetryInstance_ConcurrentReconnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbfs")
+	if err != nil {
+		t.Fatalf("TempDir fail: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dsn := &sqlite.DSN{ParsedDSN: *dsnparser.Parse("sqlite://" + filepath.Join(dir, "vol.db") + "?max_open_conns=1")}
+	sqlInst := &sqldb.Instance{DSN: dsn, Dialect: sqlite.Dialect{}}
+	pool, err := sqlInst.Connect()
+	if err != nil {
+		t.Fatalf("Connect fail: %s", err)
+	}
+	defer sqlInst.Close()
+	if _, err = sqlInst.Exec(`CREATE TABLE counters (id INTEGER PRIMARY KEY, value INTEGER NOT NULL)`); err != nil {
+		t.Fatalf("Exec fail: %s", err)
+	}
+	if _, err = sqlInst.Exec(`INSERT INTO counters (id, value) VALUES (1, 0)`); err != nil {
+		t.Fatalf("Exec fail: %s", err)
+	}
+	inst := &db.RetryInstance{Instance: sqlInst, Classify: sqlite.ClassifyError}
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	txErr := make(chan error, 1)
+	go func() {
+		txErr <- inst.Transaction(ctx, func(tx db.Queryer) error {
+			close(started)
+			<-release
+			_, err := tx.Exec(`UPDATE counters SET value = value + 1 WHERE id = 1`)
+			return err
+		})
+	}()
+	<-started
+
+	queryErr := make(chan error, 1)
+	go func() {
+		var value int
+		queryErr <- inst.QueryRowContext(ctx, `SELECT value FROM counters WHERE id = 1`).Scan(&value)
+	}()
+	for pool.Stats().WaitCount == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err = inst.Reconnect(); err != nil {
+		t.Fatalf("Reconnect fail: %s", err)
+	}
+	close(release)
+
+	if err = <-txErr; err != nil {
+		t.Errorf("Transaction started before the reconnect fail.\nExpected: %v. Result: %v.\n", nil, err)
+	}
+	if err = <-queryErr; err != nil {
+		t.Errorf("Query waiting for a connection during the reconnect fail.\nExpected: %v. Result: %v.\n", nil, err)
+	}
+
+	var value int
+	if err = inst.QueryRowContext(ctx, `SELECT value FROM counters WHERE id = 1`).Scan(&value); err != nil || value != 1 {
+		t.Errorf("Counter after the reconnect is not as expected.\nExpected: %v. Result: %v, %v.\n", 1, value, err)
+	}
+	// Close waits until the old pool is closed.
+	if err = sqlInst.Close(); err != nil {
+		t.Fatalf("Close fail: %s", err)
+	}
+	if _, err = pool.Exec(`SELECT 1`); err == nil {
+		t.Errorf("Old pool after Close is not as expected.\nExpected: closed. Result: %v.\n", err)
+	}
+}
+
+func TestInstance_NotConnected(t *testing.T) {
+	inst := &sqldb.Instance{DSN: &sqlite.DSN{ParsedDSN: *dsnparser.Parse("sqlite:///nonexistent/vol.db")}, Dialect: sqlite.Dialect{}}
+	ctx := context.Background()
+
+	var value int
+	tests := []func() error{
+		func() error {
+			_, err := inst.ExecContext(ctx, `SELECT 1`)
+			return err
+		},
+		func() error {
+			_, err := inst.QueryContext(ctx, `SELECT 1`)
+			return err
+		},
+		func() error { return inst.QueryRowContext(ctx, `SELECT 1`).Scan(&value) },
+		func() error {
+			return inst.Transaction(ctx, func(tx db.Queryer) error { return nil })
+		},
+	}
+
+	for id, test := range tests {
+		id += 1
+		var notConnectedErr *sqldb.NotConnectedError
+		if err := test(); !errors.As(err, &notConnectedErr) {
+			t.Errorf("Test %v fail: error is not as expected.\nExpected: %T. Result: %v.\n", id, notConnectedErr, err)
+		}
+	}
+
+	if err := inst.Close(); err != nil {
+		t.Errorf("Close of an instance that is not connected fail: %s", err)
+	}
+}
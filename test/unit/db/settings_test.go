@@ -0,0 +1,115 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"github.com/kos-v/dbunderfs/internal/db/memory"
+	"testing"
+)
+
+func createSettingsRegistry(t *testing.T) db.RepositoryRegistry {
+	storage, err := memory.NewStorage()
+	if err != nil {
+		t.Fatalf("NewStorage fail: %s", err)
+	}
+
+	return &memory.RepositoryRegistry{BlockSize: 4, Storage: storage}
+}
+
+func TestLoadVolumeSettings(t *testing.T) {
+	tests := []struct {
+		stored      map[string]string
+		expected    db.VolumeSettings
+		expectedErr bool
+	}{
+		{map[string]string{}, db.VolumeSettings{}, false},
+		{map[string]string{"case_insensitive": "true"}, db.VolumeSettings{CaseInsensitive: true}, false},
+		{map[string]string{"case_insensitive": "0"}, db.VolumeSettings{}, false},
+		{map[string]string{"case_insensitive": "yes"}, db.VolumeSettings{}, true},
+		{map[string]string{"capacity": "1048576"}, db.VolumeSettings{Limits: db.VolumeLimits{Capacity: 1 << 20}}, false},
+		{map[string]string{"capacity": "10G", "max_inodes": "1000"}, db.VolumeSettings{Limits: db.VolumeLimits{Capacity: 10 << 30, MaxInodes: 1000}}, false},
+		{map[string]string{"capacity": "2T"}, db.VolumeSettings{Limits: db.VolumeLimits{Capacity: 2 << 40}}, false},
+		{map[string]string{"capacity": "10X"}, db.VolumeSettings{}, true},
+		{map[string]string{"capacity": "99999999999T"}, db.VolumeSettings{}, true},
+		{map[string]string{"max_inodes": "-1"}, db.VolumeSettings{}, true},
+		{map[string]string{"unknown": "1"}, db.VolumeSettings{}, true},
+	}
+
+	ctx := context.Background()
+	for id, test := range tests {
+		id += 1
+		repo := createSettingsRegistry(t).GetSettingRepository()
+		for name, value := range test.stored {
+			if err := repo.Set(ctx, name, value); err != nil {
+				t.Fatalf("Test %v fail: %s", id, err)
+			}
+		}
+
+		result, err := db.LoadVolumeSettings(ctx, repo)
+		if (err != nil) != test.expectedErr {
+			t.Errorf("Test %v fail: error is not as expected.\nExpected: %v. Result: %v.\n", id, test.expectedErr, err)
+			continue
+		}
+		if err == nil && result != test.expected {
+			t.Errorf("Test %v fail: settings are not as expected.\nExpected: %+v. Result: %+v.\n", id, test.expected, result)
+		}
+	}
+}
+
+func TestSaveVolumeSetting(t *testing.T) {
+	ctx := context.Background()
+	registry := createSettingsRegistry(t)
+
+	tests := []struct {
+		name        string
+		value       string
+		expectedErr bool
+	}{
+		{"case_insensitive", "true", false},
+		{"case_insensitive", "false", false},
+		{"case_insensitive", "yes", true},
+		{"unknown", "1", true},
+	}
+	for id, test := range tests {
+		id += 1
+		err := db.SaveVolumeSetting(ctx, registry, test.name, test.value)
+		if (err != nil) != test.expectedErr {
+			t.Errorf("Test %v fail: error is not as expected.\nExpected: %v. Result: %v.\n", id, test.expectedErr, err)
+		}
+	}
+
+	settings, _ := registry.GetSettingRepository().FindAll(ctx)
+	if len(settings) != 1 || settings["case_insensitive"] != "false" {
+		t.Errorf("Stored settings are not as expected.\nExpected: %v. Result: %v.\n", map[string]string{"case_insensitive": "false"}, settings)
+	}
+
+	repo := registry.GetDescriptorRepository()
+	root, _ := repo.FindRoot(ctx)
+	if _, err := repo.Create(ctx, root.GetInode(), "file", db.DT_File, db.DescriptorAttrs{Permission: "0644"}); err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	var notEmptyErr *db.NotEmptyError
+	if err := db.SaveVolumeSetting(ctx, registry, "case_insensitive", "true"); !errors.As(err, &notEmptyErr) {
+		t.Errorf("Change of the case sensitivity of a volume with entries error is not as expected.\nExpected: %T. Result: %v.\n", notEmptyErr, err)
+	}
+	if err := db.SaveVolumeSetting(ctx, registry, "capacity", "10G"); err != nil {
+		t.Errorf("Change of the capacity of a volume with entries fail: %s", err)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sqlite
+
+import (
+	"github.com/kos-v/dbunderfs/internal/db/sqldb"
+	"github.com/kos-v/dbunderfs/internal/db/sqlite"
+	"github.com/kos-v/dsnparser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDSN_ToString(t *testing.T) {
+	tests := []struct {
+		dsn         string
+		expectedDSN string
+	}{
+		{"sqlite:///var/lib/dbfs/vol.db", "file:/var/lib/dbfs/vol.db?_busy_timeout=5000&_foreign_keys=1&_journal_mode=WAL&_txlock=immediate"},
+		{"sqlite://vol.db?prefix=fs_&max_open_conns=1", "file:vol.db?_busy_timeout=5000&_foreign_keys=1&_journal_mode=WAL&_txlock=immediate"},
+		{"sqlite:///data/a#b%c d.db", "file:/data/a%23b%25c%20d.db?_busy_timeout=5000&_foreign_keys=1&_journal_mode=WAL&_txlock=immediate"},
+		{"sqlite:///vol.db?_busy_timeout=100&_cache_size=-2000", "file:/vol.db?_busy_timeout=100&_cache_size=-2000&_foreign_keys=1&_journal_mode=WAL&_txlock=immediate"},
+		{"sqlite:///vol.db?_foreign_keys=0&_txlock=deferred", "file:/vol.db?_busy_timeout=5000&_foreign_keys=1&_journal_mode=WAL&_txlock=immediate"},
+	}
+
+	for id, test := range tests {
+		id += 1
+		dsn := &sqlite.DSN{ParsedDSN: *dsnparser.Parse(test.dsn)}
+		if result := dsn.ToString(); result != test.expectedDSN {
+			t.Errorf("Test %v fail: connection string is not as expected.\nExpected: %q. Result: %q.\n", id, test.expectedDSN, result)
+		}
+	}
+}
+
+func TestDSN_SpecialCharacters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbfs")
+	if err != nil {
+		t.Fatalf("TempDir fail: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a#b%c.db")
+	inst := &sqldb.Instance{DSN: &sqlite.DSN{ParsedDSN: *dsnparser.Parse("sqlite://" + path)}, Dialect: sqlite.Dialect{}}
+	if _, err := inst.Connect(); err != nil {
+		t.Fatalf("Connect fail: %s", err)
+	}
+	defer inst.Close()
+	if _, err := inst.Exec(`CREATE TABLE t (id INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatalf("Exec fail: %s", err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Database file is not as expected.\nExpected: %s. Result: %v.\n", path, err)
+	}
+}
@@ -0,0 +1,195 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sqlite
+
+import (
+	"context"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"github.com/kos-v/dbunderfs/internal/db/migration"
+	"github.com/kos-v/dbunderfs/internal/db/sqldb"
+	"github.com/kos-v/dbunderfs/internal/db/sqlite"
+	sqliteMigration "github.com/kos-v/dbunderfs/internal/db/sqlite/migration"
+	sqliteMigrations "github.com/kos-v/dbunderfs/internal/migrations/sqlite"
+	helpers "github.com/kos-v/dbunderfs/test/helpers/db"
+	"github.com/kos-v/dsnparser"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func createRegistry(t *testing.T) (db.RepositoryRegistry, func()) {
+	dir, err := ioutil.TempDir("", "dbfs")
+	if err != nil {
+		t.Fatalf("TempDir fail: %s", err)
+	}
+	path := filepath.Join(dir, "vol.db")
+	inst := &sqldb.Instance{DSN: &sqlite.DSN{ParsedDSN: *dsnparser.Parse("sqlite://" + path)}, Dialect: sqlite.Dialect{}}
+	if _, err := inst.Connect(); err != nil {
+		t.Fatalf("Connect fail: %s", err)
+	}
+
+	logger := log.New()
+	logger.SetOutput(ioutil.Discard)
+
+	migrator := migration.Migrator{
+		Commiter:            &sqliteMigration.Commiter{Instance: inst},
+		Direction:           migration.DirUp,
+		DownDirectionNumber: -1,
+		QueryExecutor:       inst,
+		Logger:              logger,
+		Migrations:          sqliteMigrations.Migrations(),
+	}
+	// The migrations are rolled back to check the down direction as well.
+	cleanup := func() {
+		migrator.Direction = migration.DirDown
+		if err := migrator.Migrate(); err != nil {
+			t.Errorf("Migrate down fail: %s", err)
+		}
+		inst.Close()
+		os.RemoveAll(dir)
+	}
+	if err := migrator.Migrate(); err != nil {
+		cleanup()
+		t.Fatalf("Migrate fail: %s", err)
+	}
+
+	return &sqldb.RepositoryRegistry{BlockSize: 4, Instance: inst, Dialect: sqlite.Dialect{}}, cleanup
+}
+
+func TestDataBlockRepository(t *testing.T) {
+	registry, cleanup := createRegistry(t)
+	defer cleanup()
+
+	helpers.CheckDataBlockRepository(t, registry)
+}
+
+func TestDescriptorRepository(t *testing.T) {
+	registry, cleanup := createRegistry(t)
+	defer cleanup()
+
+	helpers.CheckDescriptorRepository(t, registry)
+}
+
+func TestRename(t *testing.T) {
+	registry, cleanup := createRegistry(t)
+	defer cleanup()
+
+	helpers.CheckRename(t, registry)
+}
+
+func TestLink(t *testing.T) {
+	registry, cleanup := createRegistry(t)
+	defer cleanup()
+
+	helpers.CheckLink(t, registry)
+}
+
+func TestSymlink(t *testing.T) {
+	registry, cleanup := createRegistry(t)
+	defer cleanup()
+
+	helpers.CheckSymlink(t, registry)
+}
+
+func TestTimestamps(t *testing.T) {
+	registry, cleanup := createRegistry(t)
+	defer cleanup()
+
+	helpers.CheckTimestamps(t, registry)
+}
+
+func TestXattrRepository(t *testing.T) {
+	registry, cleanup := createRegistry(t)
+	defer cleanup()
+
+	helpers.CheckXattrRepository(t, registry)
+}
+
+func TestUsage(t *testing.T) {
+	registry, cleanup := createRegistry(t)
+	defer cleanup()
+
+	helpers.CheckUsage(t, registry)
+}
+
+func TestSettingRepository(t *testing.T) {
+	registry, cleanup := createRegistry(t)
+	defer cleanup()
+
+	helpers.CheckSettingRepository(t, registry)
+}
+
+// createCaseInsensitiveRegistry switches the new volume to case-insensitive names.
+func createCaseInsensitiveRegistry(t *testing.T) (db.RepositoryRegistry, func()) {
+	registry, cleanup := createRegistry(t)
+
+	ctx := context.Background()
+	if err := db.SaveVolumeSetting(ctx, registry, db.SettingCaseInsensitive, "true"); err != nil {
+		cleanup()
+		t.Fatalf("SaveVolumeSetting fail: %s", err)
+	}
+	settings, err := db.LoadVolumeSettings(ctx, registry.GetSettingRepository())
+	if err != nil {
+		cleanup()
+		t.Fatalf("LoadVolumeSettings fail: %s", err)
+	}
+	registry.(*sqldb.RepositoryRegistry).CaseInsensitive = settings.CaseInsensitive
+
+	return registry, cleanup
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	registry, cleanup := createCaseInsensitiveRegistry(t)
+	defer cleanup()
+
+	helpers.CheckCaseInsensitive(t, registry)
+}
+
+func TestCaseInsensitive_Rename(t *testing.T) {
+	registry, cleanup := createCaseInsensitiveRegistry(t)
+	defer cleanup()
+
+	helpers.CheckRename(t, registry)
+}
+
+func TestDescriptorsParentRestrict(t *testing.T) {
+	registry, cleanup := createRegistry(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	repo := registry.GetDescriptorRepository()
+	root, _ := repo.FindRoot(ctx)
+	dir, err := repo.Create(ctx, root.GetInode(), "dir", db.DT_Dir, db.DescriptorAttrs{Permission: "0755"})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	if _, err = repo.Create(ctx, dir.GetInode(), "file", db.DT_File, db.DescriptorAttrs{Permission: "0644"}); err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+
+	inst := registry.(*sqldb.RepositoryRegistry).Instance
+	if _, err = inst.Exec(`DELETE FROM descriptors WHERE inode = ?`, dir.GetInode()); err == nil {
+		t.Errorf("Deletion of a directory with entries is not as expected.\nExpected: error. Result: %v.\n", err)
+	}
+	if descr, _ := repo.FindSingleByName(ctx, dir.GetInode(), "file"); descr == nil {
+		t.Errorf("Entry of the directory is not as expected.\nExpected: found. Result: %v.\n", descr)
+	}
+}
@@ -0,0 +1,1468 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"bazil.org/fuse"
+	fuseFS "bazil.org/fuse/fs"
+	"bytes"
+	"encoding/binary"
+	"github.com/kos-v/dbunderfs/internal/db"
+	"github.com/kos-v/dbunderfs/internal/db/memory"
+	"github.com/kos-v/dbunderfs/internal/fs"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func createRoot(t *testing.T) fuseFS.Node {
+	storage, err := memory.NewStorage()
+	if err != nil {
+		t.Fatalf("NewStorage fail: %s", err)
+	}
+
+	filesys := &fs.FS{RepositoryRegistry: &memory.RepositoryRegistry{BlockSize: 4, Storage: storage}}
+	root, err := filesys.Root()
+	if err != nil {
+		t.Fatalf("Root fail: %s", err)
+	}
+
+	return root
+}
+
+// hangingRegistry returns a descriptor repository whose lookups block until the context is done,
+// as the queries of an unavailable database do.
+type hangingRegistry struct {
+	db.RepositoryRegistry
+}
+
+func (r *hangingRegistry) GetDescriptorRepository() db.DescriptorRepository {
+	return &hangingDescriptorRepository{r.RepositoryRegistry.GetDescriptorRepository()}
+}
+
+type hangingDescriptorRepository struct {
+	db.DescriptorRepository
+}
+
+func (r *hangingDescriptorRepository) FindSingleByName(ctx context.Context, parent db.Inode, target string) (db.DescriptorInterface, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// failingRegistry returns a data block repository whose writes fail, as the writes of a lost connection do.
+type failingRegistry struct {
+	db.RepositoryRegistry
+}
+
+func (r *failingRegistry) GetDataBlockRepository() db.DataBlockRepository {
+	return &failingDataBlockRepository{r.RepositoryRegistry.GetDataBlockRepository()}
+}
+
+type failingDataBlockRepository struct {
+	db.DataBlockRepository
+}
+
+func (r *failingDataBlockRepository) Write(ctx context.Context, descr db.DescriptorInterface, offset uint64, data []byte) (int, error) {
+	return 0, fuse.EIO
+}
+
+func readDir(t *testing.T, dir fuseFS.Node) []string {
+	dirents, err := dir.(fuseFS.HandleReadDirAller).ReadDirAll(context.Background())
+	if err != nil {
+		t.Fatalf("ReadDirAll fail: %s", err)
+	}
+
+	names := []string{}
+	for _, dirent := range dirents {
+		names = append(names, dirent.Name)
+	}
+
+	return names
+}
+
+func TestFS_FileLifecycle(t *testing.T) {
+	ctx := context.Background()
+	root := createRoot(t)
+
+	dir, err := root.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Name: "dir", Mode: os.ModeDir | 0755})
+	if err != nil {
+		t.Fatalf("Mkdir fail: %s", err)
+	}
+
+	file, handle, err := dir.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "file", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+
+	writeResp := &fuse.WriteResponse{}
+	err = handle.(fuseFS.HandleWriter).Write(ctx, &fuse.WriteRequest{Offset: 2, Data: []byte("hello world")}, writeResp)
+	if err != nil || writeResp.Size != 11 {
+		t.Fatalf("Write fail: %v. Written: %v.", err, writeResp.Size)
+	}
+
+	attr := fuse.Attr{}
+	if err = file.Attr(ctx, &attr); err != nil || attr.Size != 13 || attr.Mode != 0644 {
+		t.Errorf("Attr is not as expected.\nExpected: 13, %v. Result: %v, %v.\n", os.FileMode(0644), attr.Size, attr.Mode)
+	}
+
+	readResp := &fuse.ReadResponse{}
+	if err = handle.(fuseFS.HandleReader).Read(ctx, &fuse.ReadRequest{Offset: 0, Size: 100}, readResp); err != nil {
+		t.Fatalf("Read fail: %s", err)
+	}
+	if expected := "\x00\x00hello world"; string(readResp.Data) != expected {
+		t.Errorf("Read data is not as expected.\nExpected: %q. Result: %q.\n", expected, readResp.Data)
+	}
+
+	err = dir.(fuseFS.NodeRenamer).Rename(ctx, &fuse.RenameRequest{OldName: "file", NewName: "moved"}, root)
+	if err != nil {
+		t.Fatalf("Rename fail: %s", err)
+	}
+	if names := readDir(t, root); len(names) != 2 || names[0] != "dir" || names[1] != "moved" {
+		t.Errorf("Root entries are not as expected.\nExpected: [dir moved]. Result: %v.\n", names)
+	}
+	if names := readDir(t, dir); len(names) != 0 {
+		t.Errorf("Dir entries are not as expected.\nExpected: []. Result: %v.\n", names)
+	}
+
+	moved, err := root.(fuseFS.NodeRequestLookuper).Lookup(ctx, &fuse.LookupRequest{Name: "moved"}, &fuse.LookupResponse{})
+	if err != nil {
+		t.Fatalf("Lookup fail: %s", err)
+	}
+	if err = moved.Attr(ctx, &attr); err != nil || attr.Size != 13 {
+		t.Errorf("Attr of the moved file is not as expected.\nExpected: 13. Result: %v.\n", attr.Size)
+	}
+
+	if err = root.(fuseFS.NodeRemover).Remove(ctx, &fuse.RemoveRequest{Name: "moved"}); err != nil {
+		t.Fatalf("Remove fail: %s", err)
+	}
+	if _, err = root.(fuseFS.NodeRequestLookuper).Lookup(ctx, &fuse.LookupRequest{Name: "moved"}, &fuse.LookupResponse{}); err != fuse.ENOENT {
+		t.Errorf("Lookup of the removed file is not as expected.\nExpected: %v. Result: %v.\n", fuse.ENOENT, err)
+	}
+}
+
+func TestFS_OpTimeout(t *testing.T) {
+	storage, err := memory.NewStorage()
+	if err != nil {
+		t.Fatalf("NewStorage fail: %s", err)
+	}
+	registry := &hangingRegistry{&memory.RepositoryRegistry{BlockSize: 4, Storage: storage}}
+	filesys := &fs.FS{RepositoryRegistry: registry, Options: fs.Options{OpTimeout: 10 * time.Millisecond}}
+	root, err := filesys.Root()
+	if err != nil {
+		t.Fatalf("Root fail: %s", err)
+	}
+	lookuper := root.(fuseFS.NodeRequestLookuper)
+
+	_, err = lookuper.Lookup(context.Background(), &fuse.LookupRequest{Name: "file"}, &fuse.LookupResponse{})
+	if err != fuse.EIO {
+		t.Errorf("Lookup with a timeout is not as expected.\nExpected: %v. Result: %v.\n", fuse.EIO, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = lookuper.Lookup(ctx, &fuse.LookupRequest{Name: "file"}, &fuse.LookupResponse{})
+	if err != fuse.EINTR {
+		t.Errorf("Interrupted lookup is not as expected.\nExpected: %v. Result: %v.\n", fuse.EINTR, err)
+	}
+}
+
+func TestFS_CacheTTL(t *testing.T) {
+	storage, err := memory.NewStorage()
+	if err != nil {
+		t.Fatalf("NewStorage fail: %s", err)
+	}
+	registry := &memory.RepositoryRegistry{BlockSize: 4, Storage: storage}
+	filesys := &fs.FS{
+		RepositoryRegistry: db.NewCachedRepositoryRegistry(registry, 5*time.Second, false),
+		Options:            fs.Options{CacheTTL: 5 * time.Second},
+	}
+	root, err := filesys.Root()
+	if err != nil {
+		t.Fatalf("Root fail: %s", err)
+	}
+
+	ctx := context.Background()
+	_, _, err = root.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "file", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+
+	resp := &fuse.LookupResponse{}
+	file, err := root.(fuseFS.NodeRequestLookuper).Lookup(ctx, &fuse.LookupRequest{Name: "file"}, resp)
+	if err != nil {
+		t.Fatalf("Lookup fail: %s", err)
+	}
+	if resp.EntryValid != 5*time.Second {
+		t.Errorf("EntryValid is not as expected.\nExpected: %v. Result: %v.\n", 5*time.Second, resp.EntryValid)
+	}
+
+	attr := fuse.Attr{}
+	if err = file.Attr(ctx, &attr); err != nil || attr.Valid != 5*time.Second {
+		t.Errorf("Attr.Valid is not as expected.\nExpected: %v. Result: %v.\n", 5*time.Second, attr.Valid)
+	}
+}
+
+func TestFS_WriteBuffer(t *testing.T) {
+	storage, err := memory.NewStorage()
+	if err != nil {
+		t.Fatalf("NewStorage fail: %s", err)
+	}
+	registry := &memory.RepositoryRegistry{BlockSize: 4, Storage: storage}
+	filesys := &fs.FS{RepositoryRegistry: registry, Options: fs.Options{WriteBuffer: 8}}
+	root, err := filesys.Root()
+	if err != nil {
+		t.Fatalf("Root fail: %s", err)
+	}
+
+	ctx := context.Background()
+	file, handle, err := root.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "file", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	descr, _ := registry.GetDescriptorRepository().FindSingleByName(ctx, 1, "file")
+	stored := func() string {
+		data, err := registry.GetDataBlockRepository().Read(ctx, descr, 0, 100)
+		if err != nil {
+			t.Fatalf("Read fail: %s", err)
+		}
+		return string(data)
+	}
+
+	writer := handle.(fuseFS.HandleWriter)
+	for _, req := range []*fuse.WriteRequest{{Offset: 3, Data: []byte("def")}, {Offset: 0, Data: []byte("abc")}} {
+		if err = writer.Write(ctx, req, &fuse.WriteResponse{}); err != nil {
+			t.Fatalf("Write fail: %s", err)
+		}
+	}
+	if data := stored(); data != "" {
+		t.Errorf("Stored data before flush is not as expected.\nExpected: %q. Result: %q.\n", "", data)
+	}
+	attr := fuse.Attr{}
+	if err = file.Attr(ctx, &attr); err != nil || attr.Size != 6 {
+		t.Errorf("Size of buffered file is not as expected.\nExpected: %v. Result: %v.\n", 6, attr.Size)
+	}
+
+	readResp := &fuse.ReadResponse{}
+	if err = handle.(fuseFS.HandleReader).Read(ctx, &fuse.ReadRequest{Offset: 0, Size: 100}, readResp); err != nil {
+		t.Fatalf("Read fail: %s", err)
+	}
+	if string(readResp.Data) != "abcdef" {
+		t.Errorf("Read data is not as expected.\nExpected: %q. Result: %q.\n", "abcdef", readResp.Data)
+	}
+
+	if err = writer.Write(ctx, &fuse.WriteRequest{Offset: 6, Data: []byte("0123456789")}, &fuse.WriteResponse{}); err != nil {
+		t.Fatalf("Write fail: %s", err)
+	}
+	if data := stored(); data != "abcdef0123456789" {
+		t.Errorf("Stored data after exceeding the buffer is not as expected.\nExpected: %q. Result: %q.\n", "abcdef0123456789", data)
+	}
+
+	if err = writer.Write(ctx, &fuse.WriteRequest{Offset: 0, Data: []byte("ABC")}, &fuse.WriteResponse{}); err != nil {
+		t.Fatalf("Write fail: %s", err)
+	}
+	if err = handle.(fuseFS.HandleFlusher).Flush(ctx, &fuse.FlushRequest{}); err != nil {
+		t.Fatalf("Flush fail: %s", err)
+	}
+	if data := stored(); data != "ABCdef0123456789" {
+		t.Errorf("Stored data after flush is not as expected.\nExpected: %q. Result: %q.\n", "ABCdef0123456789", data)
+	}
+}
+
+func TestFS_WriteBufferSequential(t *testing.T) {
+	storage, err := memory.NewStorage()
+	if err != nil {
+		t.Fatalf("NewStorage fail: %s", err)
+	}
+	registry := &memory.RepositoryRegistry{BlockSize: 4, Storage: storage}
+	filesys := &fs.FS{RepositoryRegistry: registry, Options: fs.Options{WriteBuffer: 1 << 20}}
+	root, err := filesys.Root()
+	if err != nil {
+		t.Fatalf("Root fail: %s", err)
+	}
+
+	ctx := context.Background()
+	_, handle, err := root.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "file", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+
+	writes := []struct {
+		offset int64
+		data   string
+	}{
+		{0, "abcd"},
+		{4, "efgh"},
+		{6, "GHIJ"},
+		{20, "uv"},
+		{22, "wx"},
+		{21, "VWXY"},
+		{10, "klmnopqrst"},
+	}
+	for _, w := range writes {
+		if err = handle.(fuseFS.HandleWriter).Write(ctx, &fuse.WriteRequest{Offset: w.offset, Data: []byte(w.data)}, &fuse.WriteResponse{}); err != nil {
+			t.Fatalf("Write fail: %s", err)
+		}
+	}
+	if err = handle.(fuseFS.HandleFlusher).Flush(ctx, &fuse.FlushRequest{}); err != nil {
+		t.Fatalf("Flush fail: %s", err)
+	}
+
+	descr, _ := registry.GetDescriptorRepository().FindSingleByName(ctx, 1, "file")
+	data, err := registry.GetDataBlockRepository().Read(ctx, descr, 0, 100)
+	if err != nil {
+		t.Fatalf("Read fail: %s", err)
+	}
+	if expected := "abcdefGHIJklmnopqrstuVWXY"; string(data) != expected {
+		t.Errorf("Stored data is not as expected.\nExpected: %q. Result: %q.\n", expected, data)
+	}
+}
+
+func TestFS_WriteBufferError(t *testing.T) {
+	storage, err := memory.NewStorage()
+	if err != nil {
+		t.Fatalf("NewStorage fail: %s", err)
+	}
+	registry := &failingRegistry{&memory.RepositoryRegistry{BlockSize: 4, Storage: storage}}
+	filesys := &fs.FS{RepositoryRegistry: registry, Options: fs.Options{WriteBuffer: 8}}
+	root, err := filesys.Root()
+	if err != nil {
+		t.Fatalf("Root fail: %s", err)
+	}
+
+	ctx := context.Background()
+	file, handle, err := root.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "file", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+
+	if err = handle.(fuseFS.HandleWriter).Write(ctx, &fuse.WriteRequest{Data: []byte("abc")}, &fuse.WriteResponse{}); err != nil {
+		t.Fatalf("Write fail: %s", err)
+	}
+	if err = file.(fuseFS.NodeFsyncer).Fsync(ctx, &fuse.FsyncRequest{}); err != fuse.EIO {
+		t.Errorf("Fsync error is not as expected.\nExpected: %v. Result: %v.\n", fuse.EIO, err)
+	}
+	if err = handle.(fuseFS.HandleFlusher).Flush(ctx, &fuse.FlushRequest{}); err != fuse.EIO {
+		t.Errorf("Flush error after failed fsync is not as expected.\nExpected: %v. Result: %v.\n", fuse.EIO, err)
+	}
+	if err = handle.(fuseFS.HandleFlusher).Flush(ctx, &fuse.FlushRequest{}); err != nil {
+		t.Errorf("Repeated flush error is not as expected.\nExpected: %v. Result: %v.\n", nil, err)
+	}
+}
+
+func TestFS_OpenFlags(t *testing.T) {
+	ctx := context.Background()
+	root := createRoot(t)
+	creater := root.(fuseFS.NodeCreater)
+
+	file, handle, err := creater.Create(ctx, &fuse.CreateRequest{Name: "file", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	if err = handle.(fuseFS.HandleWriter).Write(ctx, &fuse.WriteRequest{Data: []byte("hello")}, &fuse.WriteResponse{}); err != nil {
+		t.Fatalf("Write fail: %s", err)
+	}
+
+	open := func(flags fuse.OpenFlags) fuseFS.Handle {
+		handle, err := file.(fuseFS.NodeOpener).Open(ctx, &fuse.OpenRequest{Flags: flags}, &fuse.OpenResponse{})
+		if err != nil {
+			t.Fatalf("Open fail: %s", err)
+		}
+		return handle
+	}
+	read := func() string {
+		resp := &fuse.ReadResponse{}
+		if err := open(fuse.OpenReadOnly).(fuseFS.HandleReader).Read(ctx, &fuse.ReadRequest{Size: 100}, resp); err != nil {
+			t.Fatalf("Read fail: %s", err)
+		}
+		return string(resp.Data)
+	}
+
+	err = open(fuse.OpenReadOnly).(fuseFS.HandleWriter).Write(ctx, &fuse.WriteRequest{Data: []byte("x")}, &fuse.WriteResponse{})
+	if err != fuse.Errno(syscall.EBADF) {
+		t.Errorf("Write to read-only handle is not as expected.\nExpected: %v. Result: %v.\n", syscall.EBADF, err)
+	}
+
+	if data := read(); data != "hello" {
+		t.Errorf("Data before truncate is not as expected.\nExpected: %q. Result: %q.\n", "hello", data)
+	}
+	truncated := open(fuse.OpenWriteOnly | fuse.OpenTruncate)
+	if data := read(); data != "" {
+		t.Errorf("Data after O_TRUNC is not as expected.\nExpected: %q. Result: %q.\n", "", data)
+	}
+	if err = truncated.(fuseFS.HandleWriter).Write(ctx, &fuse.WriteRequest{Data: []byte("abc")}, &fuse.WriteResponse{}); err != nil {
+		t.Fatalf("Write fail: %s", err)
+	}
+
+	err = open(fuse.OpenWriteOnly|fuse.OpenAppend).(fuseFS.HandleWriter).Write(ctx, &fuse.WriteRequest{Offset: 0, Data: []byte("de")}, &fuse.WriteResponse{})
+	if err != nil {
+		t.Fatalf("Write fail: %s", err)
+	}
+	if data := read(); data != "abcde" {
+		t.Errorf("Data after O_APPEND is not as expected.\nExpected: %q. Result: %q.\n", "abcde", data)
+	}
+
+	_, _, err = creater.Create(ctx, &fuse.CreateRequest{Name: "file", Mode: 0644, Flags: fuse.OpenReadWrite | fuse.OpenExclusive}, &fuse.CreateResponse{})
+	if err != fuse.EEXIST {
+		t.Errorf("Create with O_EXCL is not as expected.\nExpected: %v. Result: %v.\n", fuse.EEXIST, err)
+	}
+	_, _, err = creater.Create(ctx, &fuse.CreateRequest{Name: "file", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	if err != nil {
+		t.Errorf("Create of existing file is not as expected.\nExpected: %v. Result: %v.\n", nil, err)
+	}
+}
+
+func TestFS_Errno(t *testing.T) {
+	ctx := context.Background()
+	root := createRoot(t)
+	longName := strings.Repeat("n", db.MaxNameLength+1)
+
+	dir, err := root.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Name: "dir", Mode: os.ModeDir | 0755})
+	if err != nil {
+		t.Fatalf("Mkdir fail: %s", err)
+	}
+	_, _, err = dir.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "file", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+
+	tests := []struct {
+		op       func() error
+		expected error
+	}{
+		{func() error {
+			_, err := root.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Name: "dir", Mode: os.ModeDir | 0755})
+			return err
+		}, fuse.EEXIST},
+		{func() error {
+			_, err := root.(fuseFS.NodeSymlinker).Symlink(ctx, &fuse.SymlinkRequest{NewName: "dir", Target: "target"})
+			return err
+		}, fuse.EEXIST},
+		{func() error {
+			_, err := root.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Name: longName, Mode: os.ModeDir | 0755})
+			return err
+		}, fuse.Errno(syscall.ENAMETOOLONG)},
+		{func() error {
+			_, _, err := root.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "dir", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+			return err
+		}, fuse.Errno(syscall.EISDIR)},
+		{func() error {
+			return root.(fuseFS.NodeRemover).Remove(ctx, &fuse.RemoveRequest{Name: "missing"})
+		}, fuse.ENOENT},
+		{func() error {
+			return root.(fuseFS.NodeRemover).Remove(ctx, &fuse.RemoveRequest{Name: "dir", Dir: true})
+		}, fuse.Errno(syscall.ENOTEMPTY)},
+		{func() error {
+			return root.(fuseFS.NodeRemover).Remove(ctx, &fuse.RemoveRequest{Name: "dir"})
+		}, fuse.Errno(syscall.EISDIR)},
+		{func() error {
+			return dir.(fuseFS.NodeRemover).Remove(ctx, &fuse.RemoveRequest{Name: "file", Dir: true})
+		}, fuse.Errno(syscall.ENOTDIR)},
+	}
+
+	for id, test := range tests {
+		id += 1
+		if err := test.op(); err != test.expected {
+			t.Errorf("Test %v fail: errno is not as expected.\nExpected: %v. Result: %v.\n", id, test.expected, err)
+		}
+	}
+}
+
+func TestFS_Rename(t *testing.T) {
+	ctx := context.Background()
+	root := createRoot(t)
+
+	mkdir := func(parent fuseFS.Node, name string) fuseFS.Node {
+		dir, err := parent.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Name: name, Mode: os.ModeDir | 0755})
+		if err != nil {
+			t.Fatalf("Mkdir fail: %s", err)
+		}
+		return dir
+	}
+	create := func(parent fuseFS.Node, name string, data string) fuseFS.Node {
+		file, handle, err := parent.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: name, Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+		if err != nil {
+			t.Fatalf("Create fail: %s", err)
+		}
+		if err = handle.(fuseFS.HandleWriter).Write(ctx, &fuse.WriteRequest{Data: []byte(data)}, &fuse.WriteResponse{}); err != nil {
+			t.Fatalf("Write fail: %s", err)
+		}
+		return file
+	}
+	src := mkdir(root, "src")
+	dst := mkdir(root, "dst")
+	sub := mkdir(src, "sub")
+	full := mkdir(dst, "full")
+	create(src, "new", "new data")
+	create(dst, "current", "current")
+	create(full, "child", "")
+
+	if err := src.(fuseFS.NodeRenamer).Rename(ctx, &fuse.RenameRequest{OldName: "new", NewName: "current"}, dst); err != nil {
+		t.Fatalf("Rename fail: %s", err)
+	}
+	replaced, err := dst.(fuseFS.NodeRequestLookuper).Lookup(ctx, &fuse.LookupRequest{Name: "current"}, &fuse.LookupResponse{})
+	if err != nil {
+		t.Fatalf("Lookup fail: %s", err)
+	}
+	attr := fuse.Attr{}
+	if err = replaced.Attr(ctx, &attr); err != nil || attr.Size != uint64(len("new data")) {
+		t.Errorf("Size of the replaced target is not as expected.\nExpected: %v. Result: %v.\n", len("new data"), attr.Size)
+	}
+	if names := readDir(t, src); len(names) != 1 || names[0] != "sub" {
+		t.Errorf("Source entries are not as expected.\nExpected: [sub]. Result: %v.\n", names)
+	}
+
+	tests := []struct {
+		dir      fuseFS.Node
+		oldName  string
+		newDir   fuseFS.Node
+		newName  string
+		expected error
+	}{
+		{root, "src", sub, "moved", fuse.Errno(syscall.EINVAL)},
+		{root, "src", src, "moved", fuse.Errno(syscall.EINVAL)},
+		{src, "sub", dst, "full", fuse.Errno(syscall.ENOTEMPTY)},
+		{dst, "current", dst, "full", fuse.Errno(syscall.EISDIR)},
+		{dst, "full", dst, "current", fuse.Errno(syscall.ENOTDIR)},
+		{src, "missing", dst, "moved", fuse.ENOENT},
+		{dst, "current", replaced, "moved", fuse.Errno(syscall.ENOTDIR)},
+	}
+
+	for id, test := range tests {
+		id += 1
+		err := test.dir.(fuseFS.NodeRenamer).Rename(ctx, &fuse.RenameRequest{OldName: test.oldName, NewName: test.newName}, test.newDir)
+		if err != test.expected {
+			t.Errorf("Test %v fail: errno is not as expected.\nExpected: %v. Result: %v.\n", id, test.expected, err)
+		}
+	}
+
+	if names := readDir(t, full); len(names) != 1 || names[0] != "child" {
+		t.Errorf("Entries of the refused target are not as expected.\nExpected: [child]. Result: %v.\n", names)
+	}
+}
+
+func TestFS_Link(t *testing.T) {
+	ctx := context.Background()
+	root := createRoot(t)
+
+	dir, err := root.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Name: "dir", Mode: os.ModeDir | 0755})
+	if err != nil {
+		t.Fatalf("Mkdir fail: %s", err)
+	}
+	file, handle, err := root.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "file", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	if err = handle.(fuseFS.HandleWriter).Write(ctx, &fuse.WriteRequest{Data: []byte("data")}, &fuse.WriteResponse{}); err != nil {
+		t.Fatalf("Write fail: %s", err)
+	}
+	origin := fuse.Attr{}
+	if err = file.Attr(ctx, &origin); err != nil {
+		t.Fatalf("Attr fail: %s", err)
+	}
+
+	if _, err = dir.(fuseFS.NodeLinker).Link(ctx, &fuse.LinkRequest{NewName: "link"}, file); err != nil {
+		t.Fatalf("Link fail: %s", err)
+	}
+	if _, err = root.(fuseFS.NodeLinker).Link(ctx, &fuse.LinkRequest{NewName: "dirlink"}, dir); err != fuse.EPERM {
+		t.Errorf("Link of a directory errno is not as expected.\nExpected: %v. Result: %v.\n", fuse.EPERM, err)
+	}
+
+	tests := []struct {
+		remove        string
+		lookupDir     fuseFS.Node
+		lookupName    string
+		expectedNlink uint32
+	}{
+		{"", root, "file", 2},
+		{"", dir, "link", 2},
+		{"file", dir, "link", 1},
+	}
+
+	for id, test := range tests {
+		id += 1
+		if test.remove != "" {
+			if err := root.(fuseFS.NodeRemover).Remove(ctx, &fuse.RemoveRequest{Name: test.remove}); err != nil {
+				t.Fatalf("Test %v fail: %s", id, err)
+			}
+		}
+
+		node, err := test.lookupDir.(fuseFS.NodeRequestLookuper).Lookup(ctx, &fuse.LookupRequest{Name: test.lookupName}, &fuse.LookupResponse{})
+		if err != nil {
+			t.Fatalf("Test %v fail: %s", id, err)
+		}
+		attr := fuse.Attr{}
+		if err = node.Attr(ctx, &attr); err != nil {
+			t.Fatalf("Test %v fail: %s", id, err)
+		}
+		if attr.Inode != origin.Inode || attr.Nlink != test.expectedNlink || attr.Size != 4 {
+			t.Errorf("Test %v fail: attr is not as expected.\nExpected: %v, %v, 4. Result: %v, %v, %v.\n", id, origin.Inode, test.expectedNlink, attr.Inode, attr.Nlink, attr.Size)
+		}
+	}
+
+	if _, err = root.(fuseFS.NodeRequestLookuper).Lookup(ctx, &fuse.LookupRequest{Name: "file"}, &fuse.LookupResponse{}); err != fuse.ENOENT {
+		t.Errorf("Lookup of the removed entry is not as expected.\nExpected: %v. Result: %v.\n", fuse.ENOENT, err)
+	}
+}
+
+func TestFS_Symlink(t *testing.T) {
+	ctx := context.Background()
+	root := createRoot(t)
+
+	created, err := root.(fuseFS.NodeSymlinker).Symlink(ctx, &fuse.SymlinkRequest{NewName: "link", Target: "dir/file"})
+	if err != nil {
+		t.Fatalf("Symlink fail: %s", err)
+	}
+	if _, err = root.(fuseFS.NodeLinker).Link(ctx, &fuse.LinkRequest{NewName: "hard"}, created); err != nil {
+		t.Fatalf("Link fail: %s", err)
+	}
+
+	for id, name := range []string{"link", "hard"} {
+		id += 1
+		node, err := root.(fuseFS.NodeRequestLookuper).Lookup(ctx, &fuse.LookupRequest{Name: name}, &fuse.LookupResponse{})
+		if err != nil {
+			t.Fatalf("Test %v fail: %s", id, err)
+		}
+		readlinker, ok := node.(fuseFS.NodeReadlinker)
+		if !ok {
+			t.Fatalf("Test %v fail: node is not as expected.\nExpected: %v. Result: %T.\n", id, "NodeReadlinker", node)
+		}
+		target, err := readlinker.Readlink(ctx, &fuse.ReadlinkRequest{})
+		if err != nil || target != "dir/file" {
+			t.Errorf("Test %v fail: target is not as expected.\nExpected: %q. Result: %q, %v.\n", id, "dir/file", target, err)
+		}
+
+		attr := fuse.Attr{}
+		if err = node.Attr(ctx, &attr); err != nil {
+			t.Fatalf("Test %v fail: %s", id, err)
+		}
+		if attr.Mode != os.ModeSymlink|0777 || attr.Size != uint64(len("dir/file")) || attr.Nlink != 2 {
+			t.Errorf("Test %v fail: attr is not as expected.\nExpected: %v, %v, 2. Result: %v, %v, %v.\n", id, os.ModeSymlink|0777, len("dir/file"), attr.Mode, attr.Size, attr.Nlink)
+		}
+	}
+
+	dirents, err := root.(fuseFS.HandleReadDirAller).ReadDirAll(ctx)
+	if err != nil {
+		t.Fatalf("ReadDirAll fail: %s", err)
+	}
+	for _, dirent := range dirents {
+		if dirent.Type != fuse.DT_Link {
+			t.Errorf("Type of %s is not as expected.\nExpected: %v. Result: %v.\n", dirent.Name, fuse.DT_Link, dirent.Type)
+		}
+	}
+
+	err = created.(fuseFS.NodeSetattrer).Setattr(ctx, &fuse.SetattrRequest{Valid: fuse.SetattrSize, Size: 1}, &fuse.SetattrResponse{})
+	if err != fuse.EPERM {
+		t.Errorf("Truncate of a symlink errno is not as expected.\nExpected: %v. Result: %v.\n", fuse.EPERM, err)
+	}
+}
+
+// The flags of setxattr(2).
+const (
+	xattrCreate  = 0x1
+	xattrReplace = 0x2
+)
+
+func TestFS_Xattr(t *testing.T) {
+	ctx := context.Background()
+	root := createRoot(t)
+
+	file, _, err := root.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "file", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	link, err := root.(fuseFS.NodeSymlinker).Symlink(ctx, &fuse.SymlinkRequest{NewName: "link", Target: "file"})
+	if err != nil {
+		t.Fatalf("Symlink fail: %s", err)
+	}
+
+	for id, node := range []fuseFS.Node{root, file} {
+		id += 1
+		setxattr := func(name string, value string, flags uint32) error {
+			return node.(fuseFS.NodeSetxattrer).Setxattr(ctx, &fuse.SetxattrRequest{Name: name, Xattr: []byte(value), Flags: flags})
+		}
+		getxattr := func(name string) (string, error) {
+			resp := &fuse.GetxattrResponse{}
+			err := node.(fuseFS.NodeGetxattrer).Getxattr(ctx, &fuse.GetxattrRequest{Name: name}, resp)
+			return string(resp.Xattr), err
+		}
+
+		steps := []struct {
+			err      error
+			expected error
+		}{
+			{setxattr("user.tag", "first", xattrCreate), nil},
+			{setxattr("user.tag", "second", xattrCreate), fuse.EEXIST},
+			{setxattr("user.missing", "value", xattrReplace), fuse.ErrNoXattr},
+			{setxattr("user.tag", "third", xattrReplace), nil},
+			{setxattr("user.other", "value", 0), nil},
+			{node.(fuseFS.NodeRemovexattrer).Removexattr(ctx, &fuse.RemovexattrRequest{Name: "user.other"}), nil},
+			{node.(fuseFS.NodeRemovexattrer).Removexattr(ctx, &fuse.RemovexattrRequest{Name: "user.other"}), fuse.ErrNoXattr},
+		}
+		for step, test := range steps {
+			if test.err != test.expected {
+				t.Errorf("Test %v fail: errno of step %v is not as expected.\nExpected: %v. Result: %v.\n", id, step+1, test.expected, test.err)
+			}
+		}
+
+		if value, err := getxattr("user.tag"); err != nil || value != "third" {
+			t.Errorf("Test %v fail: value is not as expected.\nExpected: %q. Result: %q, %v.\n", id, "third", value, err)
+		}
+		if _, err := getxattr("user.other"); err != fuse.ErrNoXattr {
+			t.Errorf("Test %v fail: errno of a removed attribute is not as expected.\nExpected: %v. Result: %v.\n", id, fuse.ErrNoXattr, err)
+		}
+
+		resp := &fuse.ListxattrResponse{}
+		if err := node.(fuseFS.NodeListxattrer).Listxattr(ctx, &fuse.ListxattrRequest{}, resp); err != nil {
+			t.Fatalf("Test %v fail: %s", id, err)
+		}
+		if expected := "user.tag\x00"; string(resp.Xattr) != expected {
+			t.Errorf("Test %v fail: names are not as expected.\nExpected: %q. Result: %q.\n", id, expected, resp.Xattr)
+		}
+	}
+
+	// Linux allows the user attributes only on regular files and directories.
+	symlinkSteps := []struct {
+		err      error
+		expected error
+	}{
+		{link.(fuseFS.NodeSetxattrer).Setxattr(ctx, &fuse.SetxattrRequest{Name: "user.tag", Xattr: []byte("value")}), fuse.EPERM},
+		{link.(fuseFS.NodeGetxattrer).Getxattr(ctx, &fuse.GetxattrRequest{Name: "user.tag"}, &fuse.GetxattrResponse{}), fuse.ErrNoXattr},
+		{link.(fuseFS.NodeRemovexattrer).Removexattr(ctx, &fuse.RemovexattrRequest{Name: "user.tag"}), fuse.EPERM},
+		{link.(fuseFS.NodeSetxattrer).Setxattr(ctx, &fuse.SetxattrRequest{Name: "trusted.tag", Xattr: []byte("value")}), nil},
+		{link.(fuseFS.NodeGetxattrer).Getxattr(ctx, &fuse.GetxattrRequest{Name: "trusted.tag"}, &fuse.GetxattrResponse{}), nil},
+	}
+	for id, test := range symlinkSteps {
+		id += 1
+		if test.err != test.expected {
+			t.Errorf("Test %v fail: errno of the symlink attribute is not as expected.\nExpected: %v. Result: %v.\n", id, test.expected, test.err)
+		}
+	}
+
+	if err := file.(fuseFS.NodeSetxattrer).Setxattr(ctx, &fuse.SetxattrRequest{Name: "trusted.tag", Xattr: []byte("value")}); err != nil {
+		t.Fatalf("Setxattr fail: %s", err)
+	}
+	listed := []struct {
+		header   fuse.Header
+		expected string
+	}{
+		{fuse.Header{}, "trusted.tag\x00user.tag\x00"},
+		{fuse.Header{Uid: 1000, Gid: 1000}, "user.tag\x00"},
+	}
+	for id, test := range listed {
+		id += 1
+		resp := &fuse.ListxattrResponse{}
+		if err := file.(fuseFS.NodeListxattrer).Listxattr(ctx, &fuse.ListxattrRequest{Header: test.header}, resp); err != nil {
+			t.Fatalf("Test %v fail: %s", id, err)
+		}
+		if string(resp.Xattr) != test.expected {
+			t.Errorf("Test %v fail: listed names are not as expected.\nExpected: %q. Result: %q.\n", id, test.expected, resp.Xattr)
+		}
+	}
+}
+
+func TestFS_Atime(t *testing.T) {
+	ctx := context.Background()
+	old := time.Unix(1000, 0)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		mode            fs.AtimeMode
+		setAtime        time.Time
+		expectedUpdated bool
+	}{
+		{fs.AtimeStrict, future, true},
+		{fs.AtimeStrict, old, true},
+		{fs.AtimeRelative, future, false},
+		{fs.AtimeRelative, old, true},
+		{fs.AtimeNone, future, false},
+		{fs.AtimeNone, old, false},
+	}
+
+	for id, test := range tests {
+		id += 1
+		storage, err := memory.NewStorage()
+		if err != nil {
+			t.Fatalf("NewStorage fail: %s", err)
+		}
+		filesys := &fs.FS{RepositoryRegistry: &memory.RepositoryRegistry{BlockSize: 4, Storage: storage}, Options: fs.Options{Atime: test.mode}}
+		root, err := filesys.Root()
+		if err != nil {
+			t.Fatalf("Root fail: %s", err)
+		}
+
+		file, handle, err := root.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "file", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+		if err != nil {
+			t.Fatalf("Test %v fail: %s", id, err)
+		}
+		req := &fuse.SetattrRequest{Valid: fuse.SetattrAtime | fuse.SetattrMtime, Atime: test.setAtime, Mtime: old}
+		if err = file.(fuseFS.NodeSetattrer).Setattr(ctx, req, &fuse.SetattrResponse{}); err != nil {
+			t.Fatalf("Test %v fail: %s", id, err)
+		}
+
+		started := time.Now()
+		if err = handle.(fuseFS.HandleReader).Read(ctx, &fuse.ReadRequest{Size: 10}, &fuse.ReadResponse{}); err != nil {
+			t.Fatalf("Test %v fail: %s", id, err)
+		}
+		attr := fuse.Attr{}
+		if err = file.Attr(ctx, &attr); err != nil {
+			t.Fatalf("Test %v fail: %s", id, err)
+		}
+		if !attr.Mtime.Equal(old) {
+			t.Errorf("Test %v fail: mtime is not as expected.\nExpected: %v. Result: %v.\n", id, old, attr.Mtime)
+		}
+		if updated := !attr.Atime.Equal(test.setAtime); updated != test.expectedUpdated || updated && attr.Atime.Before(started) {
+			t.Errorf("Test %v fail: atime after read is not as expected.\nExpected updated: %v. Result: %v.\n", id, test.expectedUpdated, attr.Atime)
+		}
+	}
+}
+
+func TestParseAtimeMode(t *testing.T) {
+	tests := []struct {
+		mode          string
+		expectedMode  fs.AtimeMode
+		expectedError bool
+	}{
+		{"strict", fs.AtimeStrict, false},
+		{"relatime", fs.AtimeRelative, false},
+		{"noatime", fs.AtimeNone, false},
+		{"", "", true},
+		{"atime", "", true},
+	}
+
+	for id, test := range tests {
+		id += 1
+		mode, err := fs.ParseAtimeMode(test.mode)
+		if mode != test.expectedMode || (err != nil) != test.expectedError {
+			t.Errorf("Test %v fail: mode is not as expected.\nExpected: %q, error %v. Result: %q, %v.\n", id, test.expectedMode, test.expectedError, mode, err)
+		}
+	}
+}
+
+func TestFS_Names(t *testing.T) {
+	ctx := context.Background()
+	root := createRoot(t)
+
+	tests := []struct {
+		name     string
+		expected error
+	}{
+		{"Файл 🙂", nil},
+		{"файл 🙂", nil},
+		{strings.Repeat("я", db.MaxNameLength/2), nil},
+		{strings.Repeat("я", db.MaxNameLength/2+1), fuse.Errno(syscall.ENAMETOOLONG)},
+		{"a/b", fuse.Errno(syscall.EINVAL)},
+		{"a\x00b", fuse.Errno(syscall.EINVAL)},
+		{"a\xffb", fuse.Errno(syscall.EILSEQ)},
+	}
+
+	for id, test := range tests {
+		id += 1
+		_, err := root.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Name: test.name, Mode: os.ModeDir | 0755})
+		if err != test.expected {
+			t.Errorf("Test %v fail: errno is not as expected.\nExpected: %v. Result: %v.\n", id, test.expected, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if _, err = root.(fuseFS.NodeRequestLookuper).Lookup(ctx, &fuse.LookupRequest{Name: test.name}, &fuse.LookupResponse{}); err != nil {
+			t.Errorf("Test %v fail: created entry is not found: %s", id, err)
+		}
+	}
+
+	if _, err := root.(fuseFS.NodeRequestLookuper).Lookup(ctx, &fuse.LookupRequest{Name: "ФАЙЛ 🙂"}, &fuse.LookupResponse{}); err != fuse.ENOENT {
+		t.Errorf("Lookup of a name differing in case is not failed.\nExpected: %v. Result: %v.\n", fuse.ENOENT, err)
+	}
+}
+
+func TestFS_Permissions(t *testing.T) {
+	ctx := context.Background()
+	root := createRoot(t)
+	owner := fuse.Header{Uid: 1000, Gid: 1000}
+	member := fuse.Header{Uid: 2000, Gid: 1000}
+	other := fuse.Header{Uid: 3000, Gid: 3000}
+
+	err := root.(fuseFS.NodeSetattrer).Setattr(ctx, &fuse.SetattrRequest{Valid: fuse.SetattrMode, Mode: os.ModeDir | os.ModeSticky | 0777}, &fuse.SetattrResponse{})
+	if err != nil {
+		t.Fatalf("Setattr fail: %s", err)
+	}
+	private, err := root.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Header: owner, Name: "private", Mode: os.ModeDir | 0750})
+	if err != nil {
+		t.Fatalf("Mkdir fail: %s", err)
+	}
+	file, _, err := private.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Header: owner, Name: "file", Mode: 0640, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+
+	tests := []struct {
+		op       func() error
+		expected error
+	}{
+		{func() error {
+			_, err := private.(fuseFS.NodeRequestLookuper).Lookup(ctx, &fuse.LookupRequest{Header: member, Name: "file"}, &fuse.LookupResponse{})
+			return err
+		}, nil},
+		{func() error {
+			_, err := private.(fuseFS.NodeRequestLookuper).Lookup(ctx, &fuse.LookupRequest{Header: other, Name: "file"}, &fuse.LookupResponse{})
+			return err
+		}, fuse.Errno(syscall.EACCES)},
+		{func() error {
+			_, err := private.(fuseFS.NodeOpener).Open(ctx, &fuse.OpenRequest{Header: other, Dir: true, Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+			return err
+		}, fuse.Errno(syscall.EACCES)},
+		{func() error {
+			_, err := private.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Header: member, Name: "dir", Mode: os.ModeDir | 0755})
+			return err
+		}, fuse.Errno(syscall.EACCES)},
+		{func() error {
+			_, err := file.(fuseFS.NodeOpener).Open(ctx, &fuse.OpenRequest{Header: member, Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+			return err
+		}, nil},
+		{func() error {
+			_, err := file.(fuseFS.NodeOpener).Open(ctx, &fuse.OpenRequest{Header: member, Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+			return err
+		}, fuse.Errno(syscall.EACCES)},
+		{func() error {
+			_, _, err := private.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Header: member, Name: "file", Mode: 0644, Flags: fuse.OpenWriteOnly}, &fuse.CreateResponse{})
+			return err
+		}, fuse.Errno(syscall.EACCES)},
+		{func() error {
+			return file.(fuseFS.NodeAccesser).Access(ctx, &fuse.AccessRequest{Header: owner, Mask: 6})
+		}, nil},
+		{func() error {
+			return file.(fuseFS.NodeAccesser).Access(ctx, &fuse.AccessRequest{Header: member, Mask: 2})
+		}, fuse.Errno(syscall.EACCES)},
+		{func() error {
+			return file.(fuseFS.NodeAccesser).Access(ctx, &fuse.AccessRequest{Mask: 1})
+		}, fuse.Errno(syscall.EACCES)},
+		{func() error {
+			return file.(fuseFS.NodeSetattrer).Setattr(ctx, &fuse.SetattrRequest{Header: member, Valid: fuse.SetattrMode, Mode: 0666}, &fuse.SetattrResponse{})
+		}, fuse.EPERM},
+		{func() error {
+			return file.(fuseFS.NodeSetattrer).Setattr(ctx, &fuse.SetattrRequest{Header: owner, Valid: fuse.SetattrUid, Uid: 2000}, &fuse.SetattrResponse{})
+		}, fuse.EPERM},
+		{func() error {
+			return file.(fuseFS.NodeSetattrer).Setattr(ctx, &fuse.SetattrRequest{Header: member, Valid: fuse.SetattrSize}, &fuse.SetattrResponse{})
+		}, fuse.Errno(syscall.EACCES)},
+		{func() error {
+			return file.(fuseFS.NodeSetxattrer).Setxattr(ctx, &fuse.SetxattrRequest{Header: member, Name: "user.tag", Xattr: []byte("1")})
+		}, fuse.Errno(syscall.EACCES)},
+		{func() error {
+			return root.(fuseFS.NodeRemover).Remove(ctx, &fuse.RemoveRequest{Header: other, Name: "private", Dir: true})
+		}, fuse.EPERM},
+		{func() error {
+			return root.(fuseFS.NodeRenamer).Rename(ctx, &fuse.RenameRequest{Header: other, OldName: "private", NewName: "renamed"}, root)
+		}, fuse.EPERM},
+		{func() error {
+			return file.(fuseFS.NodeSetattrer).Setattr(ctx, &fuse.SetattrRequest{Header: owner, Valid: fuse.SetattrMode, Mode: 0600}, &fuse.SetattrResponse{})
+		}, nil},
+		{func() error {
+			_, err := file.(fuseFS.NodeOpener).Open(ctx, &fuse.OpenRequest{Header: member, Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+			return err
+		}, fuse.Errno(syscall.EACCES)},
+	}
+
+	for id, test := range tests {
+		id += 1
+		if err := test.op(); err != test.expected {
+			t.Errorf("Test %v fail: errno is not as expected.\nExpected: %v. Result: %v.\n", id, test.expected, err)
+		}
+	}
+
+	if err := private.(fuseFS.NodeRemover).Remove(ctx, &fuse.RemoveRequest{Header: owner, Name: "file"}); err != nil {
+		t.Errorf("Remove by owner fail: %s", err)
+	}
+	if err := root.(fuseFS.NodeRemover).Remove(ctx, &fuse.RemoveRequest{Header: owner, Name: "private", Dir: true}); err != nil {
+		t.Errorf("Remove from sticky directory by owner fail: %s", err)
+	}
+}
+
+func TestFS_PermissionsSupplementaryGroups(t *testing.T) {
+	groups, err := os.Getgroups()
+	if err != nil || len(groups) == 0 {
+		t.Skip("The process has no supplementary groups")
+	}
+
+	ctx := context.Background()
+	root := createRoot(t)
+	file, _, err := root.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "file", Mode: 0640, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	err = file.(fuseFS.NodeSetattrer).Setattr(ctx, &fuse.SetattrRequest{Valid: fuse.SetattrUid | fuse.SetattrGid, Uid: 1000, Gid: uint32(groups[0])}, &fuse.SetattrResponse{})
+	if err != nil {
+		t.Fatalf("Setattr fail: %s", err)
+	}
+
+	header := fuse.Header{Uid: 4000, Gid: 4000, Pid: uint32(os.Getpid())}
+	if err := file.(fuseFS.NodeAccesser).Access(ctx, &fuse.AccessRequest{Header: header, Mask: 4}); err != nil {
+		t.Errorf("Access of a supplementary group fail.\nExpected: %v. Result: %v.\n", nil, err)
+	}
+	header.Pid = 0
+	if err := file.(fuseFS.NodeAccesser).Access(ctx, &fuse.AccessRequest{Header: header, Mask: 4}); err != fuse.Errno(syscall.EACCES) {
+		t.Errorf("Access without supplementary groups is not failed.\nExpected: %v. Result: %v.\n", fuse.Errno(syscall.EACCES), err)
+	}
+}
+
+func TestFS_DefaultPermissions(t *testing.T) {
+	storage, err := memory.NewStorage()
+	if err != nil {
+		t.Fatalf("NewStorage fail: %s", err)
+	}
+	filesys := &fs.FS{
+		RepositoryRegistry: &memory.RepositoryRegistry{BlockSize: 4, Storage: storage},
+		Options:            fs.Options{DefaultPermissions: true},
+	}
+	root, err := filesys.Root()
+	if err != nil {
+		t.Fatalf("Root fail: %s", err)
+	}
+
+	// The kernel has checked the permissions, so the request of any user is served.
+	ctx := context.Background()
+	_, err = root.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Header: fuse.Header{Uid: 3000, Gid: 3000}, Name: "dir", Mode: os.ModeDir | 0700})
+	if err != nil {
+		t.Errorf("Mkdir fail: %s", err)
+	}
+}
+
+// aclXattr encodes the ACL entries of tag, perm and id in the xattr format of the kernel.
+func aclXattr(entries ...[3]uint32) []byte {
+	data := make([]byte, 4+len(entries)*8)
+	binary.LittleEndian.PutUint32(data, 2)
+	for i, entry := range entries {
+		binary.LittleEndian.PutUint16(data[4+i*8:], uint16(entry[0]))
+		binary.LittleEndian.PutUint16(data[6+i*8:], uint16(entry[1]))
+		binary.LittleEndian.PutUint32(data[8+i*8:], entry[2])
+	}
+
+	return data
+}
+
+func TestFS_ACL(t *testing.T) {
+	const undefined = ^uint32(0)
+	ctx := context.Background()
+	root := createRoot(t)
+	owner := fuse.Header{Uid: 1000, Gid: 1000}
+	named := fuse.Header{Uid: 2000, Gid: 2000}
+	other := fuse.Header{Uid: 3000, Gid: 3000}
+
+	err := root.(fuseFS.NodeSetattrer).Setattr(ctx, &fuse.SetattrRequest{Valid: fuse.SetattrMode, Mode: os.ModeDir | 0777}, &fuse.SetattrResponse{})
+	if err != nil {
+		t.Fatalf("Setattr fail: %s", err)
+	}
+	project, err := root.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Header: owner, Name: "project", Mode: os.ModeDir | 0750})
+	if err != nil {
+		t.Fatalf("Mkdir fail: %s", err)
+	}
+
+	access := aclXattr([3]uint32{0x01, 7, undefined}, [3]uint32{0x02, 7, 2000}, [3]uint32{0x04, 5, undefined}, [3]uint32{0x10, 7, undefined}, [3]uint32{0x20, 0, undefined})
+	defaults := aclXattr([3]uint32{0x01, 7, undefined}, [3]uint32{0x02, 6, 2000}, [3]uint32{0x04, 5, undefined}, [3]uint32{0x10, 7, undefined}, [3]uint32{0x20, 0, undefined})
+	for _, req := range []*fuse.SetxattrRequest{
+		{Header: owner, Name: "system.posix_acl_access", Xattr: access},
+		{Header: owner, Name: "system.posix_acl_default", Xattr: defaults},
+	} {
+		if err := project.(fuseFS.NodeSetxattrer).Setxattr(ctx, req); err != nil {
+			t.Fatalf("Setxattr %s fail: %s", req.Name, err)
+		}
+	}
+
+	resp := &fuse.GetxattrResponse{}
+	if err := project.(fuseFS.NodeGetxattrer).Getxattr(ctx, &fuse.GetxattrRequest{Name: "system.posix_acl_access"}, resp); err != nil || !bytes.Equal(resp.Xattr, access) {
+		t.Errorf("Access ACL does not round-trip: %v.\nExpected: %v. Result: %v.\n", err, access, resp.Xattr)
+	}
+	var attr fuse.Attr
+	if err := project.Attr(ctx, &attr); err != nil || attr.Mode.Perm() != 0770 {
+		t.Errorf("Mode of the ACL is not as expected: %v.\nExpected: %v. Result: %v.\n", err, os.FileMode(0770), attr.Mode.Perm())
+	}
+
+	file, _, err := project.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Header: owner, Name: "file", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	subdir, err := project.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Header: owner, Name: "subdir", Mode: os.ModeDir | 0755})
+	if err != nil {
+		t.Fatalf("Mkdir fail: %s", err)
+	}
+
+	// The kernel clears the bits of the umask from the requested mode, the default ACL overrides the umask.
+	masked, _, err := project.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Header: owner, Name: "masked", Mode: 0600, Umask: 0077, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	maskedDir, err := project.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Header: owner, Name: "maskeddir", Mode: os.ModeDir | 0700, Umask: 0077})
+	if err != nil {
+		t.Fatalf("Mkdir fail: %s", err)
+	}
+	if err := masked.Attr(ctx, &attr); err != nil || attr.Mode.Perm() != 0660 {
+		t.Errorf("Mode of the file created with a umask is not as expected: %v.\nExpected: %v. Result: %v.\n", err, os.FileMode(0660), attr.Mode.Perm())
+	}
+
+	inherited := []struct {
+		node     fuseFS.Node
+		name     string
+		expected []byte
+	}{
+		{file, "system.posix_acl_access", aclXattr([3]uint32{0x01, 6, undefined}, [3]uint32{0x02, 6, 2000}, [3]uint32{0x04, 5, undefined}, [3]uint32{0x10, 4, undefined}, [3]uint32{0x20, 0, undefined})},
+		{subdir, "system.posix_acl_access", aclXattr([3]uint32{0x01, 7, undefined}, [3]uint32{0x02, 6, 2000}, [3]uint32{0x04, 5, undefined}, [3]uint32{0x10, 5, undefined}, [3]uint32{0x20, 0, undefined})},
+		{subdir, "system.posix_acl_default", defaults},
+		{masked, "system.posix_acl_access", aclXattr([3]uint32{0x01, 6, undefined}, [3]uint32{0x02, 6, 2000}, [3]uint32{0x04, 5, undefined}, [3]uint32{0x10, 6, undefined}, [3]uint32{0x20, 0, undefined})},
+		{maskedDir, "system.posix_acl_access", aclXattr([3]uint32{0x01, 7, undefined}, [3]uint32{0x02, 6, 2000}, [3]uint32{0x04, 5, undefined}, [3]uint32{0x10, 7, undefined}, [3]uint32{0x20, 0, undefined})},
+	}
+	for id, test := range inherited {
+		id += 1
+		resp := &fuse.GetxattrResponse{}
+		if err := test.node.(fuseFS.NodeGetxattrer).Getxattr(ctx, &fuse.GetxattrRequest{Name: test.name}, resp); err != nil || !bytes.Equal(resp.Xattr, test.expected) {
+			t.Errorf("Test %v fail: inherited ACL is not as expected: %v.\nExpected: %v. Result: %v.\n", id, err, test.expected, resp.Xattr)
+		}
+	}
+
+	tests := []struct {
+		op       func() error
+		expected error
+	}{
+		{func() error {
+			_, err := project.(fuseFS.NodeRequestLookuper).Lookup(ctx, &fuse.LookupRequest{Header: named, Name: "file"}, &fuse.LookupResponse{})
+			return err
+		}, nil},
+		{func() error {
+			_, err := project.(fuseFS.NodeRequestLookuper).Lookup(ctx, &fuse.LookupRequest{Header: other, Name: "file"}, &fuse.LookupResponse{})
+			return err
+		}, fuse.Errno(syscall.EACCES)},
+		{func() error {
+			return file.(fuseFS.NodeAccesser).Access(ctx, &fuse.AccessRequest{Header: named, Mask: 4})
+		}, nil},
+		{func() error {
+			return file.(fuseFS.NodeAccesser).Access(ctx, &fuse.AccessRequest{Header: named, Mask: 2})
+		}, fuse.Errno(syscall.EACCES)},
+		{func() error {
+			return file.(fuseFS.NodeSetattrer).Setattr(ctx, &fuse.SetattrRequest{Header: owner, Valid: fuse.SetattrMode, Mode: 0660}, &fuse.SetattrResponse{})
+		}, nil},
+		{func() error {
+			return file.(fuseFS.NodeAccesser).Access(ctx, &fuse.AccessRequest{Header: named, Mask: 2})
+		}, nil},
+		{func() error {
+			return file.(fuseFS.NodeSetxattrer).Setxattr(ctx, &fuse.SetxattrRequest{Header: named, Name: "system.posix_acl_access", Xattr: access})
+		}, fuse.EPERM},
+		{func() error {
+			return file.(fuseFS.NodeSetxattrer).Setxattr(ctx, &fuse.SetxattrRequest{Header: owner, Name: "system.posix_acl_default", Xattr: defaults})
+		}, fuse.Errno(syscall.EACCES)},
+		{func() error {
+			return file.(fuseFS.NodeSetxattrer).Setxattr(ctx, &fuse.SetxattrRequest{Header: owner, Name: "system.posix_acl_access", Xattr: access, Flags: xattrCreate})
+		}, fuse.EEXIST},
+		{func() error {
+			invalid := aclXattr([3]uint32{0x01, 7, undefined}, [3]uint32{0x02, 7, 2000}, [3]uint32{0x04, 5, undefined}, [3]uint32{0x20, 0, undefined})
+			return file.(fuseFS.NodeSetxattrer).Setxattr(ctx, &fuse.SetxattrRequest{Header: owner, Name: "system.posix_acl_access", Xattr: invalid})
+		}, fuse.Errno(syscall.EINVAL)},
+		{func() error {
+			minimal := aclXattr([3]uint32{0x01, 6, undefined}, [3]uint32{0x04, 4, undefined}, [3]uint32{0x20, 4, undefined})
+			return file.(fuseFS.NodeSetxattrer).Setxattr(ctx, &fuse.SetxattrRequest{Header: owner, Name: "system.posix_acl_access", Xattr: minimal})
+		}, nil},
+		{func() error {
+			return file.(fuseFS.NodeGetxattrer).Getxattr(ctx, &fuse.GetxattrRequest{Name: "system.posix_acl_access"}, &fuse.GetxattrResponse{})
+		}, fuse.ErrNoXattr},
+		{func() error {
+			return file.(fuseFS.NodeSetxattrer).Setxattr(ctx, &fuse.SetxattrRequest{Header: owner, Name: "system.posix_acl_access", Xattr: access, Flags: xattrReplace})
+		}, fuse.ErrNoXattr},
+		{func() error {
+			return file.(fuseFS.NodeAccesser).Access(ctx, &fuse.AccessRequest{Header: named, Mask: 4})
+		}, nil},
+		{func() error {
+			return file.(fuseFS.NodeAccesser).Access(ctx, &fuse.AccessRequest{Header: named, Mask: 2})
+		}, fuse.Errno(syscall.EACCES)},
+	}
+
+	for id, test := range tests {
+		id += 1
+		if err := test.op(); err != test.expected {
+			t.Errorf("Test %v fail: errno is not as expected.\nExpected: %v. Result: %v.\n", id, test.expected, err)
+		}
+	}
+
+	if err := file.Attr(ctx, &attr); err != nil || attr.Mode.Perm() != 0644 {
+		t.Errorf("Mode of the minimal ACL is not as expected: %v.\nExpected: %v. Result: %v.\n", err, os.FileMode(0644), attr.Mode.Perm())
+	}
+}
+
+// createRootsOfStorage returns the roots of two filesystems with different options over the same storage.
+func createRootsOfStorage(t *testing.T, opts fs.Options, otherOpts fs.Options) (fuseFS.Node, fuseFS.Node) {
+	storage, err := memory.NewStorage()
+	if err != nil {
+		t.Fatalf("NewStorage fail: %s", err)
+	}
+
+	var roots []fuseFS.Node
+	for _, opts := range []fs.Options{opts, otherOpts} {
+		filesys := &fs.FS{RepositoryRegistry: &memory.RepositoryRegistry{BlockSize: 4, Storage: storage}, Options: opts}
+		root, err := filesys.Root()
+		if err != nil {
+			t.Fatalf("Root fail: %s", err)
+		}
+		roots = append(roots, root)
+	}
+
+	return roots[0], roots[1]
+}
+
+func TestFS_ReadOnly(t *testing.T) {
+	ctx := context.Background()
+	writable, root := createRootsOfStorage(t, fs.Options{}, fs.Options{ReadOnly: true})
+	if _, _, err := writable.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "file", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{}); err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	file, err := root.(fuseFS.NodeRequestLookuper).Lookup(ctx, &fuse.LookupRequest{Name: "file"}, &fuse.LookupResponse{})
+	if err != nil {
+		t.Fatalf("Lookup fail: %s", err)
+	}
+
+	tests := []struct {
+		op       func() error
+		expected error
+	}{
+		{func() error {
+			_, err := file.(fuseFS.NodeOpener).Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+			return err
+		}, nil},
+		{func() error {
+			_, err := file.(fuseFS.NodeOpener).Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly}, &fuse.OpenResponse{})
+			return err
+		}, fuse.Errno(syscall.EROFS)},
+		{func() error {
+			_, _, err := root.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "new", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+			return err
+		}, fuse.Errno(syscall.EROFS)},
+		{func() error {
+			_, err := root.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Name: "dir", Mode: os.ModeDir | 0755})
+			return err
+		}, fuse.Errno(syscall.EROFS)},
+		{func() error {
+			_, err := root.(fuseFS.NodeSymlinker).Symlink(ctx, &fuse.SymlinkRequest{NewName: "link", Target: "file"})
+			return err
+		}, fuse.Errno(syscall.EROFS)},
+		{func() error {
+			_, err := root.(fuseFS.NodeLinker).Link(ctx, &fuse.LinkRequest{NewName: "hard"}, file)
+			return err
+		}, fuse.Errno(syscall.EROFS)},
+		{func() error {
+			return root.(fuseFS.NodeRemover).Remove(ctx, &fuse.RemoveRequest{Name: "file"})
+		}, fuse.Errno(syscall.EROFS)},
+		{func() error {
+			return root.(fuseFS.NodeRenamer).Rename(ctx, &fuse.RenameRequest{OldName: "file", NewName: "renamed"}, root)
+		}, fuse.Errno(syscall.EROFS)},
+		{func() error {
+			return file.(fuseFS.NodeSetattrer).Setattr(ctx, &fuse.SetattrRequest{Valid: fuse.SetattrMode, Mode: 0600}, &fuse.SetattrResponse{})
+		}, fuse.Errno(syscall.EROFS)},
+		{func() error {
+			return file.(fuseFS.NodeSetxattrer).Setxattr(ctx, &fuse.SetxattrRequest{Name: "user.tag", Xattr: []byte("1")})
+		}, fuse.Errno(syscall.EROFS)},
+		{func() error {
+			return file.(fuseFS.NodeRemovexattrer).Removexattr(ctx, &fuse.RemovexattrRequest{Name: "user.tag"})
+		}, fuse.Errno(syscall.EROFS)},
+		{func() error {
+			return file.(fuseFS.NodeAccesser).Access(ctx, &fuse.AccessRequest{Mask: 2})
+		}, fuse.Errno(syscall.EROFS)},
+	}
+
+	for id, test := range tests {
+		id += 1
+		if err := test.op(); err != test.expected {
+			t.Errorf("Test %v fail: errno is not as expected.\nExpected: %v. Result: %v.\n", id, test.expected, err)
+		}
+	}
+}
+
+func TestFS_AllowRoot(t *testing.T) {
+	ctx := context.Background()
+	_, root := createRootsOfStorage(t, fs.Options{}, fs.Options{AllowRoot: true})
+
+	// The tests do not mount the filesystem, so its owner is root.
+	tests := []struct {
+		header   fuse.Header
+		expected error
+	}{
+		{fuse.Header{Uid: 0, Gid: 0}, fuse.ENOENT},
+		{fuse.Header{Uid: 1000, Gid: 1000}, fuse.Errno(syscall.EACCES)},
+	}
+
+	for id, test := range tests {
+		id += 1
+		_, err := root.(fuseFS.NodeRequestLookuper).Lookup(ctx, &fuse.LookupRequest{Header: test.header, Name: "missing"}, &fuse.LookupResponse{})
+		if err != test.expected {
+			t.Errorf("Test %v fail: errno is not as expected.\nExpected: %v. Result: %v.\n", id, test.expected, err)
+		}
+	}
+}
+
+func TestFS_OwnerOverrides(t *testing.T) {
+	ctx := context.Background()
+	uid, gid := uint32(1000), uint32(100)
+	writable, root := createRootsOfStorage(t, fs.Options{}, fs.Options{UID: &uid, GID: &gid, Umask: 0027})
+	_, err := writable.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Name: "dir", Mode: os.ModeDir | 0775})
+	if err != nil {
+		t.Fatalf("Mkdir fail: %s", err)
+	}
+
+	dir, err := root.(fuseFS.NodeRequestLookuper).Lookup(ctx, &fuse.LookupRequest{Name: "dir"}, &fuse.LookupResponse{})
+	if err != nil {
+		t.Fatalf("Lookup fail: %s", err)
+	}
+	var attr fuse.Attr
+	if err := dir.Attr(ctx, &attr); err != nil {
+		t.Fatalf("Attr fail: %s", err)
+	}
+	if attr.Uid != uid || attr.Gid != gid || attr.Mode.Perm() != 0750 {
+		t.Errorf("Attributes are not as expected.\nExpected: %v, %v, %v. Result: %v, %v, %v.\n", uid, gid, os.FileMode(0750), attr.Uid, attr.Gid, attr.Mode.Perm())
+	}
+
+	// The forced owner passes the permission checks of the owner.
+	_, err = dir.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Header: fuse.Header{Uid: uid, Gid: gid}, Name: "child", Mode: os.ModeDir | 0755})
+	if err != nil {
+		t.Errorf("Mkdir of the forced owner fail: %s", err)
+	}
+}
+
+func TestFS_Statfs(t *testing.T) {
+	storage, err := memory.NewStorage()
+	if err != nil {
+		t.Fatalf("NewStorage fail: %s", err)
+	}
+	registry := &memory.RepositoryRegistry{BlockSize: 4, Storage: storage}
+	filesys := &fs.FS{
+		RepositoryRegistry: registry,
+		Options:            fs.Options{Limits: db.VolumeLimits{Capacity: 1 << 20, MaxInodes: 100}, StatfsTTL: time.Minute},
+	}
+	root, err := filesys.Root()
+	if err != nil {
+		t.Fatalf("Root fail: %s", err)
+	}
+
+	ctx := context.Background()
+	_, handle, err := root.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "file", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	if err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	if err = handle.(fuseFS.HandleWriter).Write(ctx, &fuse.WriteRequest{Data: make([]byte, 5000)}, &fuse.WriteResponse{}); err != nil {
+		t.Fatalf("Write fail: %s", err)
+	}
+
+	resp := &fuse.StatfsResponse{}
+	if err = filesys.Statfs(ctx, &fuse.StatfsRequest{}, resp); err != nil {
+		t.Fatalf("Statfs fail: %s", err)
+	}
+	expected := fuse.StatfsResponse{Blocks: 256, Bfree: 254, Bavail: 254, Files: 100, Ffree: 98, Bsize: 4096, Namelen: 255, Frsize: 4096}
+	if *resp != expected {
+		t.Errorf("Statfs is not as expected.\nExpected: %+v. Result: %+v.\n", expected, *resp)
+	}
+
+	// The writes of the mount are counted in the cached usage, the changes of other mounts are not.
+	if err = handle.(fuseFS.HandleWriter).Write(ctx, &fuse.WriteRequest{Offset: 5000, Data: make([]byte, 5000)}, &fuse.WriteResponse{}); err != nil {
+		t.Fatalf("Write fail: %s", err)
+	}
+	otherRoot, err := (&fs.FS{RepositoryRegistry: registry}).Root()
+	if err != nil {
+		t.Fatalf("Root fail: %s", err)
+	}
+	if _, _, err = otherRoot.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "other", Mode: 0644}, &fuse.CreateResponse{}); err != nil {
+		t.Fatalf("Create fail: %s", err)
+	}
+	cached := &fuse.StatfsResponse{}
+	expected.Bfree, expected.Bavail = 253, 253
+	if err = filesys.Statfs(ctx, &fuse.StatfsRequest{}, cached); err != nil || *cached != expected {
+		t.Errorf("Cached statfs is not as expected.\nExpected: %+v. Result: %+v.\n", expected, *cached)
+	}
+}
+
+func TestFS_Limits(t *testing.T) {
+	ctx := context.Background()
+	root, _ := createRootsOfStorage(t, fs.Options{Limits: db.VolumeLimits{Capacity: 10, MaxInodes: 3}, StatfsTTL: time.Minute}, fs.Options{})
+
+	var handle fuseFS.Handle
+	write := func(offset int64, size int) error {
+		return handle.(fuseFS.HandleWriter).Write(ctx, &fuse.WriteRequest{Offset: offset, Data: make([]byte, size)}, &fuse.WriteResponse{})
+	}
+	var file fuseFS.Node
+	setSize := func(size uint64) error {
+		return file.(fuseFS.NodeSetattrer).Setattr(ctx, &fuse.SetattrRequest{Valid: fuse.SetattrSize, Size: size}, &fuse.SetattrResponse{})
+	}
+	enospc := fuse.Errno(syscall.ENOSPC)
+
+	tests := []struct {
+		op          func() error
+		expectedErr error
+	}{
+		{func() error {
+			_, err := root.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Name: "dir", Mode: os.ModeDir | 0755})
+			return err
+		}, nil},
+		{func() (err error) {
+			file, handle, err = root.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "file", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+			return err
+		}, nil},
+		{func() error {
+			_, _, err := root.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "file2", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+			return err
+		}, enospc},
+		{func() error {
+			_, err := root.(fuseFS.NodeMkdirer).Mkdir(ctx, &fuse.MkdirRequest{Name: "dir2", Mode: os.ModeDir | 0755})
+			return err
+		}, enospc},
+		{func() error {
+			_, err := root.(fuseFS.NodeSymlinker).Symlink(ctx, &fuse.SymlinkRequest{NewName: "symlink", Target: "file"})
+			return err
+		}, enospc},
+		{func() error { return write(0, 8) }, nil},
+		{func() error { return write(0, 8) }, nil},
+		{func() error { return write(8, 3) }, enospc},
+		{func() error { return write(8, 2) }, nil},
+		{func() error { return write(10, 1) }, enospc},
+		{func() error { return setSize(11) }, enospc},
+		{func() error { return setSize(10) }, nil},
+		{func() error { return setSize(4) }, nil},
+	}
+
+	for id, test := range tests {
+		id += 1
+		if err := test.op(); err != test.expectedErr {
+			t.Errorf("Test %v fail: error is not as expected.\nExpected: %v. Result: %v.\n", id, test.expectedErr, err)
+		}
+	}
+
+	limited, other := createRootsOfStorage(t, fs.Options{Limits: db.VolumeLimits{MaxInodes: 2}}, fs.Options{})
+	for _, name := range []string{"file", "file2"} {
+		if _, _, err := other.(fuseFS.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: name, Mode: 0644}, &fuse.CreateResponse{}); err != nil {
+			t.Fatalf("Create fail: %s", err)
+		}
+	}
+	file, err := limited.(fuseFS.NodeRequestLookuper).Lookup(ctx, &fuse.LookupRequest{Name: "file"}, &fuse.LookupResponse{})
+	if err != nil {
+		t.Fatalf("Lookup fail: %s", err)
+	}
+	if _, err = limited.(fuseFS.NodeLinker).Link(ctx, &fuse.LinkRequest{NewName: "link"}, file); err != enospc {
+		t.Errorf("Link on a volume over its limits error is not as expected.\nExpected: %v. Result: %v.\n", enospc, err)
+	}
+}
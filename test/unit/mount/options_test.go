@@ -0,0 +1,104 @@
+/*
+   Copyright 2021 The DbunderFS Contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mount
+
+import (
+	"github.com/kos-v/dbunderfs/internal/mount"
+	"github.com/spf13/cobra"
+	"testing"
+	"time"
+)
+
+type mountFlags struct {
+	allowOther bool
+	atime      string
+	cacheTTL   time.Duration
+	readOnly   bool
+	uid        int64
+	umask      string
+}
+
+// createCommand returns a command with the kinds of flags the mount command has.
+func createCommand() (*cobra.Command, *mountFlags) {
+	flags := &mountFlags{}
+	cmd := &cobra.Command{Use: "mount"}
+	cmd.Flags().BoolVar(&flags.allowOther, "allow-other", false, "")
+	cmd.Flags().StringVar(&flags.atime, "atime", "relatime", "")
+	cmd.Flags().DurationVar(&flags.cacheTTL, "cache-ttl", time.Second, "")
+	cmd.Flags().BoolVar(&flags.readOnly, "read-only", false, "")
+	cmd.Flags().Int64Var(&flags.uid, "uid", -1, "")
+	cmd.Flags().StringVar(&flags.umask, "umask", "0", "")
+	cmd.Flags().StringArrayP("options", "o", nil, "")
+	cmd.Flags().BoolP("sloppy", "s", false, "")
+	_ = cmd.Flags().MarkHidden("sloppy")
+
+	return cmd, flags
+}
+
+func TestApplyOptions(t *testing.T) {
+	defaults := mountFlags{atime: "relatime", cacheTTL: time.Second, uid: -1, umask: "0"}
+	with := func(change func(flags *mountFlags)) mountFlags {
+		flags := defaults
+		change(&flags)
+		return flags
+	}
+
+	tests := []struct {
+		options       []string
+		sloppy        bool
+		expectedFlags mountFlags
+		expectedErr   bool
+	}{
+		{nil, false, defaults, false},
+		{[]string{"ro"}, false, with(func(f *mountFlags) { f.readOnly = true }), false},
+		{[]string{"ro,rw"}, false, defaults, false},
+		{[]string{"rw", "ro"}, false, with(func(f *mountFlags) { f.readOnly = true }), false},
+		{[]string{"noatime"}, false, with(func(f *mountFlags) { f.atime = "noatime" }), false},
+		{[]string{"strictatime"}, false, with(func(f *mountFlags) { f.atime = "strict" }), false},
+		{[]string{"noatime,relatime"}, false, defaults, false},
+		{[]string{"atime=noatime"}, false, with(func(f *mountFlags) { f.atime = "noatime" }), false},
+		{[]string{"uid=1000,umask=022"}, false, with(func(f *mountFlags) { f.uid, f.umask = 1000, "022" }), false},
+		{[]string{"uid=abc"}, false, defaults, true},
+		{[]string{"uid"}, false, defaults, true},
+		{[]string{"cache_ttl=5s"}, false, with(func(f *mountFlags) { f.cacheTTL = 5 * time.Second }), false},
+		{[]string{"allow_other"}, false, with(func(f *mountFlags) { f.allowOther = true }), false},
+		{[]string{"allow-other=false"}, false, defaults, false},
+		{[]string{"allow_other=maybe"}, false, defaults, true},
+		{[]string{"defaults,noauto,nofail,_netdev,nosuid,nodev"}, false, defaults, false},
+		{[]string{"x-systemd.automount,x-systemd.idle-timeout=60"}, false, defaults, false},
+		{[]string{"ro,,"}, false, with(func(f *mountFlags) { f.readOnly = true }), false},
+		{[]string{"unknown"}, false, defaults, true},
+		{[]string{"unknown,ro"}, true, with(func(f *mountFlags) { f.readOnly = true }), false},
+		{[]string{"sloppy"}, false, defaults, true},
+		{[]string{"options=ro"}, false, defaults, true},
+		{[]string{"help"}, false, defaults, true},
+		{[]string{"options=ro,help"}, true, defaults, false},
+	}
+
+	for id, test := range tests {
+		id += 1
+		cmd, flags := createCommand()
+		err := mount.ApplyOptions(cmd, test.options, test.sloppy)
+		if (err != nil) != test.expectedErr {
+			t.Errorf("Test %v fail: error is not as expected.\nExpected: %v. Result: %v.\n", id, test.expectedErr, err)
+			continue
+		}
+		if err == nil && *flags != test.expectedFlags {
+			t.Errorf("Test %v fail: flags are not as expected.\nExpected: %+v. Result: %+v.\n", id, test.expectedFlags, *flags)
+		}
+	}
+}